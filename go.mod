@@ -3,6 +3,7 @@ module github.com/patrickdk77/aws-s3-proxy
 go 1.26
 
 require (
+	github.com/andybalholm/brotli v1.2.0
 	github.com/aws/aws-sdk-go-v2 v1.43.4
 	github.com/aws/aws-sdk-go-v2/config v1.32.35
 	github.com/aws/aws-sdk-go-v2/service/s3 v1.107.0
@@ -10,6 +11,7 @@ require (
 	github.com/go-openapi/swag/typeutils v0.28.0
 	github.com/golang-jwt/jwt/v4 v4.5.2
 	github.com/karlseguin/ccache/v3 v3.0.8
+	github.com/klauspost/compress v1.19.1
 	github.com/prometheus/client_golang v1.24.1
 	github.com/stretchr/testify v1.11.1
 )
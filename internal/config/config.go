@@ -14,7 +14,7 @@ import (
 
 // Config represents its configurations
 var (
-	Config          *config
+	Config          *Settings
 	AccessLog       *log.Logger
 	AccessLogWriter *logwriter.Writer
 )
@@ -23,58 +23,93 @@ func init() {
 	Setup()
 }
 
-type config struct { // nolint
-	AwsRegion            string        // AWS_REGION
-	AwsAPIEndpoint       string        // AWS_API_ENDPOINT
-	S3Bucket             string        // AWS_S3_BUCKET
-	S3KeyPrefix          string        // AWS_S3_KEY_PREFIX
-	IndexDocument        string        // INDEX_DOCUMENT
-	DirectoryListing     bool          // DIRECTORY_LISTINGS
-	DirListingFormat     string        // DIRECTORY_LISTINGS_FORMAT
-	DirListingCheckIndex bool          // DIRECTORY_LISTINGS_CHECK_INDEX
-	HTTPCacheControl     string        // HTTP_CACHE_CONTROL (max-age=86400, no-cache ...)
-	HTTPExpires          string        // HTTP_EXPIRES (Thu, 01 Dec 1994 16:00:00 GMT ...)
-	BasicAuthUser        []string      // BASIC_AUTH_USER
-	BasicAuthPass        []string      // BASIC_AUTH_PASS
-	Port                 string        // APP_PORT
-	Host                 string        // APP_HOST
-	AccessLog            bool          // ACCESS_LOG
-	ForwardedFor         string        // FORWARDED_FOR
-	SslCert              string        // SSL_CERT_PATH
-	SslKey               string        // SSL_KEY_PATH
-	StripPath            string        // STRIP_PATH
-	ContentEncoding      bool          // CONTENT_ENCODING
-	CorsAllowOrigin      string        // CORS_ALLOW_ORIGIN
-	CorsAllowMethods     string        // CORS_ALLOW_METHODS
-	CorsAllowHeaders     string        // CORS_ALLOW_HEADERS
-	CorsMaxAge           int64         // CORS_MAX_AGE
-	HealthCheckPath      string        // HEALTHCHECK_PATH
-	MetricsPath          string        // METRICS_PATH
-	VersionPath          string        // VERSION_PATH
-	AllPagesInDir        bool          // GET_ALL_PAGES_IN_DIR
-	MaxIdleConns         int           // MAX_IDLE_CONNECTIONS
-	IdleConnTimeout      time.Duration // IDLE_CONNECTION_TIMEOUT
-	DisableCompression   bool          // DISABLE_COMPRESSION
-	InsecureTLS          bool          // Disables TLS validation on request endpoints.
-	JwtSecretKey         string        // JWT_SECRET_KEY
-	JwtUserField         string        // JWT_USER_FIELD
-	JwtHeader            string        // JWT_HEADER
-	SPA                  bool          // SPA
-	WhiteListIPRanges    []*net.IPNet  // WHITELIST_IP_RANGES is commma separated list of IP's and IP ranges. Needs parsing.
-	ContentType          string        // Override default Content-Type
-	ContentDisposition   string        // Override default Content-Disposition
-	UsernameHeader       string        // Username Header Cf-Access-Authenticated-User-Email
-	SortDateAsc          bool          // Sort by Date Asc
-	SortDateDesc         bool          // Sort by Date Desc
-	SortFileAsc          bool          // Sort by File Asc
-	SortFileDesc         bool          // Sort by File Desc
-	SortNumeric          bool          // Sort by Numeric Filenames
-	TimeoutRead          time.Duration // Timeout on reads (uploads)
-	TimeoutWrite         time.Duration // Timeout on writes (downloads)
-	CacheSize            int64         // CACHE_SIZE
-	CacheTTL             time.Duration // CACHE_TTL
-	CacheTTLIndex        time.Duration // CACHE_TTL_INDEX
-	CacheMaxFileSize     int64         // CACHE_MAX_FILE_SIZE
+// Settings holds the proxy configuration, Config is built from the environment
+// and routes derive per request copies of it
+type Settings struct {
+	AwsRegion            string         // AWS_REGION
+	AwsAPIEndpoint       string         // AWS_API_ENDPOINT
+	S3Bucket             string         // AWS_S3_BUCKET
+	S3KeyPrefix          string         // AWS_S3_KEY_PREFIX
+	IndexDocument        string         // INDEX_DOCUMENT
+	DirectoryListing     bool           // DIRECTORY_LISTINGS
+	DirListingFormat     string         // DIRECTORY_LISTINGS_FORMAT
+	DirListingCheckIndex bool           // DIRECTORY_LISTINGS_CHECK_INDEX
+	DirListingTemplate   string         // DIRECTORY_LISTINGS_TEMPLATE
+	DirListingStream     bool           // DIRECTORY_LISTINGS_STREAM
+	DirListingRecursive  bool           // DIRECTORY_LISTINGS_RECURSIVE
+	DirListingMaxKeys    int            // DIRECTORY_LISTINGS_MAX_KEYS
+	DirListingArchive    bool           // DIRECTORY_LISTINGS_ARCHIVE
+	ArchiveMaxFiles      int            // ARCHIVE_MAX_FILES
+	ArchiveMaxSize       int64          // ARCHIVE_MAX_SIZE
+	ZipMembers           bool           // ZIP_MEMBERS
+	Precompressed        []string       // PRECOMPRESSED
+	HTTPCacheControl     string         // HTTP_CACHE_CONTROL (max-age=86400, no-cache ...)
+	HTTPExpires          string         // HTTP_EXPIRES (Thu, 01 Dec 1994 16:00:00 GMT ...)
+	BasicAuthUser        []string       // BASIC_AUTH_USER
+	BasicAuthPass        []string       // BASIC_AUTH_PASS
+	Port                 string         // APP_PORT
+	Host                 string         // APP_HOST
+	AccessLog            bool           // ACCESS_LOG
+	ForwardedFor         string         // FORWARDED_FOR
+	SslCert              string         // SSL_CERT_PATH
+	SslKey               string         // SSL_KEY_PATH
+	StripPath            string         // STRIP_PATH
+	ContentEncoding      bool           // CONTENT_ENCODING
+	CompressionEncodings []string       // COMPRESSION_ENCODINGS
+	CompressionLevels    map[string]int // COMPRESSION_LEVELS
+	CompressionMinSize   int64          // COMPRESSION_MIN_SIZE
+	CompressionTypes     []string       // COMPRESSION_TYPES
+	CompressionSkipTypes []string       // COMPRESSION_SKIP_TYPES
+	CorsAllowOrigin      string         // CORS_ALLOW_ORIGIN
+	CorsAllowMethods     string         // CORS_ALLOW_METHODS
+	CorsAllowHeaders     string         // CORS_ALLOW_HEADERS
+	CorsMaxAge           int64          // CORS_MAX_AGE
+	HealthCheckPath      string         // HEALTHCHECK_PATH
+	MetricsPath          string         // METRICS_PATH
+	VersionPath          string         // VERSION_PATH
+	AllPagesInDir        bool           // GET_ALL_PAGES_IN_DIR
+	MaxIdleConns         int            // MAX_IDLE_CONNECTIONS
+	IdleConnTimeout      time.Duration  // IDLE_CONNECTION_TIMEOUT
+	DisableCompression   bool           // DISABLE_COMPRESSION
+	InsecureTLS          bool           // Disables TLS validation on request endpoints.
+	JwtSecretKey         string         // JWT_SECRET_KEY
+	JwtUserField         string         // JWT_USER_FIELD
+	JwtHeader            string         // JWT_HEADER
+	SPA                  bool           // SPA
+	WhiteListIPRanges    []*net.IPNet   // WHITELIST_IP_RANGES is commma separated list of IP's and IP ranges. Needs parsing.
+	ContentType          string         // Override default Content-Type
+	ContentDisposition   string         // Override default Content-Disposition
+	UsernameHeader       string         // Username Header Cf-Access-Authenticated-User-Email
+	SortDateAsc          bool           // Sort by Date Asc
+	SortDateDesc         bool           // Sort by Date Desc
+	SortFileAsc          bool           // Sort by File Asc
+	SortFileDesc         bool           // Sort by File Desc
+	SortNumeric          bool           // Sort by Numeric Filenames
+	TimeoutRead          time.Duration  // Timeout on reads (uploads)
+	TimeoutWrite         time.Duration  // Timeout on writes (downloads)
+	CacheSize            int64          // CACHE_SIZE
+	CacheTTL             time.Duration  // CACHE_TTL
+	CacheTTLIndex        time.Duration  // CACHE_TTL_INDEX
+	CacheMaxFileSize     int64          // CACHE_MAX_FILE_SIZE
+	WriteEnabled         bool           // WRITE_ENABLED
+	WriteUsers           []string       // WRITE_USERS
+	UploadPartSize       int64          // UPLOAD_PART_SIZE
+	UploadMaxSize        int64          // UPLOAD_MAX_SIZE
+	DirListingUpload     bool           // DIRECTORY_LISTINGS_UPLOAD
+	UploadExtensions     []string       // UPLOAD_ALLOWED_EXTENSIONS
+	Routes               []*Route       // ROUTES_FILE
+	ErrorDocuments       map[int]string // ERROR_DOCUMENTS
+	RoutingRulesFile     string         // ROUTING_RULES
+	Manifests            bool           // NETLIFY_MANIFESTS
+	ManifestTTL          time.Duration  // MANIFEST_TTL
+	HeaderRules          []*HeaderRule  // HEADER_RULES
+	SymlinkMaxHops       int            // SYMLINK_MAX_HOPS
+	SymlinkBuckets       []string       // SYMLINK_BUCKETS
+	SymlinkCacheTTL      time.Duration  // SYMLINK_CACHE_TTL
+	SymlinkDebug         bool           // SYMLINK_DEBUG
+	LatestName           string         // LATEST_NAME
+	LatestOrder          string         // LATEST_ORDER
+	LatestRedirect       bool           // LATEST_REDIRECT
 }
 
 // Setup configurations with environment variables
@@ -107,6 +142,30 @@ func Setup() {
 	if b, err := strconv.ParseBool(os.Getenv("CONTENT_ENCODING")); err == nil {
 		contentEncoding = b
 	}
+	compressionEncodings := []string{"br", "zstd", "gzip", "deflate"}
+	if encodings := os.Getenv("COMPRESSION_ENCODINGS"); encodings != "" {
+		compressionEncodings = []string{}
+		for _, encoding := range strings.Split(encodings, ",") {
+			switch encoding = strings.ToLower(strings.TrimSpace(encoding)); encoding {
+			case "":
+			case "br", "zstd", "gzip", "deflate":
+				compressionEncodings = append(compressionEncodings, encoding)
+			default:
+				log.Fatalf("COMPRESSION_ENCODINGS: unknown encoding %q, expected br, zstd, gzip or deflate", encoding)
+			}
+		}
+	}
+	compressionMinSize := int64(1024)
+	if b, err := strconv.ParseInt(os.Getenv("COMPRESSION_MIN_SIZE"), 10, 64); err == nil {
+		compressionMinSize = b
+	}
+	compressionTypes := []string{"text/*", "application/json", "application/*+json", "application/x-ndjson",
+		"application/javascript", "application/xml", "application/*+xml", "image/svg+xml", "application/wasm",
+		"font/ttf", "font/otf", "application/vnd.ms-fontobject"}
+	if types := os.Getenv("COMPRESSION_TYPES"); types != "" {
+		compressionTypes = splitMediaTypes(types)
+	}
+	compressionSkipTypes := splitMediaTypes(os.Getenv("COMPRESSION_SKIP_TYPES"))
 	corsMaxAge := int64(600)
 	if i, err := strconv.ParseInt(os.Getenv("CORS_MAX_AGE"), 10, 64); err == nil {
 		corsMaxAge = i
@@ -135,30 +194,7 @@ func Setup() {
 	if b, err := strconv.ParseBool(os.Getenv("SPA")); err == nil {
 		SPA = b
 	}
-	sortDateDesc := false
-	sortDateAsc := false
-	sortFileAsc := true
-	sortFileDesc := false
-	sortNumeric := false
-	if s := os.Getenv("SORT"); len(s) > 0 {
-		switch s {
-		case "datedesc":
-			sortDateDesc = true
-			sortFileAsc = false
-		case "filedesc":
-			sortFileDesc = true
-			sortFileAsc = false
-		case "dateasc":
-			sortDateAsc = true
-			sortFileAsc = false
-		case "numberdesc":
-			sortFileDesc = false
-			sortFileAsc = false
-			sortNumeric = true
-		case "numberasc":
-			sortNumeric = true
-		}
-	}
+	sortDateAsc, sortDateDesc, sortFileAsc, sortFileDesc, sortNumeric := parseSort(os.Getenv("SORT"))
 	timeoutRead := time.Duration(60) * time.Second
 	if b, err := strconv.ParseInt(os.Getenv("POST_TIMEOUT"), 10, 64); err == nil {
 		timeoutRead = time.Duration(b) * time.Second
@@ -183,6 +219,78 @@ func Setup() {
 	if b, err := strconv.ParseInt(os.Getenv("CACHE_MAX_FILE_SIZE"), 10, 64); err == nil {
 		cacheMaxFileSize = b * 1024 * 1024
 	}
+	writeEnabled := false
+	if b, err := strconv.ParseBool(os.Getenv("WRITE_ENABLED")); err == nil {
+		writeEnabled = b
+	}
+	dirListingUpload := false
+	if b, err := strconv.ParseBool(os.Getenv("DIRECTORY_LISTINGS_UPLOAD")); err == nil {
+		dirListingUpload = b
+	}
+	dirListingStream := false
+	if b, err := strconv.ParseBool(os.Getenv("DIRECTORY_LISTINGS_STREAM")); err == nil {
+		dirListingStream = b
+	}
+	dirListingRecursive := false
+	if b, err := strconv.ParseBool(os.Getenv("DIRECTORY_LISTINGS_RECURSIVE")); err == nil {
+		dirListingRecursive = b
+	}
+	dirListingMaxKeys := 10000
+	if b, err := strconv.Atoi(os.Getenv("DIRECTORY_LISTINGS_MAX_KEYS")); err == nil && b > 0 {
+		dirListingMaxKeys = b
+	}
+	dirListingArchive := false
+	if b, err := strconv.ParseBool(os.Getenv("DIRECTORY_LISTINGS_ARCHIVE")); err == nil {
+		dirListingArchive = b
+	}
+	archiveMaxFiles := 1000
+	if b, err := strconv.Atoi(os.Getenv("ARCHIVE_MAX_FILES")); err == nil && b > 0 {
+		archiveMaxFiles = b
+	}
+	archiveMaxSize := int64(1024 * 1024 * 1024)
+	if b, err := strconv.ParseInt(os.Getenv("ARCHIVE_MAX_SIZE"), 10, 64); err == nil && b > 0 {
+		archiveMaxSize = b * 1024 * 1024
+	}
+	zipMembers := false
+	if b, err := strconv.ParseBool(os.Getenv("ZIP_MEMBERS")); err == nil {
+		zipMembers = b
+	}
+	manifests := false
+	if b, err := strconv.ParseBool(os.Getenv("NETLIFY_MANIFESTS")); err == nil {
+		manifests = b
+	}
+	manifestTTL := time.Duration(60) * time.Second
+	if b, err := strconv.ParseInt(os.Getenv("MANIFEST_TTL"), 10, 64); err == nil {
+		manifestTTL = time.Duration(b) * time.Second
+	}
+	symlinkMaxHops := 8
+	if b, err := strconv.Atoi(os.Getenv("SYMLINK_MAX_HOPS")); err == nil {
+		symlinkMaxHops = b
+	}
+	symlinkCacheTTL := time.Duration(60) * time.Second
+	if b, err := strconv.ParseInt(os.Getenv("SYMLINK_CACHE_TTL"), 10, 64); err == nil {
+		symlinkCacheTTL = time.Duration(b) * time.Second
+	}
+	symlinkDebug := false
+	if b, err := strconv.ParseBool(os.Getenv("SYMLINK_DEBUG")); err == nil {
+		symlinkDebug = b
+	}
+	latestOrder := "version"
+	if order := os.Getenv("LATEST_ORDER"); len(order) != 0 {
+		latestOrder = order
+	}
+	latestRedirect := false
+	if b, err := strconv.ParseBool(os.Getenv("LATEST_REDIRECT")); err == nil {
+		latestRedirect = b
+	}
+	uploadPartSize := int64(8 * 1024 * 1024)
+	if b, err := strconv.ParseInt(os.Getenv("UPLOAD_PART_SIZE"), 10, 64); err == nil {
+		uploadPartSize = b * 1024 * 1024
+	}
+	uploadMaxSize := int64(0)
+	if b, err := strconv.ParseInt(os.Getenv("UPLOAD_MAX_SIZE"), 10, 64); err == nil {
+		uploadMaxSize = b * 1024 * 1024
+	}
 
 	whiteListIPRanges := []*net.IPNet{}
 	var err error
@@ -193,18 +301,74 @@ func Setup() {
 			log.Fatalf("%v", err)
 		}
 	}
+	routes := []*Route{}
+	if routesFile := os.Getenv("ROUTES_FILE"); len(routesFile) != 0 {
+		routes, err = loadRoutes(routesFile)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+	errorDocuments, err := parseErrorDocuments(os.Getenv("ERROR_DOCUMENTS"))
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	compressionLevels, err := parseCompressionLevels(os.Getenv("COMPRESSION_LEVELS"))
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	headerRules := []*HeaderRule{}
+	if headerRulesFile := os.Getenv("HEADER_RULES"); len(headerRulesFile) != 0 {
+		headerRules, err = loadHeaderRules(headerRulesFile)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
 	usernames := []string{}
 	username := os.Getenv("BASIC_AUTH_USER")
 	if username != "" {
 		usernames = strings.Split(username, " ")
 	}
+	writeUsers := []string{}
+	if users := os.Getenv("WRITE_USERS"); users != "" {
+		writeUsers = strings.Split(users, " ")
+	}
+	uploadExtensions := []string{}
+	if extensions := os.Getenv("UPLOAD_ALLOWED_EXTENSIONS"); extensions != "" {
+		for _, extension := range strings.Split(extensions, ",") {
+			extension = strings.ToLower(strings.TrimSpace(extension))
+			if len(extension) > 0 && !strings.HasPrefix(extension, ".") {
+				extension = "." + extension
+			}
+			uploadExtensions = append(uploadExtensions, extension)
+		}
+	}
+	symlinkBuckets := []string{}
+	if buckets := os.Getenv("SYMLINK_BUCKETS"); buckets != "" {
+		for _, bucket := range strings.Split(buckets, ",") {
+			if bucket = strings.TrimSpace(bucket); len(bucket) > 0 {
+				symlinkBuckets = append(symlinkBuckets, bucket)
+			}
+		}
+	}
+	precompressed := []string{}
+	if extensions := os.Getenv("PRECOMPRESSED"); extensions != "" {
+		for _, ext := range strings.Split(extensions, ",") {
+			switch ext = strings.ToLower(strings.TrimSpace(ext)); ext {
+			case "":
+			case "br", "zst", "gz":
+				precompressed = append(precompressed, ext)
+			default:
+				log.Fatalf("PRECOMPRESSED: unknown extension %q, expected br, zst or gz", ext)
+			}
+		}
+	}
 	passwords := []string{}
 	password := os.Getenv("BASIC_AUTH_PASS")
 	if password != "" {
 		passwords = strings.Split(password, " ")
 	}
 
-	Config = &config{
+	Config = &Settings{
 		AwsRegion:            region,
 		AwsAPIEndpoint:       os.Getenv("AWS_API_ENDPOINT"),
 		S3Bucket:             os.Getenv("AWS_S3_BUCKET"),
@@ -213,6 +377,15 @@ func Setup() {
 		DirectoryListing:     directoryListings,
 		DirListingCheckIndex: directoryListingsCheckIndex,
 		DirListingFormat:     os.Getenv("DIRECTORY_LISTINGS_FORMAT"),
+		DirListingTemplate:   os.Getenv("DIRECTORY_LISTINGS_TEMPLATE"),
+		DirListingStream:     dirListingStream,
+		DirListingRecursive:  dirListingRecursive,
+		DirListingMaxKeys:    dirListingMaxKeys,
+		DirListingArchive:    dirListingArchive,
+		ArchiveMaxFiles:      archiveMaxFiles,
+		ArchiveMaxSize:       archiveMaxSize,
+		ZipMembers:           zipMembers,
+		Precompressed:        precompressed,
 		HTTPCacheControl:     os.Getenv("HTTP_CACHE_CONTROL"),
 		HTTPExpires:          os.Getenv("HTTP_EXPIRES"),
 		BasicAuthUser:        usernames,
@@ -225,6 +398,11 @@ func Setup() {
 		SslKey:               os.Getenv("SSL_KEY_PATH"),
 		StripPath:            os.Getenv("STRIP_PATH"),
 		ContentEncoding:      contentEncoding,
+		CompressionEncodings: compressionEncodings,
+		CompressionLevels:    compressionLevels,
+		CompressionMinSize:   compressionMinSize,
+		CompressionTypes:     compressionTypes,
+		CompressionSkipTypes: compressionSkipTypes,
 		CorsAllowOrigin:      os.Getenv("CORS_ALLOW_ORIGIN"),
 		CorsAllowMethods:     os.Getenv("CORS_ALLOW_METHODS"),
 		CorsAllowHeaders:     os.Getenv("CORS_ALLOW_HEADERS"),
@@ -256,6 +434,28 @@ func Setup() {
 		CacheTTL:             cacheTTL,
 		CacheTTLIndex:        cacheTTLIndex,
 		CacheMaxFileSize:     cacheMaxFileSize,
+		WriteEnabled:         writeEnabled,
+		WriteUsers:           writeUsers,
+		UploadPartSize:       uploadPartSize,
+		UploadMaxSize:        uploadMaxSize,
+		DirListingUpload:     dirListingUpload,
+		UploadExtensions:     uploadExtensions,
+		Routes:               routes,
+		ErrorDocuments:       errorDocuments,
+		RoutingRulesFile:     os.Getenv("ROUTING_RULES"),
+		Manifests:            manifests,
+		ManifestTTL:          manifestTTL,
+		HeaderRules:          headerRules,
+		SymlinkMaxHops:       symlinkMaxHops,
+		SymlinkBuckets:       symlinkBuckets,
+		SymlinkCacheTTL:      symlinkCacheTTL,
+		SymlinkDebug:         symlinkDebug,
+		LatestName:           os.Getenv("LATEST_NAME"),
+		LatestOrder:          latestOrder,
+		LatestRedirect:       latestRedirect,
+	}
+	if err := LoadRoutingRules(); err != nil {
+		log.Fatalf("%v", err)
 	}
 
 	// Proxy
@@ -270,6 +470,21 @@ func Setup() {
 	if (len(Config.BasicAuthUser) > 0) && (len(Config.BasicAuthPass) > 0) {
 		log.Printf("[config] Basic authentication: %s", Config.BasicAuthUser)
 	}
+	// Routes
+	for _, route := range Config.Routes {
+		log.Printf("[config] Route %s to %s", route.Host+route.Path, route.S3Bucket+"/"+route.S3KeyPrefix)
+	}
+	// Redirects like S3 static website hosting
+	if len(Config.RoutingRulesFile) > 0 {
+		log.Printf("[config] Routing rules: %d", len(RoutingRules()))
+	}
+	// Uploads and deletes
+	if Config.WriteEnabled {
+		log.Printf("[config] Writes enabled for: %s", Config.WriteUsers)
+		if len(Config.BasicAuthUser) == 0 && len(Config.JwtSecretKey) == 0 {
+			log.Printf("[config] WRITE_ENABLED without BASIC_AUTH_USER or JWT_SECRET_KEY, every write is refused")
+		}
+	}
 	// CORS
 	if (len(Config.CorsAllowOrigin) > 0) && (Config.CorsMaxAge > 0) {
 		log.Printf("[config] CORS enabled: %s", Config.CorsAllowOrigin)
@@ -302,6 +517,91 @@ func FlushAccessLog() {
 	}
 }
 
+// sortModes are the values of SORT, anything else sorts by file name
+var sortModes = map[string]struct{}{
+	"datedesc": {}, "filedesc": {}, "dateasc": {}, "numberdesc": {}, "numberasc": {},
+}
+
+// parseSort returns the sort flags for a SORT value
+func parseSort(s string) (dateAsc, dateDesc, fileAsc, fileDesc, numeric bool) {
+	fileAsc = true
+	switch s {
+	case "datedesc":
+		dateDesc = true
+		fileAsc = false
+	case "filedesc":
+		fileDesc = true
+		fileAsc = false
+	case "dateasc":
+		dateAsc = true
+		fileAsc = false
+	case "numberdesc":
+		fileAsc = false
+		numeric = true
+	case "numberasc":
+		numeric = true
+	}
+	return
+}
+
+// parseErrorDocuments parses a comma separated list of status=key pairs, like 404=404.html,403=errors/403.html
+func parseErrorDocuments(s string) (map[int]string, error) {
+	errorDocuments := map[int]string{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if len(pair) == 0 {
+			continue
+		}
+		status, key, ok := strings.Cut(pair, "=")
+		code, err := strconv.Atoi(strings.TrimSpace(status))
+		key = strings.TrimSpace(key)
+		if !ok || err != nil || code < 400 || code > 599 || len(key) == 0 {
+			return nil, fmt.Errorf("[config] invalid error document '%s' in ERROR_DOCUMENTS", pair)
+		}
+		errorDocuments[code] = key
+	}
+	return errorDocuments, nil
+}
+
+// compressionLevelRanges are the levels each encoding of COMPRESSION_LEVELS accepts
+var compressionLevelRanges = map[string][2]int{
+	"br":      {0, 11},
+	"zstd":    {1, 22},
+	"gzip":    {-2, 9},
+	"deflate": {-2, 9},
+}
+
+// parseCompressionLevels parses a comma separated list of encoding=level pairs, like br=5,gzip=6
+func parseCompressionLevels(s string) (map[string]int, error) {
+	levels := map[string]int{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if len(pair) == 0 {
+			continue
+		}
+		encoding, value, ok := strings.Cut(pair, "=")
+		encoding = strings.ToLower(strings.TrimSpace(encoding))
+		level, err := strconv.Atoi(strings.TrimSpace(value))
+		bounds, known := compressionLevelRanges[encoding]
+		if !ok || err != nil || !known || level < bounds[0] || level > bounds[1] {
+			return nil, fmt.Errorf("[config] invalid compression level '%s' in COMPRESSION_LEVELS", pair)
+		}
+		levels[encoding] = level
+	}
+	return levels, nil
+}
+
+// splitMediaTypes splits a comma separated list of media types, like text/*,application/json
+func splitMediaTypes(s string) []string {
+	types := []string{}
+	for _, t := range strings.Split(s, ",") {
+		if t = strings.ToLower(strings.TrimSpace(t)); len(t) > 0 {
+			types = append(types, t)
+		}
+	}
+	return types
+}
+
 func createIPNets(src []string) ([]*net.IPNet, error) {
 	whiteListIPRanges := make([]*net.IPNet, 0, len(src))
 	for _, whiteListIPRange := range src {
@@ -9,8 +9,8 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
-func defaultConfig() *config {
-	return &config{
+func defaultConfig() *Settings {
+	return &Settings{
 		AwsRegion:            "",
 		AwsAPIEndpoint:       "",
 		S3Bucket:             "",
@@ -52,6 +52,28 @@ func defaultConfig() *config {
 		TimeoutWrite:         time.Duration(600) * time.Second,
 		CacheTTL:             time.Duration(60) * time.Second,
 		CacheTTLIndex:        time.Duration(60) * time.Second,
+		WriteUsers:           []string{},
+		UploadPartSize:       8 * 1024 * 1024,
+		UploadExtensions:     []string{},
+		Routes:               []*Route{},
+		ErrorDocuments:       map[int]string{},
+		ManifestTTL:          60 * time.Second,
+		HeaderRules:          []*HeaderRule{},
+		SymlinkMaxHops:       8,
+		SymlinkBuckets:       []string{},
+		SymlinkCacheTTL:      60 * time.Second,
+		LatestOrder:          "version",
+		DirListingMaxKeys:    10000,
+		ArchiveMaxFiles:      1000,
+		ArchiveMaxSize:       1024 * 1024 * 1024,
+		Precompressed:        []string{},
+		CompressionEncodings: []string{"br", "zstd", "gzip", "deflate"},
+		CompressionLevels:    map[string]int{},
+		CompressionMinSize:   1024,
+		CompressionTypes: []string{"text/*", "application/json", "application/*+json", "application/x-ndjson",
+			"application/javascript", "application/xml", "application/*+xml", "image/svg+xml", "application/wasm",
+			"font/ttf", "font/otf", "application/vnd.ms-fontobject"},
+		CompressionSkipTypes: []string{},
 	}
 }
 
@@ -77,6 +99,14 @@ func TestChangeDefaults(t *testing.T) {
 	os.Setenv("WHITELIST_IP_RANGES", "10.0.0.0/24,198.5.5.3")
 	os.Setenv("CONTENT_TYPE", "application/octet-stream")
 	os.Setenv("CONTENT_DISPOSITION", "attachment")
+	os.Setenv("ERROR_DOCUMENTS", "404=404.html, 403=errors/403.html")
+	os.Setenv("SYMLINK_BUCKETS", "releases, archive")
+	os.Setenv("PRECOMPRESSED", "br, GZ")
+	os.Setenv("COMPRESSION_ENCODINGS", "zstd, GZIP")
+	os.Setenv("COMPRESSION_LEVELS", "zstd=9, gzip=-2")
+	os.Setenv("COMPRESSION_MIN_SIZE", "0")
+	os.Setenv("COMPRESSION_TYPES", "text/*, Application/JSON")
+	os.Setenv("COMPRESSION_SKIP_TYPES", "text/event-stream")
 
 	Setup()
 
@@ -101,6 +131,28 @@ func TestChangeDefaults(t *testing.T) {
 	}
 	expected.ContentType = "application/octet-stream"
 	expected.ContentDisposition = "attachment"
+	expected.ErrorDocuments = map[int]string{404: "404.html", 403: "errors/403.html"}
+	expected.SymlinkBuckets = []string{"releases", "archive"}
+	expected.Precompressed = []string{"br", "gz"}
+	expected.CompressionEncodings = []string{"zstd", "gzip"}
+	expected.CompressionLevels = map[string]int{"zstd": 9, "gzip": -2}
+	expected.CompressionMinSize = 0
+	expected.CompressionTypes = []string{"text/*", "application/json"}
+	expected.CompressionSkipTypes = []string{"text/event-stream"}
 
 	assert.Equal(t, expected, Config)
 }
+
+func TestParseCompressionLevels(t *testing.T) {
+	for _, invalid := range []string{"br", "br=12", "zstd=0", "lz4=1", "gzip=fast"} {
+		_, err := parseCompressionLevels(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestParseErrorDocuments(t *testing.T) {
+	for _, invalid := range []string{"404", "200=ok.html", "abc=404.html", "404="} {
+		_, err := parseErrorDocuments(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
@@ -0,0 +1,61 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/patrickdk77/aws-s3-proxy/internal/glob"
+)
+
+// HeaderRule sets response headers for the paths matching a glob, like *.html or /assets/*
+type HeaderRule struct {
+	Match   string            `json:"match"`
+	Headers map[string]string `json:"headers"`
+	glob    *glob.Glob
+}
+
+// UnmarshalJSON compiles the glob of a rule as it is read
+func (rule *HeaderRule) UnmarshalJSON(data []byte) error {
+	type plain HeaderRule
+	if err := json.Unmarshal(data, (*plain)(rule)); err != nil {
+		return err
+	}
+	g, err := glob.Compile(rule.Match)
+	if err != nil {
+		return fmt.Errorf("[config] header rule: %w", err)
+	}
+	rule.glob = g
+	return nil
+}
+
+// loadHeaderRules reads the header rules from a JSON file
+func loadHeaderRules(path string) ([]*HeaderRule, error) {
+	data, err := os.ReadFile(path) // nolint:gosec
+	if err != nil {
+		return nil, fmt.Errorf("[config] reading HEADER_RULES: %w", err)
+	}
+	rules := []*HeaderRule{}
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("[config] parsing HEADER_RULES '%s': %w", path, err)
+	}
+	return rules, nil
+}
+
+// HeadersFor returns the headers of the rules matching path, the first rule setting a header wins
+func (c *Settings) HeadersFor(path string) http.Header {
+	headers := http.Header{}
+	for _, rule := range c.HeaderRules {
+		if rule.glob == nil || !rule.glob.Match(path) {
+			continue
+		}
+		for key, value := range rule.Headers {
+			key = http.CanonicalHeaderKey(key)
+			if _, ok := headers[key]; !ok {
+				headers[key] = []string{value}
+			}
+		}
+	}
+	return headers
+}
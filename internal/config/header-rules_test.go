@@ -0,0 +1,38 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadHeaderRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "headers.json")
+	_ = os.WriteFile(path, []byte(`[
+		{"match": "*.html", "headers": {"Cache-Control": "no-cache"}},
+		{"match": "/assets/*", "headers": {"cache-control": "public, max-age=31536000, immutable"}},
+		{"match": "*.pdf", "headers": {"Content-Disposition": "attachment"}},
+		{"match": "*", "headers": {"Cache-Control": "max-age=300", "X-Frame-Options": "DENY"}}
+	]`), 0o600)
+	rules, err := loadHeaderRules(path)
+	assert.NoError(t, err)
+	assert.Len(t, rules, 4)
+
+	c := &Settings{HeaderRules: rules}
+	headers := c.HeadersFor("/docs/index.html")
+	assert.Equal(t, "no-cache", headers.Get("Cache-Control"))
+	assert.Equal(t, "DENY", headers.Get("X-Frame-Options"))
+	headers = c.HeadersFor("/assets/app.js")
+	assert.Equal(t, "public, max-age=31536000, immutable", headers.Get("Cache-Control"))
+	headers = c.HeadersFor("/files/report.pdf")
+	assert.Equal(t, "attachment", headers.Get("Content-Disposition"))
+	assert.Equal(t, "max-age=300", headers.Get("Cache-Control"))
+
+	for _, invalid := range []string{`[{"match": "{a,b", "headers": {}}]`, `not json`} {
+		_ = os.WriteFile(path, []byte(invalid), 0o600)
+		_, err = loadHeaderRules(path)
+		assert.Error(t, err, invalid)
+	}
+}
@@ -0,0 +1,202 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Route serves the requests for a host, or a path mounted on it, from its own bucket and settings.
+// Empty fields keep the global value, an empty basic_auth_user list turns basic authentication off.
+type Route struct {
+	Host               string         `json:"host"`   // example.com, *.docs.example.com or * for any host
+	Path               string         `json:"path"`   // /docs/ mounts the bucket and prefix there, the path is stripped
+	S3Bucket           string         `json:"bucket"` // {subdomain} is replaced by the part of the host matched by *
+	S3KeyPrefix        string         `json:"prefix"` // {subdomain} is replaced by the part of the host matched by *
+	AwsRegion          string         `json:"region"`
+	IndexDocument      string         `json:"index_document"`
+	SPA                *bool          `json:"spa"`
+	DirectoryListing   *bool          `json:"directory_listing"`
+	DirListingFormat   string         `json:"directory_listing_format"`
+	DirListingTemplate string         `json:"directory_listing_template"`
+	Sort               string         `json:"sort"`            // same values as SORT
+	CacheTTL           *int64         `json:"cache_ttl"`       // seconds
+	CacheTTLIndex      *int64         `json:"cache_ttl_index"` // seconds
+	HTTPCacheControl   string         `json:"http_cache_control"`
+	ErrorDocuments     map[int]string `json:"error_documents"` // {"404": "404.html"}
+	Manifests          *bool          `json:"netlify_manifests"`
+	HeaderRules        []*HeaderRule  `json:"header_rules"` // checked before the global HEADER_RULES
+	BasicAuthUser      []string       `json:"basic_auth_user"`
+	BasicAuthPass      []string       `json:"basic_auth_pass"`
+	JwtSecretKey       string         `json:"jwt_secret_key"`
+	JwtUserField       string         `json:"jwt_user_field"`
+}
+
+type contextKey struct{}
+
+// loadRoutes reads the routing table from a JSON file
+func loadRoutes(path string) ([]*Route, error) {
+	data, err := os.ReadFile(path) // nolint:gosec
+	if err != nil {
+		return nil, fmt.Errorf("[config] reading ROUTES_FILE: %w", err)
+	}
+	routes := []*Route{}
+	if err := json.Unmarshal(data, &routes); err != nil {
+		return nil, fmt.Errorf("[config] parsing ROUTES_FILE '%s': %w", path, err)
+	}
+	for _, route := range routes {
+		route.Host = strings.ToLower(strings.TrimSpace(route.Host))
+		if len(route.Host) == 0 && len(route.Path) == 0 {
+			return nil, fmt.Errorf("[config] route without a host or path in ROUTES_FILE '%s'", path)
+		}
+		if len(route.Path) > 0 {
+			if !strings.HasPrefix(route.Path, "/") {
+				return nil, fmt.Errorf("[config] route path '%s' must start with /", route.Path)
+			}
+			if !strings.HasSuffix(route.Path, "/") {
+				route.Path += "/"
+			}
+		}
+		if _, ok := sortModes[route.Sort]; !ok && len(route.Sort) > 0 {
+			return nil, fmt.Errorf("[config] route '%s' has an unknown sort '%s'", route.Host+route.Path, route.Sort)
+		}
+		if len(route.BasicAuthUser) != len(route.BasicAuthPass) {
+			return nil, fmt.Errorf("[config] route '%s' needs as many basic_auth_pass as basic_auth_user", route.Host+route.Path)
+		}
+	}
+	return routes, nil
+}
+
+// matchRoute finds the route for a request. Exact hosts win over the longest matching wildcard,
+// which wins over routes for any host, then the longest mounted path wins.
+// It also returns the part of the host matched by the wildcard.
+func (c *Settings) matchRoute(host, path string) (*Route, string) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	var found *Route
+	foundRank := 0
+	subdomain := ""
+	for _, route := range c.Routes {
+		rank, sub, ok := route.matchHost(host)
+		if !ok || !route.matchPath(path) {
+			continue
+		}
+		if found == nil || rank > foundRank || (rank == foundRank && len(route.Path) > len(found.Path)) {
+			found, foundRank, subdomain = route, rank, sub
+		}
+	}
+	return found, subdomain
+}
+
+// matchHost ranks how closely the route matches host, and returns the part matched by a wildcard
+func (route *Route) matchHost(host string) (int, string, bool) {
+	if len(route.Host) == 0 || route.Host == "*" {
+		return 0, "", true
+	}
+	if route.Host == host {
+		return math.MaxInt, "", true
+	}
+	suffix, ok := strings.CutPrefix(route.Host, "*")
+	if !ok || !strings.HasPrefix(suffix, ".") || !strings.HasSuffix(host, suffix) || len(host) == len(suffix) {
+		return 0, "", false
+	}
+	return len(suffix), strings.TrimSuffix(host, suffix), true
+}
+
+// matchPath reports if path is below the mounted path, or the mount itself without its trailing slash
+func (route *Route) matchPath(path string) bool {
+	return len(route.Path) == 0 || strings.HasPrefix(path, route.Path) || path == strings.TrimSuffix(route.Path, "/")
+}
+
+// ForRequest returns the settings for a request, a copy with the overrides of its route applied
+func (c *Settings) ForRequest(r *http.Request) *Settings {
+	route, subdomain := c.matchRoute(r.Host, r.URL.Path)
+	if route == nil {
+		return c
+	}
+	site := *c
+	if len(route.Path) > 0 {
+		// The mount replaces STRIP_PATH, keys below it start with a /
+		site.StripPath = strings.TrimSuffix(route.Path, "/")
+	}
+	if len(route.S3Bucket) > 0 {
+		site.S3Bucket = strings.ReplaceAll(route.S3Bucket, "{subdomain}", subdomain)
+		// The global prefix belongs to the global bucket
+		site.S3KeyPrefix = ""
+	}
+	if len(route.S3KeyPrefix) > 0 {
+		site.S3KeyPrefix = strings.ReplaceAll(route.S3KeyPrefix, "{subdomain}", subdomain)
+	}
+	if len(route.AwsRegion) > 0 {
+		site.AwsRegion = route.AwsRegion
+	}
+	if len(route.IndexDocument) > 0 {
+		site.IndexDocument = route.IndexDocument
+	}
+	if route.SPA != nil {
+		site.SPA = *route.SPA
+	}
+	if route.DirectoryListing != nil {
+		site.DirectoryListing = *route.DirectoryListing
+	}
+	if len(route.DirListingFormat) > 0 {
+		site.DirListingFormat = route.DirListingFormat
+	}
+	if len(route.DirListingTemplate) > 0 {
+		site.DirListingTemplate = route.DirListingTemplate
+	}
+	if len(route.Sort) > 0 {
+		site.SortDateAsc, site.SortDateDesc, site.SortFileAsc, site.SortFileDesc, site.SortNumeric = parseSort(route.Sort)
+	}
+	if route.CacheTTL != nil {
+		site.CacheTTL = time.Duration(*route.CacheTTL) * time.Second
+	}
+	if route.CacheTTLIndex != nil {
+		site.CacheTTLIndex = time.Duration(*route.CacheTTLIndex) * time.Second
+	}
+	if len(route.HTTPCacheControl) > 0 {
+		site.HTTPCacheControl = route.HTTPCacheControl
+	}
+	if len(route.HeaderRules) > 0 {
+		site.HeaderRules = append(append([]*HeaderRule{}, route.HeaderRules...), c.HeaderRules...)
+	}
+	if route.Manifests != nil {
+		site.Manifests = *route.Manifests
+	}
+	if route.ErrorDocuments != nil {
+		site.ErrorDocuments = route.ErrorDocuments
+	}
+	if route.BasicAuthUser != nil {
+		site.BasicAuthUser = route.BasicAuthUser
+		site.BasicAuthPass = route.BasicAuthPass
+	}
+	if len(route.JwtSecretKey) > 0 {
+		site.JwtSecretKey = route.JwtSecretKey
+	}
+	if len(route.JwtUserField) > 0 {
+		site.JwtUserField = route.JwtUserField
+	}
+	return &site
+}
+
+// NewContext returns a copy of ctx carrying the settings resolved for a request
+func NewContext(ctx context.Context, c *Settings) context.Context {
+	return context.WithValue(ctx, contextKey{}, c)
+}
+
+// FromRequest returns the settings stored by NewContext, or resolves them from Config
+func FromRequest(r *http.Request) *Settings {
+	if c, ok := r.Context().Value(contextKey{}).(*Settings); ok {
+		return c
+	}
+	return Config.ForRequest(r)
+}
@@ -0,0 +1,132 @@
+package config
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testRoutes() *Settings {
+	spa := true
+	return &Settings{
+		S3Bucket:      "default",
+		S3KeyPrefix:   "/global",
+		AwsRegion:     "us-east-1",
+		IndexDocument: "index.html",
+		BasicAuthUser: []string{"user"},
+		BasicAuthPass: []string{"pass"},
+		Routes: []*Route{
+			{Host: "*", S3KeyPrefix: "/fallback"},
+			{Host: "*.example.com", S3Bucket: "site-{subdomain}"},
+			{Host: "*.docs.example.com", S3Bucket: "docs", S3KeyPrefix: "/{subdomain}", SPA: &spa},
+			{Host: "www.example.com", S3Bucket: "www", AwsRegion: "eu-west-1", BasicAuthUser: []string{}, BasicAuthPass: []string{}},
+		},
+	}
+}
+
+func TestForRequestExactHost(t *testing.T) {
+	r, _ := http.NewRequest("GET", "http://WWW.example.com:8080/", nil)
+	c := testRoutes().ForRequest(r)
+
+	assert.Equal(t, "www", c.S3Bucket)
+	assert.Equal(t, "", c.S3KeyPrefix)
+	assert.Equal(t, "eu-west-1", c.AwsRegion)
+	assert.Empty(t, c.BasicAuthUser)
+}
+
+func TestForRequestWildcardHost(t *testing.T) {
+	r, _ := http.NewRequest("GET", "http://blog.example.com/", nil)
+	c := testRoutes().ForRequest(r)
+	assert.Equal(t, "site-blog", c.S3Bucket)
+	assert.Equal(t, "us-east-1", c.AwsRegion)
+	assert.Equal(t, []string{"user"}, c.BasicAuthUser)
+
+	// The longest wildcard wins
+	r, _ = http.NewRequest("GET", "http://v2.docs.example.com/", nil)
+	c = testRoutes().ForRequest(r)
+	assert.Equal(t, "docs", c.S3Bucket)
+	assert.Equal(t, "/v2", c.S3KeyPrefix)
+	assert.True(t, c.SPA)
+
+	// A wildcard needs a subdomain
+	r, _ = http.NewRequest("GET", "http://example.com/", nil)
+	c = testRoutes().ForRequest(r)
+	assert.Equal(t, "default", c.S3Bucket)
+	assert.Equal(t, "/fallback", c.S3KeyPrefix)
+}
+
+func TestForRequestWithoutRoutes(t *testing.T) {
+	settings := testRoutes()
+	settings.Routes = []*Route{}
+	r, _ := http.NewRequest("GET", "http://www.example.com/", nil)
+	assert.Same(t, settings, settings.ForRequest(r))
+}
+
+func TestLoadRoutes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "routes.json")
+	_ = os.WriteFile(path, []byte(`[{"host": " Example.COM ", "bucket": "site"}]`), 0o600)
+	routes, err := loadRoutes(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []*Route{{Host: "example.com", S3Bucket: "site"}}, routes)
+
+	_ = os.WriteFile(path, []byte(`[{"host": "a.com", "basic_auth_user": ["user"]}]`), 0o600)
+	_, err = loadRoutes(path)
+	assert.Error(t, err)
+
+	_ = os.WriteFile(path, []byte(`[{"bucket": "site"}]`), 0o600)
+	_, err = loadRoutes(path)
+	assert.Error(t, err)
+
+	_ = os.WriteFile(path, []byte(`[{"path": "/docs", "bucket": "docs"}]`), 0o600)
+	routes, err = loadRoutes(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "/docs/", routes[0].Path)
+
+	_ = os.WriteFile(path, []byte(`[{"path": "/docs/", "sort": "random"}]`), 0o600)
+	_, err = loadRoutes(path)
+	assert.Error(t, err)
+}
+
+func TestForRequestMountedPath(t *testing.T) {
+	settings := testRoutes()
+	ttl := int64(5)
+	listing := true
+	settings.Routes = append(settings.Routes,
+		&Route{Path: "/docs/", S3Bucket: "docs-bucket", S3KeyPrefix: "/site", DirectoryListing: &listing, DirListingFormat: "apache"},
+		&Route{Path: "/docs/api/", S3Bucket: "api-bucket", Sort: "datedesc", CacheTTL: &ttl},
+		&Route{Host: "www.example.com", Path: "/artifacts/", S3Bucket: "ci-bucket", BasicAuthUser: []string{"ci"}, BasicAuthPass: []string{"secret"}},
+	)
+
+	r, _ := http.NewRequest("GET", "http://other.org/docs/guide/index.html", nil)
+	c := settings.ForRequest(r)
+	assert.Equal(t, "docs-bucket", c.S3Bucket)
+	assert.Equal(t, "/site", c.S3KeyPrefix)
+	assert.Equal(t, "/docs", c.StripPath)
+	assert.True(t, c.DirectoryListing)
+	assert.Equal(t, "apache", c.DirListingFormat)
+
+	// The longest mount wins
+	r, _ = http.NewRequest("GET", "http://other.org/docs/api/", nil)
+	c = settings.ForRequest(r)
+	assert.Equal(t, "api-bucket", c.S3Bucket)
+	assert.Equal(t, "/docs/api", c.StripPath)
+	assert.True(t, c.SortDateDesc)
+	assert.False(t, c.SortFileAsc)
+	assert.Equal(t, 5*time.Second, c.CacheTTL)
+
+	// The mount itself, without its trailing slash
+	r, _ = http.NewRequest("GET", "http://other.org/docs", nil)
+	assert.Equal(t, "docs-bucket", settings.ForRequest(r).S3Bucket)
+
+	// Hosts are matched first, then the mounts on them
+	r, _ = http.NewRequest("GET", "http://www.example.com/artifacts/build.zip", nil)
+	c = settings.ForRequest(r)
+	assert.Equal(t, "ci-bucket", c.S3Bucket)
+	assert.Equal(t, []string{"ci"}, c.BasicAuthUser)
+	r, _ = http.NewRequest("GET", "http://www.example.com/docs/", nil)
+	assert.Equal(t, "www", settings.ForRequest(r).S3Bucket)
+}
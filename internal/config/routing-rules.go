@@ -0,0 +1,79 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"sync/atomic"
+)
+
+// RoutingRule is a redirect rule in the JSON format of S3 static website hosting
+type RoutingRule struct {
+	Condition struct {
+		KeyPrefixEquals             string `json:"KeyPrefixEquals"`
+		HTTPErrorCodeReturnedEquals string `json:"HttpErrorCodeReturnedEquals"`
+	} `json:"Condition"`
+	Redirect struct {
+		HostName             string `json:"HostName"`
+		HTTPRedirectCode     string `json:"HttpRedirectCode"`
+		Protocol             string `json:"Protocol"`
+		ReplaceKeyPrefixWith string `json:"ReplaceKeyPrefixWith"`
+		ReplaceKeyWith       string `json:"ReplaceKeyWith"`
+	} `json:"Redirect"`
+}
+
+var routingRules atomic.Pointer[[]RoutingRule]
+
+// RoutingRules returns the rules loaded from ROUTING_RULES
+func RoutingRules() []RoutingRule {
+	if rules := routingRules.Load(); rules != nil {
+		return *rules
+	}
+	return nil
+}
+
+// LoadRoutingRules reads ROUTING_RULES again, the rules in use are kept when it fails
+func LoadRoutingRules() error {
+	if len(Config.RoutingRulesFile) == 0 {
+		return nil
+	}
+	rules, err := loadRoutingRules(Config.RoutingRulesFile)
+	if err != nil {
+		return err
+	}
+	routingRules.Store(&rules)
+	return nil
+}
+
+// loadRoutingRules accepts the rules as a list, or as the RoutingRules of a website configuration
+func loadRoutingRules(path string) ([]RoutingRule, error) {
+	data, err := os.ReadFile(path) // nolint:gosec
+	if err != nil {
+		return nil, fmt.Errorf("[config] reading ROUTING_RULES: %w", err)
+	}
+	rules := []RoutingRule{}
+	if err := json.Unmarshal(data, &rules); err != nil {
+		website := struct{ RoutingRules []RoutingRule }{}
+		if err := json.Unmarshal(data, &website); err != nil {
+			return nil, fmt.Errorf("[config] parsing ROUTING_RULES '%s': %w", path, err)
+		}
+		rules = website.RoutingRules
+	}
+	for _, rule := range rules {
+		if code := rule.Condition.HTTPErrorCodeReturnedEquals; len(code) > 0 {
+			if i, err := strconv.Atoi(code); err != nil || i < 400 || i > 599 {
+				return nil, fmt.Errorf("[config] invalid HttpErrorCodeReturnedEquals '%s' in ROUTING_RULES", code)
+			}
+		}
+		if code := rule.Redirect.HTTPRedirectCode; len(code) > 0 {
+			if i, err := strconv.Atoi(code); err != nil || i < 300 || i > 399 {
+				return nil, fmt.Errorf("[config] invalid HttpRedirectCode '%s' in ROUTING_RULES", code)
+			}
+		}
+		if len(rule.Redirect.ReplaceKeyPrefixWith) > 0 && len(rule.Redirect.ReplaceKeyWith) > 0 {
+			return nil, fmt.Errorf("[config] ReplaceKeyPrefixWith and ReplaceKeyWith are exclusive in ROUTING_RULES")
+		}
+	}
+	return rules, nil
+}
@@ -0,0 +1,39 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadRoutingRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	_ = os.WriteFile(path, []byte(`[{"Condition": {"KeyPrefixEquals": "docs/"}, "Redirect": {"ReplaceKeyPrefixWith": "documents/"}}]`), 0o600)
+	rules, err := loadRoutingRules(path)
+	assert.NoError(t, err)
+	assert.Len(t, rules, 1)
+	assert.Equal(t, "docs/", rules[0].Condition.KeyPrefixEquals)
+	assert.Equal(t, "documents/", rules[0].Redirect.ReplaceKeyPrefixWith)
+
+	// As in a website configuration
+	_ = os.WriteFile(path, []byte(`{"IndexDocument": {"Suffix": "index.html"}, "RoutingRules": [
+		{"Condition": {"HttpErrorCodeReturnedEquals": "404"}, "Redirect": {"HostName": "example.com", "HttpRedirectCode": "302"}}]}`), 0o600)
+	rules, err = loadRoutingRules(path)
+	assert.NoError(t, err)
+	assert.Len(t, rules, 1)
+	assert.Equal(t, "404", rules[0].Condition.HTTPErrorCodeReturnedEquals)
+	assert.Equal(t, "302", rules[0].Redirect.HTTPRedirectCode)
+
+	for _, invalid := range []string{
+		`[{"Redirect": {"HttpRedirectCode": "200"}}]`,
+		`[{"Condition": {"HttpErrorCodeReturnedEquals": "x"}}]`,
+		`[{"Redirect": {"ReplaceKeyWith": "a", "ReplaceKeyPrefixWith": "b"}}]`,
+		`not json`,
+	} {
+		_ = os.WriteFile(path, []byte(invalid), 0o600)
+		_, err = loadRoutingRules(path)
+		assert.Error(t, err, invalid)
+	}
+}
@@ -0,0 +1,279 @@
+package controllers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/patrickdk77/aws-s3-proxy/internal/config"
+	"github.com/patrickdk77/aws-s3-proxy/internal/httperr"
+	"github.com/patrickdk77/aws-s3-proxy/internal/metrics"
+	"github.com/patrickdk77/aws-s3-proxy/internal/service"
+)
+
+// archiveFormats are the archives of a directory ?download= streams
+var archiveFormats = []string{"zip", "tar", "tar.gz", "tar.zst"}
+
+// archiveContentTypes are what each archive format is served as
+var archiveContentTypes = map[string]string{
+	"zip":     "application/zip",
+	"tar":     "application/x-tar",
+	"tar.gz":  "application/gzip",
+	"tar.zst": "application/zstd",
+}
+
+var errArchiveTooLarge = errors.New("directory is too large to download")
+
+// compressedTypes are content types already compressed, archives store them as they are
+var compressedTypes = map[string]bool{
+	"application/zip":                       true,
+	"application/gzip":                      true,
+	"application/x-gzip":                    true,
+	"application/zstd":                      true,
+	"application/x-bzip2":                   true,
+	"application/x-xz":                      true,
+	"application/x-7z-compressed":           true,
+	"application/vnd.rar":                   true,
+	"application/x-rar-compressed":          true,
+	"application/java-archive":              true,
+	"application/vnd.debian.binary-package": true,
+	"application/x-rpm":                     true,
+	"font/woff":                             true,
+	"font/woff2":                            true,
+}
+
+// archiveEntry is a key written to an archive
+type archiveEntry struct {
+	key     string
+	name    string // path in the archive, directories end with /
+	size    int64
+	modTime time.Time
+}
+
+// archiveCompressed reports if content of a type gains nothing from compression
+func archiveCompressed(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case compressedTypes[mediaType]:
+		return true
+	case mediaType == "image/svg+xml" || mediaType == "image/bmp":
+		return false
+	}
+	for _, prefix := range []string{"image/", "video/", "audio/"} {
+		if strings.HasPrefix(mediaType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// listArchive lists every key below prefix, failing with errArchiveTooLarge past ARCHIVE_MAX_FILES or ARCHIVE_MAX_SIZE
+func listArchive(r *http.Request, client service.AWS, c *config.Settings, prefix string) ([]archiveEntry, error) {
+	opts := &service.ListOptions{MaxKeys: maxListingLimit, Recursive: true}
+	entries := []archiveEntry{}
+	total := int64(0)
+	for {
+		result, err := client.S3listPage(r.Context(), c.S3Bucket, prefix, opts)
+		metrics.UpdateS3Reads(err, metrics.ListObjectAction, metrics.ProxySource)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range result.Contents {
+			key := aws.ToString(obj.Key)
+			name := strings.TrimPrefix(key, prefix)
+			// Keys like ../x would be written outside of the directory extracting them
+			if !fs.ValidPath(strings.TrimSuffix(name, "/")) {
+				continue
+			}
+			entry := archiveEntry{key: key, name: name, size: aws.ToInt64(obj.Size), modTime: aws.ToTime(obj.LastModified)}
+			total += entry.size
+			if len(entries) >= c.ArchiveMaxFiles || total > c.ArchiveMaxSize {
+				return nil, errArchiveTooLarge
+			}
+			entries = append(entries, entry)
+		}
+		if result.IsTruncated == nil || !*result.IsTruncated || result.NextContinuationToken == nil {
+			return entries, nil
+		}
+		opts.ContinuationToken = result.NextContinuationToken
+	}
+}
+
+// archiveName names the archive of a directory after it, or after the bucket at the root
+func archiveName(c *config.Settings, dir, format string) string {
+	name := path.Base(dir)
+	if name == "/" || name == "." {
+		name = c.S3Bucket
+	}
+	return name + "." + format
+}
+
+// downloadArchive streams an archive of everything below path, each object copied from S3 as it is read,
+// keeping the paths relative to the directory and the modification times.
+// The limits are checked on the listing first, as errors once the archive started can only drop the connection.
+func downloadArchive(w http.ResponseWriter, r *http.Request, client service.AWS, c *config.Settings, path, format string) {
+	known := false
+	for _, f := range archiveFormats {
+		known = known || f == format
+	}
+	if !known {
+		httperr.WriteMessage(w, r, http.StatusBadRequest, "Unknown archive format")
+		return
+	}
+	prefix := strings.TrimPrefix(c.S3KeyPrefix+path, "/")
+	entries, err := listArchive(r, client, c, prefix)
+	if errors.Is(err, errArchiveTooLarge) {
+		httperr.WriteMessage(w, r, http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("Directory has more than %d files or %d MB to download", c.ArchiveMaxFiles, c.ArchiveMaxSize/1024/1024))
+		return
+	}
+	if err != nil {
+		code, message := toHTTPError(err)
+		writeS3Error(w, r, client, c, path, code, message)
+		return
+	}
+	if len(entries) == 0 {
+		writeS3Error(w, r, client, c, path, http.StatusNotFound, "nothing to download in "+prefix)
+		return
+	}
+
+	w.Header().Set("Content-Type", archiveContentTypes[format])
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": archiveName(c, path, format)}))
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	aw := newArchiveWriter(w, format)
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.name, "/") {
+			err = aw.dir(entry)
+		} else {
+			err = writeArchiveEntry(r, client, c, aw, entry)
+		}
+		if err == nil {
+			err = aw.flush()
+		}
+		if err != nil {
+			abortStream(r, err)
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+	if err = aw.close(); err != nil {
+		abortStream(r, err)
+	}
+}
+
+// writeArchiveEntry copies an object into the archive
+func writeArchiveEntry(r *http.Request, client service.AWS, c *config.Settings, aw *archiveWriter, entry archiveEntry) error {
+	obj, err := client.S3get(r.Context(), c.S3Bucket, entry.key, nil, nil)
+	metrics.UpdateS3Reads(err, metrics.GetObjectAction, metrics.ProxySource)
+	if err != nil {
+		return err
+	}
+	defer obj.Body.Close()
+	return aw.file(entry, obj)
+}
+
+// archiveWriter writes a zip or tar archive, tar compressed with gzip or zstd, as its entries come
+type archiveWriter struct {
+	zw         *zip.Writer
+	tw         *tar.Writer
+	compressor io.WriteCloser
+}
+
+func newArchiveWriter(w io.Writer, format string) *archiveWriter {
+	aw := &archiveWriter{}
+	switch format {
+	case "zip":
+		aw.zw = zip.NewWriter(w)
+		return aw
+	case "tar.gz":
+		aw.compressor = gzip.NewWriter(w)
+	case "tar.zst":
+		// Only invalid options fail
+		aw.compressor, _ = zstd.NewWriter(w)
+	}
+	if aw.compressor != nil {
+		w = aw.compressor
+	}
+	aw.tw = tar.NewWriter(w)
+	return aw
+}
+
+func (aw *archiveWriter) dir(entry archiveEntry) error {
+	if aw.zw != nil {
+		_, err := aw.zw.CreateHeader(&zip.FileHeader{Name: entry.name, Modified: entry.modTime})
+		return err
+	}
+	return aw.tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: entry.name, Mode: 0o755, ModTime: entry.modTime})
+}
+
+// file writes an object, zip deflates it unless its content type is already compressed
+func (aw *archiveWriter) file(entry archiveEntry, obj *s3.GetObjectOutput) error {
+	var fw io.Writer
+	var err error
+	if aw.zw != nil {
+		header := &zip.FileHeader{Name: entry.name, Modified: entry.modTime, Method: zip.Deflate}
+		if archiveCompressed(aws.ToString(obj.ContentType)) {
+			header.Method = zip.Store
+		}
+		fw, err = aw.zw.CreateHeader(header)
+	} else {
+		// The size of tar entries comes first, the object read may have changed since it was listed
+		size := entry.size
+		if obj.ContentLength != nil {
+			size = *obj.ContentLength
+		}
+		fw, err = aw.tw, aw.tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: entry.name, Mode: 0o644, Size: size, ModTime: entry.modTime})
+	}
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, obj.Body)
+	return err
+}
+
+// flush sends the entries written so far through the compressor
+func (aw *archiveWriter) flush() error {
+	if aw.zw != nil {
+		return aw.zw.Flush()
+	}
+	if err := aw.tw.Flush(); err != nil {
+		return err
+	}
+	if f, ok := aw.compressor.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+func (aw *archiveWriter) close() error {
+	if aw.zw != nil {
+		return aw.zw.Close()
+	}
+	if err := aw.tw.Close(); err != nil {
+		return err
+	}
+	if aw.compressor != nil {
+		return aw.compressor.Close()
+	}
+	return nil
+}
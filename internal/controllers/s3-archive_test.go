@@ -0,0 +1,183 @@
+package controllers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/patrickdk77/aws-s3-proxy/internal/config"
+	"github.com/patrickdk77/aws-s3-proxy/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func setupArchive(t *testing.T) *MockAWS {
+	mockAWS := new(MockAWS)
+	setupAWS(t, mockAWS)
+	config.Config.DirectoryListing = true
+	config.Config.DirListingArchive = true
+	config.Config.ArchiveMaxFiles = 10
+	config.Config.ArchiveMaxSize = 1024
+
+	mtime := aws.Time(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	mockAWS.On("S3listPage", mock.Anything, "bucket", "site/", &service.ListOptions{MaxKeys: 1000, Recursive: true}).Return(&s3.ListObjectsV2Output{
+		Contents: []types.Object{
+			{Key: aws.String("site/index.html"), Size: aws.Int64(11), LastModified: mtime},
+			{Key: aws.String("site/img/"), Size: aws.Int64(0), LastModified: mtime},
+		},
+		IsTruncated:           aws.Bool(true),
+		NextContinuationToken: aws.String("t1"),
+	}, nil)
+	mockAWS.On("S3listPage", mock.Anything, "bucket", "site/", &service.ListOptions{MaxKeys: 1000, Recursive: true, ContinuationToken: aws.String("t1")}).Return(&s3.ListObjectsV2Output{
+		Contents: []types.Object{
+			{Key: aws.String("site/img/logo.png"), Size: aws.Int64(4), LastModified: mtime},
+			{Key: aws.String("site/../escape"), Size: aws.Int64(1), LastModified: mtime},
+		},
+	}, nil)
+	return mockAWS
+}
+
+func TestAwsS3_DownloadZip(t *testing.T) {
+	mockAWS := setupArchive(t)
+
+	mockAWS.On("S3get", mock.Anything, "bucket", "site/index.html", (*string)(nil), (*service.Conditions)(nil)).Return(&s3.GetObjectOutput{
+		Body:        io.NopCloser(bytes.NewBufferString("<html></html")),
+		ContentType: aws.String("text/html; charset=utf-8"),
+	}, nil).Once()
+	mockAWS.On("S3get", mock.Anything, "bucket", "site/img/logo.png", (*string)(nil), (*service.Conditions)(nil)).Return(&s3.GetObjectOutput{
+		Body:        io.NopCloser(bytes.NewBufferString("\x89PNG")),
+		ContentType: aws.String("image/png"),
+	}, nil).Once()
+
+	req, _ := http.NewRequest("GET", "/site/?download=zip", nil)
+	rr := httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/zip", rr.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename=site.zip`, rr.Header().Get("Content-Disposition"))
+
+	zr, err := zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+	assert.NoError(t, err)
+	names := []string{}
+	methods := map[string]uint16{}
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+		methods[f.Name] = f.Method
+	}
+	// Keys leaving the directory are not written
+	assert.Equal(t, []string{"index.html", "img/", "img/logo.png"}, names)
+	// Images are stored as they are, the rest deflated
+	assert.Equal(t, zip.Deflate, methods["index.html"])
+	assert.Equal(t, zip.Store, methods["img/logo.png"])
+	body, _ := zr.File[0].Open()
+	content, _ := io.ReadAll(body)
+	assert.Equal(t, "<html></html", string(content))
+	assert.Equal(t, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), zr.File[0].Modified.UTC())
+	mockAWS.AssertExpectations(t)
+}
+
+func TestAwsS3_DownloadTar(t *testing.T) {
+	mockAWS := setupArchive(t)
+
+	decompress := map[string]func(io.Reader) (io.Reader, error){
+		"tar": func(r io.Reader) (io.Reader, error) { return r, nil },
+		"tar.gz": func(r io.Reader) (io.Reader, error) {
+			return gzip.NewReader(r)
+		},
+		"tar.zst": func(r io.Reader) (io.Reader, error) {
+			return zstd.NewReader(r)
+		},
+	}
+	for format, contentType := range map[string]string{"tar": "application/x-tar", "tar.gz": "application/gzip", "tar.zst": "application/zstd"} {
+		mockAWS.On("S3get", mock.Anything, "bucket", "site/index.html", (*string)(nil), (*service.Conditions)(nil)).Return(&s3.GetObjectOutput{
+			Body:          io.NopCloser(bytes.NewBufferString("<html></html")),
+			ContentLength: aws.Int64(12),
+		}, nil).Once()
+		mockAWS.On("S3get", mock.Anything, "bucket", "site/img/logo.png", (*string)(nil), (*service.Conditions)(nil)).Return(&s3.GetObjectOutput{
+			Body:          io.NopCloser(bytes.NewBufferString("\x89PNG")),
+			ContentLength: aws.Int64(4),
+		}, nil).Once()
+
+		req, _ := http.NewRequest("GET", "/site/?download="+format, nil)
+		rr := httptest.NewRecorder()
+		AwsS3(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code, format)
+		assert.Equal(t, contentType, rr.Header().Get("Content-Type"), format)
+		assert.Equal(t, "attachment; filename=site."+format, rr.Header().Get("Content-Disposition"), format)
+
+		body, err := decompress[format](rr.Body)
+		assert.NoError(t, err, format)
+		tr := tar.NewReader(body)
+		names := []string{}
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			assert.NoError(t, err, format)
+			names = append(names, header.Name)
+			assert.Equal(t, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), header.ModTime.UTC(), format)
+			if header.Name == "index.html" {
+				content, _ := io.ReadAll(tr)
+				assert.Equal(t, "<html></html", string(content), format)
+			}
+		}
+		assert.Equal(t, []string{"index.html", "img/", "img/logo.png"}, names, format)
+	}
+	mockAWS.AssertExpectations(t)
+}
+
+func TestAwsS3_DownloadLimits(t *testing.T) {
+	mockAWS := setupArchive(t)
+
+	// Limits are checked before anything is read
+	for _, limit := range []func(){
+		func() { config.Config.ArchiveMaxFiles = 2 },
+		func() { config.Config.ArchiveMaxSize = 10 },
+	} {
+		config.Config.ArchiveMaxFiles, config.Config.ArchiveMaxSize = 10, 1024
+		limit()
+		req, _ := http.NewRequest("GET", "/site/?download=zip", nil)
+		rr := httptest.NewRecorder()
+		AwsS3(rr, req)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+	}
+	mockAWS.AssertNotCalled(t, "S3get", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+	req, _ := http.NewRequest("GET", "/site/?download=rar", nil)
+	rr := httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestArchiveCompressed(t *testing.T) {
+	for contentType, expected := range map[string]bool{
+		"application/zip":          true,
+		"video/mp4":                true,
+		"image/jpeg":               true,
+		"image/svg+xml":            false,
+		"text/css; charset=utf-8":  false,
+		"application/octet-stream": false,
+		"":                         false,
+	} {
+		assert.Equal(t, expected, archiveCompressed(contentType), contentType)
+	}
+}
+
+func TestListingDownloadLinks(t *testing.T) {
+	c := &config.Settings{DirListingArchive: true}
+	var buf bytes.Buffer
+	assert.NoError(t, builtinListings["apache"].Execute(&buf, newListingPage(c, "/site/", nil)))
+	assert.Contains(t, buf.String(), `<a href="?download=zip" download>zip</a> <a href="?download=tar" download>tar</a>`+
+		` <a href="?download=tar.gz" download>tar.gz</a> <a href="?download=tar.zst" download>tar.zst</a>`)
+}
@@ -0,0 +1,151 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/patrickdk77/aws-s3-proxy/internal/config"
+	"github.com/patrickdk77/aws-s3-proxy/internal/httperr"
+	"github.com/patrickdk77/aws-s3-proxy/internal/service"
+)
+
+// conditionsFromRequest collects the conditional request headers so they can be passed to Amazon S3
+func conditionsFromRequest(r *http.Request) *service.Conditions {
+	cond := &service.Conditions{}
+	found := false
+	if v := r.Header.Get("If-Match"); len(v) > 0 {
+		cond.IfMatch = aws.String(v)
+		found = true
+	}
+	if v := r.Header.Get("If-None-Match"); len(v) > 0 {
+		// Compressed responses weaken the object's ETag, which If-None-Match still matches weakly
+		cond.IfNoneMatch = aws.String(strings.ReplaceAll(v, "W/", ""))
+		found = true
+	}
+	if t, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil {
+		cond.IfModifiedSince = aws.Time(t)
+		found = true
+	}
+	if t, err := http.ParseTime(r.Header.Get("If-Unmodified-Since")); err == nil {
+		cond.IfUnmodifiedSince = aws.Time(t)
+		found = true
+	}
+	if !found {
+		return nil
+	}
+	return cond
+}
+
+/*
+ * checkPreconditions evaluates the conditional headers against a cached object in the
+ * order given by https://www.rfc-editor.org/rfc/rfc9110#section-13.2.2
+ * It returns 0 when the request should be served normally.
+ */
+func checkPreconditions(r *http.Request, etag *string, lastModified *time.Time) int {
+	if im := r.Header.Get("If-Match"); len(im) > 0 {
+		if !etagMatches(im, aws.ToString(etag), false) {
+			return http.StatusPreconditionFailed
+		}
+	} else if ius, err := http.ParseTime(r.Header.Get("If-Unmodified-Since")); err == nil && lastModified != nil {
+		if lastModified.Truncate(time.Second).After(ius) {
+			return http.StatusPreconditionFailed
+		}
+	}
+	if inm := r.Header.Get("If-None-Match"); len(inm) > 0 {
+		if etagMatches(inm, aws.ToString(etag), true) {
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				return http.StatusNotModified
+			}
+			return http.StatusPreconditionFailed
+		}
+	} else if ims, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && lastModified != nil {
+		if !lastModified.Truncate(time.Second).After(ims) {
+			return http.StatusNotModified
+		}
+	}
+	return 0
+}
+
+// ifRangeMatches reports if a Range request may be answered with partial content,
+// a stale If-Range validator asks for the whole object instead
+func ifRangeMatches(r *http.Request, etag *string, lastModified *time.Time) bool {
+	ir := r.Header.Get("If-Range")
+	if len(ir) == 0 {
+		return true
+	}
+	if strings.HasPrefix(ir, `"`) || strings.HasPrefix(ir, "W/") {
+		return etagMatches(ir, aws.ToString(etag), false)
+	}
+	t, err := http.ParseTime(ir)
+	return err == nil && lastModified != nil && lastModified.Truncate(time.Second).Equal(t)
+}
+
+// etagMatches reports if etag is in the comma separated list of entity tags
+func etagMatches(list, etag string, weak bool) bool {
+	if len(etag) == 0 {
+		return false
+	}
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+	if weak {
+		etag = strings.TrimPrefix(etag, "W/")
+	} else if strings.HasPrefix(etag, "W/") {
+		return false
+	}
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		} else if strings.HasPrefix(candidate, "W/") {
+			continue
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// writePreconditionResult answers a request whose preconditions evaluated to status
+func writePreconditionResult(w http.ResponseWriter, r *http.Request, obj interface{}, status int, c *config.Settings) {
+	if status != http.StatusNotModified {
+		httperr.Write(w, r, status, "")
+		return
+	}
+	setHeadersFromAwsResponse(w, obj, c)
+	writeNotModified(w)
+}
+
+// writeS3NotModified answers with the validators S3 returned alongside its 304 response
+func writeS3NotModified(w http.ResponseWriter, err error, c *config.Settings) {
+	var re *smithyhttp.ResponseError
+	if errors.As(err, &re) && re.Response != nil {
+		for _, key := range []string{"ETag", "Last-Modified", "Cache-Control", "Expires"} {
+			if v := re.Response.Header.Get(key); len(v) > 0 {
+				w.Header().Set(key, v)
+			}
+		}
+	}
+	if len(c.HTTPCacheControl) > 0 {
+		w.Header().Set("Cache-Control", c.HTTPCacheControl)
+	}
+	if len(c.HTTPExpires) > 0 {
+		w.Header().Set("Expires", c.HTTPExpires)
+	}
+	writeNotModified(w)
+}
+
+func writeNotModified(w http.ResponseWriter) {
+	h := w.Header()
+	h.Del("Content-Type")
+	h.Del("Content-Length")
+	h.Del("Content-Encoding")
+	h.Del("Content-Range")
+	h.Del("Content-Disposition")
+	w.WriteHeader(http.StatusNotModified)
+}
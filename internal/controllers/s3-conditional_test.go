@@ -0,0 +1,154 @@
+package controllers
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/patrickdk77/aws-s3-proxy/internal/config"
+	"github.com/patrickdk77/aws-s3-proxy/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestCheckPreconditionsIfNoneMatch(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+	req.Header.Set("If-None-Match", `"abc", "def"`)
+
+	assert.Equal(t, http.StatusNotModified, checkPreconditions(req, aws.String(`"def"`), nil))
+	assert.Equal(t, 0, checkPreconditions(req, aws.String(`"xyz"`), nil))
+}
+
+func TestCheckPreconditionsIfMatch(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+	req.Header.Set("If-Match", `"abc"`)
+
+	assert.Equal(t, 0, checkPreconditions(req, aws.String(`"abc"`), nil))
+	assert.Equal(t, http.StatusPreconditionFailed, checkPreconditions(req, aws.String(`"def"`), nil))
+	// If-Match uses the strong comparison
+	req.Header.Set("If-Match", `W/"abc"`)
+	assert.Equal(t, http.StatusPreconditionFailed, checkPreconditions(req, aws.String(`"abc"`), nil))
+}
+
+func TestCheckPreconditionsDates(t *testing.T) {
+	modified := time.Date(2024, 1, 2, 3, 4, 5, 600, time.UTC)
+
+	req := httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+	req.Header.Set("If-Modified-Since", modified.Format(http.TimeFormat))
+	assert.Equal(t, http.StatusNotModified, checkPreconditions(req, nil, &modified))
+
+	req.Header.Set("If-Modified-Since", modified.Add(-time.Hour).Format(http.TimeFormat))
+	assert.Equal(t, 0, checkPreconditions(req, nil, &modified))
+
+	req = httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+	req.Header.Set("If-Unmodified-Since", modified.Add(-time.Hour).Format(http.TimeFormat))
+	assert.Equal(t, http.StatusPreconditionFailed, checkPreconditions(req, nil, &modified))
+}
+
+func TestConditionsFromRequest(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+	assert.Nil(t, conditionsFromRequest(req))
+
+	req.Header.Set("If-None-Match", `"abc"`)
+	cond := conditionsFromRequest(req)
+	assert.Equal(t, `"abc"`, aws.ToString(cond.IfNoneMatch))
+	assert.Nil(t, cond.IfMatch)
+
+	// The weak ETag of a compressed response revalidates against the object
+	req.Header.Set("If-None-Match", `W/"abc", "def"`)
+	cond = conditionsFromRequest(req)
+	assert.Equal(t, `"abc", "def"`, aws.ToString(cond.IfNoneMatch))
+}
+
+func TestAwsS3_ConditionalCacheHit(t *testing.T) {
+	// Setup
+	mockAWS := new(MockAWS)
+	setupAWS(t, mockAWS)
+	config.Config.CacheSize = 10 * 1024 * 1024
+	config.Config.CacheTTL = 1 * time.Minute
+	config.Config.CacheMaxFileSize = 1 * 1024 * 1024
+
+	mockAWS.On("S3get", mock.Anything, "bucket", "/etag.txt", (*string)(nil), (*service.Conditions)(nil)).Return(&s3.GetObjectOutput{
+		Body:          io.NopCloser(bytes.NewBufferString("content")),
+		ContentLength: aws.Int64(7),
+		ContentType:   aws.String("text/plain"),
+		ETag:          aws.String(`"abc"`),
+	}, nil).Once()
+
+	req, _ := http.NewRequest("GET", "/etag.txt", nil)
+	rr := httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// Revalidation is answered from the cache
+	req, _ = http.NewRequest("GET", "/etag.txt", nil)
+	req.Header.Set("If-None-Match", `"abc"`)
+	rr = httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusNotModified, rr.Code)
+	assert.Equal(t, `"abc"`, rr.Header().Get("ETag"))
+	assert.Equal(t, "", rr.Header().Get("Content-Length"))
+	assert.Equal(t, "", rr.Body.String())
+
+	req, _ = http.NewRequest("HEAD", "/etag.txt", nil)
+	req.Header.Set("If-Match", `"def"`)
+	rr = httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusPreconditionFailed, rr.Code)
+
+	mockAWS.AssertExpectations(t)
+}
+
+func TestAwsS3_ConditionalPassThrough(t *testing.T) {
+	// Setup
+	mockAWS := new(MockAWS)
+	setupAWS(t, mockAWS)
+
+	cond := &service.Conditions{IfNoneMatch: aws.String(`"abc"`)}
+	mockAWS.On("S3get", mock.Anything, "bucket", "/etag.txt", (*string)(nil), cond).
+		Return(nil, mockAPIError{code: "NotModified", message: "Not Modified"}).Once()
+
+	req, _ := http.NewRequest("GET", "/etag.txt", nil)
+	req.Header.Set("If-None-Match", `"abc"`)
+	rr := httptest.NewRecorder()
+	AwsS3(rr, req)
+
+	assert.Equal(t, http.StatusNotModified, rr.Code)
+	assert.Equal(t, "", rr.Body.String())
+	mockAWS.AssertExpectations(t)
+}
+
+func TestAwsS3_ConditionalWebsiteRedirect(t *testing.T) {
+	mockAWS := new(MockAWS)
+	setupAWS(t, mockAWS)
+	config.Config.CacheSize = 10 * 1024 * 1024
+	config.Config.CacheTTL = 1 * time.Minute
+	config.Config.CacheMaxFileSize = 1 * 1024 * 1024
+
+	mockAWS.On("S3get", mock.Anything, "bucket", "/moved.html", (*string)(nil), (*service.Conditions)(nil)).Return(&s3.GetObjectOutput{
+		Body:                    io.NopCloser(bytes.NewBufferString("")),
+		ETag:                    aws.String(`"abc"`),
+		WebsiteRedirectLocation: aws.String("/new.html"),
+	}, nil).Once()
+
+	req, _ := http.NewRequest("GET", "/moved.html", nil)
+	rr := httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusMovedPermanently, rr.Code)
+
+	// HEAD answers a cached redirect like GET does, before the preconditions
+	for _, method := range []string{"GET", "HEAD"} {
+		req, _ = http.NewRequest(method, "/moved.html", nil)
+		req.Header.Set("If-None-Match", `"abc"`)
+		rr = httptest.NewRecorder()
+		AwsS3(rr, req)
+		assert.Equal(t, http.StatusMovedPermanently, rr.Code, method)
+		assert.Equal(t, "/new.html", rr.Header().Get("Location"), method)
+	}
+	mockAWS.AssertExpectations(t)
+}
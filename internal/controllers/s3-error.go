@@ -1,11 +1,20 @@
 package controllers
 
 import (
+	"archive/zip"
 	"errors"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/aws/smithy-go"
+	"github.com/patrickdk77/aws-s3-proxy/internal/config"
+	"github.com/patrickdk77/aws-s3-proxy/internal/httperr"
+	"github.com/patrickdk77/aws-s3-proxy/internal/metrics"
+	"github.com/patrickdk77/aws-s3-proxy/internal/service"
 )
 
 func toHTTPError(err error) (int, string) {
@@ -18,8 +27,37 @@ func toHTTPError(err error) (int, string) {
 			return http.StatusForbidden, err.Error()
 		case "InvalidRange":
 			return http.StatusRequestedRangeNotSatisfiable, err.Error()
+		case "NotModified":
+			return http.StatusNotModified, err.Error()
+		case "PreconditionFailed":
+			return http.StatusPreconditionFailed, err.Error()
+		case "BadDigest", "InvalidDigest":
+			return http.StatusBadRequest, err.Error()
+		case "EntityTooLarge":
+			return http.StatusRequestEntityTooLarge, err.Error()
 		}
 	}
+	if errors.Is(err, errSymlinkLoop) {
+		return http.StatusLoopDetected, err.Error()
+	}
+	if errors.Is(err, errSymlinkTarget) {
+		return http.StatusForbidden, err.Error()
+	}
+	if errors.Is(err, errLatestNotFound) {
+		return http.StatusNotFound, err.Error()
+	}
+	// Members of anything but a ZIP archive
+	if errors.Is(err, zip.ErrFormat) {
+		return http.StatusNotFound, err.Error()
+	}
+	if errors.Is(err, zip.ErrAlgorithm) {
+		return http.StatusNotImplemented, err.Error()
+	}
+	// Uploads cut short by UPLOAD_MAX_SIZE
+	var mbe *http.MaxBytesError
+	if errors.As(err, &mbe) {
+		return http.StatusRequestEntityTooLarge, err.Error()
+	}
 	// Check for typed errors as fallback or specific handling
 	var nsk *types.NoSuchKey
 	if errors.As(err, &nsk) {
@@ -32,3 +70,87 @@ func toHTTPError(err error) (int, string) {
 
 	return http.StatusInternalServerError, err.Error()
 }
+
+// maxErrorDocumentSize bounds the error documents read into memory
+const maxErrorDocumentSize = 1024 * 1024
+
+func errorDocumentCacheKey(bucket, key string) string {
+	return "ErrorDocument:=" + bucket + ":" + key
+}
+
+// writeS3Error answers with the redirect of a routing rule or _redirects, or the error document configured for the status code,
+// like S3 static website hosting does, or with a generic error when there is none.
+// message is only logged, it holds AWS request IDs and bucket names.
+func writeS3Error(w http.ResponseWriter, r *http.Request, client service.AWS, c *config.Settings, path string, code int, message string) {
+	if routingRuleRedirect(w, r, c, path, code) {
+		return
+	}
+	if m := loadManifest(r, client, c); m != nil && code == http.StatusNotFound {
+		if _, done := m.redirect(w, r, client, c, path, true); done {
+			return
+		}
+	}
+	doc, ok := errorDocument(r, client, c, path, code)
+	if !ok {
+		httperr.Write(w, r, code, message)
+		return
+	}
+	w.Header().Set("Content-Type", doc.ContentType)
+	if len(w.Header().Get("Content-Encoding")) == 0 {
+		w.Header().Set("Content-Length", strconv.Itoa(len(doc.Body)))
+	}
+	w.WriteHeader(code)
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(doc.Body)
+	}
+}
+
+// errorDocument finds the error document for code, relative keys are looked up
+// in the directory of path first and then at the root
+func errorDocument(r *http.Request, client service.AWS, c *config.Settings, path string, code int) (cachedResponse, bool) {
+	key, ok := c.ErrorDocuments[code]
+	if !ok || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+		return cachedResponse{}, false
+	}
+	keys := []string{}
+	if !strings.HasPrefix(key, "/") {
+		if dir := path[:strings.LastIndex(path, "/")+1]; len(dir) > 1 {
+			keys = append(keys, dir+key)
+		}
+		key = "/" + key
+	}
+	keys = append(keys, key)
+	for _, key := range keys {
+		if doc, ok := loadErrorDocument(r, client, c, c.S3KeyPrefix+key); ok {
+			return doc, true
+		}
+	}
+	return cachedResponse{}, false
+}
+
+func loadErrorDocument(r *http.Request, client service.AWS, c *config.Settings, key string) (cachedResponse, bool) {
+	cacheKey := errorDocumentCacheKey(c.S3Bucket, key)
+	if httpCache != nil {
+		if item := httpCache.Get(cacheKey); item != nil && !item.Expired() {
+			return item.Value(), item.Value().Exists
+		}
+	}
+	doc := cachedResponse{}
+	obj, err := client.S3get(r.Context(), c.S3Bucket, key, nil, nil)
+	metrics.UpdateS3Reads(err, metrics.GetObjectAction, metrics.ProxySource)
+	if err == nil {
+		body, err := io.ReadAll(io.LimitReader(obj.Body, maxErrorDocumentSize))
+		obj.Body.Close()
+		if err == nil {
+			doc = cachedResponse{Body: body, ContentType: aws.ToString(obj.ContentType), Exists: true}
+			if len(doc.ContentType) == 0 {
+				doc.ContentType = "text/html; charset=utf-8"
+			}
+		}
+	}
+	// Missing documents are cached too, or every error would cost more S3 requests
+	if httpCache != nil {
+		httpCache.Set(cacheKey, doc, c.CacheTTL)
+	}
+	return doc, doc.Exists
+}
@@ -2,13 +2,21 @@ package controllers
 
 import (
 	"errors"
+	"io"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/aws/smithy-go"
+	"github.com/patrickdk77/aws-s3-proxy/internal/config"
+	"github.com/patrickdk77/aws-s3-proxy/internal/service"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 )
 
 type mockAPIError struct {
@@ -75,3 +83,54 @@ func TestToHTTPInvalidRangeError(t *testing.T) {
 	assert.Equal(t, expectedCode, code)
 	assert.Equal(t, expectedMsg, msg)
 }
+
+func TestAwsS3_ErrorDocument(t *testing.T) {
+	mockAWS := new(MockAWS)
+	setupAWS(t, mockAWS)
+	config.Config.CacheSize = 10 * 1024 * 1024
+	config.Config.CacheTTL = 1 * time.Minute
+	config.Config.CacheMaxFileSize = 1 * 1024 * 1024
+	config.Config.SPA = false
+	config.Config.ErrorDocuments = map[int]string{http.StatusNotFound: "404.html"}
+
+	notFound := &smithy.GenericAPIError{Code: "NoSuchKey", Message: "The specified key does not exist. RequestId: 123"}
+	mockAWS.On("S3get", mock.Anything, "bucket", mock.MatchedBy(func(key string) bool {
+		return strings.HasPrefix(key, "/docs/missing")
+	}), (*string)(nil), (*service.Conditions)(nil)).Return(nil, notFound).Twice()
+	// Looked up in the directory first, then at the root, and cached either way
+	mockAWS.On("S3get", mock.Anything, "bucket", "/docs/404.html", (*string)(nil), (*service.Conditions)(nil)).Return(nil, notFound).Once()
+	mockAWS.On("S3get", mock.Anything, "bucket", "/404.html", (*string)(nil), (*service.Conditions)(nil)).Return(&s3.GetObjectOutput{
+		Body:        io.NopCloser(strings.NewReader("<h1>Not here</h1>")),
+		ContentType: aws.String("text/html"),
+	}, nil).Once()
+
+	for _, path := range []string{"/docs/missing.html", "/docs/missing2.html"} {
+		req, _ := http.NewRequest("GET", path, nil)
+		rr := httptest.NewRecorder()
+		AwsS3(rr, req)
+
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+		assert.Equal(t, "text/html", rr.Header().Get("Content-Type"))
+		assert.Equal(t, "<h1>Not here</h1>", rr.Body.String())
+	}
+	mockAWS.AssertExpectations(t)
+}
+
+func TestAwsS3_ErrorHidesDetail(t *testing.T) {
+	mockAWS := new(MockAWS)
+	setupAWS(t, mockAWS)
+	config.Config.SPA = false
+
+	mockAWS.On("S3get", mock.Anything, "bucket", "/secret.txt", (*string)(nil), (*service.Conditions)(nil)).Return(nil,
+		&smithy.GenericAPIError{Code: "AccessDenied", Message: "Access Denied, RequestID: ABC123, HostID: xyz"}).Once()
+
+	req, _ := http.NewRequest("GET", "/secret.txt", nil)
+	rr := httptest.NewRecorder()
+	AwsS3(rr, req)
+
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.NotContains(t, rr.Body.String(), "ABC123")
+	assert.Contains(t, rr.Body.String(), "Forbidden")
+	assert.Contains(t, rr.Body.String(), rr.Header().Get("X-Request-Id"))
+	mockAWS.AssertExpectations(t)
+}
@@ -0,0 +1,86 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/patrickdk77/aws-s3-proxy/internal/config"
+)
+
+// contentHeaders describe the object served and are left alone on errors and redirects
+var contentHeaders = []string{"Content-Type", "Content-Encoding", "Content-Disposition", "Cache-Control", "Expires"}
+
+// notModifiedHeaders are the validator and cache headers a 304 carries, the others are left to the full response
+var notModifiedHeaders = []string{"Cache-Control", "Content-Location", "ETag", "Expires", "Last-Modified", "Vary"}
+
+// headerRulePath returns the path header rules match, directories match their index document
+func headerRulePath(path string, c *config.Settings) string {
+	if strings.HasSuffix(path, "/") && len(c.IndexDocument) > 0 {
+		return path + c.IndexDocument
+	}
+	return path
+}
+
+// headerWriter sets the headers of HEADER_RULES and _headers once the status is written, over the ones from S3
+type headerWriter struct {
+	http.ResponseWriter
+	headers     http.Header
+	wroteHeader bool
+}
+
+// withHeaders wraps w when there are headers to set
+func withHeaders(w http.ResponseWriter, headers http.Header) http.ResponseWriter {
+	if len(headers) == 0 {
+		return w
+	}
+	return &headerWriter{ResponseWriter: w, headers: headers}
+}
+
+func (hw *headerWriter) WriteHeader(code int) {
+	if !hw.wroteHeader {
+		hw.wroteHeader = true
+		success := code >= 200 && code < 300
+		for key, values := range hw.headers {
+			switch {
+			case code == http.StatusNotModified:
+				if !hasHeader(notModifiedHeaders, key) {
+					continue
+				}
+			case !success && isContentHeader(key):
+				continue
+			}
+			hw.ResponseWriter.Header()[key] = values
+		}
+	}
+	hw.ResponseWriter.WriteHeader(code)
+}
+
+func (hw *headerWriter) Write(b []byte) (int, error) {
+	if !hw.wroteHeader {
+		hw.WriteHeader(http.StatusOK)
+	}
+	return hw.ResponseWriter.Write(b)
+}
+
+func (hw *headerWriter) Flush() {
+	if f, ok := hw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (hw *headerWriter) Unwrap() http.ResponseWriter {
+	return hw.ResponseWriter
+}
+
+func isContentHeader(key string) bool {
+	return hasHeader(contentHeaders, key)
+}
+
+func hasHeader(headers []string, key string) bool {
+	for _, h := range headers {
+		if strings.EqualFold(h, key) {
+			return true
+		}
+	}
+	return false
+}
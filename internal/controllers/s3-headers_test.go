@@ -0,0 +1,75 @@
+package controllers
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	"github.com/patrickdk77/aws-s3-proxy/internal/config"
+	"github.com/patrickdk77/aws-s3-proxy/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestAwsS3_HeaderRules(t *testing.T) {
+	mockAWS := new(MockAWS)
+	setupAWS(t, mockAWS)
+	config.Config.SPA = false
+	config.Config.HTTPCacheControl = "max-age=60"
+	config.Config.IndexDocument = "index.html"
+	config.Config.HeaderRules = []*config.HeaderRule{}
+	for _, rule := range []string{
+		`{"match": "*.html", "headers": {"Cache-Control": "no-cache"}}`,
+		`{"match": "*.pdf", "headers": {"Content-Disposition": "attachment"}}`,
+		`{"match": "*", "headers": {"X-Content-Type-Options": "nosniff"}}`,
+	} {
+		headerRule := &config.HeaderRule{}
+		assert.NoError(t, headerRule.UnmarshalJSON([]byte(rule)))
+		config.Config.HeaderRules = append(config.Config.HeaderRules, headerRule)
+	}
+
+	mockAWS.On("S3get", mock.Anything, "bucket", "/docs/index.html", (*string)(nil), (*service.Conditions)(nil)).Return(&s3.GetObjectOutput{
+		Body:         io.NopCloser(bytes.NewBufferString("html")),
+		CacheControl: aws.String("max-age=3600"),
+	}, nil).Once()
+	mockAWS.On("S3get", mock.Anything, "bucket", "/report.pdf", (*string)(nil), (*service.Conditions)(nil)).Return(
+		nil, &smithy.GenericAPIError{Code: "NoSuchKey"}).Once()
+
+	// Directories match with their index document
+	req, _ := http.NewRequest("GET", "/docs/", nil)
+	rr := httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, []string{"no-cache"}, rr.Header().Values("Cache-Control"))
+	assert.Equal(t, "nosniff", rr.Header().Get("X-Content-Type-Options"))
+
+	// Errors keep security headers but not the ones describing the object
+	req, _ = http.NewRequest("GET", "/report.pdf", nil)
+	rr = httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Empty(t, rr.Header().Get("Content-Disposition"))
+	assert.Equal(t, "nosniff", rr.Header().Get("X-Content-Type-Options"))
+	mockAWS.AssertExpectations(t)
+}
+
+func TestHeaderWriterNotModified(t *testing.T) {
+	rr := httptest.NewRecorder()
+	w := withHeaders(rr, http.Header{
+		"Cache-Control":          {"no-cache"},
+		"Content-Type":           {"text/html"},
+		"Content-Encoding":       {"br"},
+		"X-Content-Type-Options": {"nosniff"},
+	})
+	writeNotModified(w)
+	assert.Equal(t, http.StatusNotModified, rr.Code)
+	assert.Equal(t, "no-cache", rr.Header().Get("Cache-Control"))
+	assert.Empty(t, rr.Header().Get("Content-Type"))
+	assert.Empty(t, rr.Header().Get("Content-Encoding"))
+	assert.Empty(t, rr.Header().Get("X-Content-Type-Options"))
+}
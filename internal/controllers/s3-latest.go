@@ -0,0 +1,149 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/patrickdk77/aws-s3-proxy/internal/config"
+	"github.com/patrickdk77/aws-s3-proxy/internal/metrics"
+	"github.com/patrickdk77/aws-s3-proxy/internal/service"
+)
+
+var errLatestNotFound = errors.New("nothing to resolve latest to")
+
+// versionPattern finds the version in a name, like 1.2.3 in myapp-v1.2.3-rc.1
+var versionPattern = regexp.MustCompile(`(\d+(?:\.\d+)*)(?:-([0-9A-Za-z.-]+))?`)
+
+func latestCacheKey(bucket, dir, suffix string) string {
+	return "Latest:=" + bucket + ":" + dir + "?" + suffix
+}
+
+// resolveLatest replaces the first LATEST_NAME segment of path with the newest entry of its directory.
+// The bare name resolves to a directory, with an extension like latest.tar.gz to an object with that extension.
+// The path is returned unchanged when it has no such segment.
+func resolveLatest(r *http.Request, client service.AWS, c *config.Settings, path string) (string, error) {
+	if len(c.LatestName) == 0 {
+		return path, nil
+	}
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		suffix, ok := strings.CutPrefix(segment, c.LatestName)
+		if !ok || (len(suffix) > 0 && !strings.HasPrefix(suffix, ".")) {
+			continue
+		}
+		dir := strings.Join(segments[:i], "/") + "/"
+		latest, err := latestEntry(r, client, c, dir, suffix)
+		if err != nil {
+			return path, err
+		}
+		rest := strings.Join(segments[i+1:], "/")
+		if len(suffix) == 0 {
+			return dir + latest + rest, nil
+		}
+		if i+1 < len(segments) {
+			return dir + latest + "/" + rest, nil
+		}
+		return dir + latest, nil
+	}
+	return path, nil
+}
+
+// latestEntry returns the newest directory of dir, or object ending with suffix, cached for CACHE_TTL_INDEX
+func latestEntry(r *http.Request, client service.AWS, c *config.Settings, dir, suffix string) (string, error) {
+	prefix := strings.TrimPrefix(c.S3KeyPrefix+dir, "/")
+	cacheKey := latestCacheKey(c.S3Bucket, c.S3KeyPrefix+dir, suffix)
+	if httpCache != nil && c.CacheTTLIndex > 0 {
+		if item := httpCache.Get(cacheKey); item != nil && !item.Expired() {
+			return string(item.Value().Body), nil
+		}
+	}
+	result, err := client.S3listObjects(r.Context(), c.S3Bucket, prefix)
+	metrics.UpdateS3Reads(err, metrics.ListObjectAction, metrics.ProxySource)
+	if err != nil {
+		return "", err
+	}
+	var candidates s3objects
+	for _, file := range convertToMaps(c, result, prefix) {
+		isDir := strings.HasSuffix(file.file, "/")
+		if (len(suffix) == 0 && isDir) || (len(suffix) > 0 && !isDir && strings.HasSuffix(file.file, suffix)) {
+			candidates = append(candidates, file)
+		}
+	}
+	if len(candidates) == 0 {
+		return "", fmt.Errorf("%w in %s", errLatestNotFound, dir)
+	}
+	// Directories have no date, they are always ordered by version
+	if strings.EqualFold(c.LatestOrder, "date") && len(suffix) > 0 {
+		sort.Sort(sortedObjects{candidates, &config.Settings{SortDateDesc: true, SortFileDesc: true}})
+	} else {
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return compareVersions(
+				strings.TrimSuffix(strings.TrimSuffix(candidates[i].file, "/"), suffix),
+				strings.TrimSuffix(strings.TrimSuffix(candidates[j].file, "/"), suffix)) > 0
+		})
+	}
+	latest := candidates[0].file
+	if httpCache != nil && c.CacheTTLIndex > 0 {
+		httpCache.Set(cacheKey, cachedResponse{Body: []byte(latest), Exists: true}, c.CacheTTLIndex)
+	}
+	return latest, nil
+}
+
+// compareVersions orders names by the semantic version they hold, pre-releases before their release.
+// Names without a version come before any version and are compared as strings.
+func compareVersions(a, b string) int {
+	va, vb := versionPattern.FindStringSubmatch(a), versionPattern.FindStringSubmatch(b)
+	switch {
+	case va == nil && vb == nil:
+		return strings.Compare(a, b)
+	case va == nil:
+		return -1
+	case vb == nil:
+		return 1
+	}
+	if n := compareIdentifiers(strings.Split(va[1], "."), strings.Split(vb[1], ".")); n != 0 {
+		return n
+	}
+	// A release is newer than its pre-releases
+	switch {
+	case len(va[2]) == 0 && len(vb[2]) > 0:
+		return 1
+	case len(va[2]) > 0 && len(vb[2]) == 0:
+		return -1
+	}
+	if n := compareIdentifiers(strings.Split(va[2], "."), strings.Split(vb[2], ".")); n != 0 {
+		return n
+	}
+	return strings.Compare(a, b)
+}
+
+// compareIdentifiers compares dot separated identifiers, numbers by value and before words
+func compareIdentifiers(a, b []string) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		na, errA := strconv.ParseUint(a[i], 10, 64)
+		nb, errB := strconv.ParseUint(b[i], 10, 64)
+		switch {
+		case errA == nil && errB == nil:
+			if na != nb {
+				if na < nb {
+					return -1
+				}
+				return 1
+			}
+		case errA == nil:
+			return -1
+		case errB == nil:
+			return 1
+		default:
+			if n := strings.Compare(a[i], b[i]); n != 0 {
+				return n
+			}
+		}
+	}
+	return len(a) - len(b)
+}
@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/karlseguin/ccache/v3"
+	"github.com/patrickdk77/aws-s3-proxy/internal/config"
+	"github.com/patrickdk77/aws-s3-proxy/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestCompareVersions(t *testing.T) {
+	ordered := []string{"README", "myapp-0.9.0", "myapp-1.2.0-rc.1", "myapp-1.2.0-rc.2", "myapp-1.2.0", "v1.10.0", "2.0"}
+	for i := 0; i < len(ordered)-1; i++ {
+		assert.Negative(t, compareVersions(ordered[i], ordered[i+1]), ordered[i])
+		assert.Positive(t, compareVersions(ordered[i+1], ordered[i]), ordered[i+1])
+	}
+	assert.Zero(t, compareVersions("v1.0.0", "v1.0.0"))
+}
+
+func setupLatest(t *testing.T) *MockAWS {
+	mockAWS := new(MockAWS)
+	setupAWS(t, mockAWS)
+	config.Config.CacheSize = 1024 * 1024
+	config.Config.CacheTTL = time.Minute
+	config.Config.CacheTTLIndex = time.Minute
+	config.Config.CacheMaxFileSize = 1024
+	config.Config.SPA = false
+	config.Config.LatestName = "latest"
+	config.Config.LatestOrder = "version"
+
+	mockAWS.On("S3listObjects", mock.Anything, "bucket", "releases/myapp/").Return(&s3.ListObjectsV2Output{
+		CommonPrefixes: []types.CommonPrefix{
+			{Prefix: aws.String("releases/myapp/v1.9.0/")},
+			{Prefix: aws.String("releases/myapp/v1.10.0/")},
+			{Prefix: aws.String("releases/myapp/v1.10.0-rc.1/")},
+		},
+		Contents: []types.Object{
+			{Key: aws.String("releases/myapp/myapp-1.10.0.tar.gz"), Size: aws.Int64(1), LastModified: aws.Time(time.Unix(100, 0))},
+			{Key: aws.String("releases/myapp/myapp-1.9.1.tar.gz"), Size: aws.Int64(1), LastModified: aws.Time(time.Unix(200, 0))},
+			{Key: aws.String("releases/myapp/myapp-2.0.0.zip"), Size: aws.Int64(1), LastModified: aws.Time(time.Unix(300, 0))},
+		},
+	}, nil)
+	return mockAWS
+}
+
+func TestAwsS3_Latest(t *testing.T) {
+	mockAWS := setupLatest(t)
+
+	mockAWS.On("S3get", mock.Anything, "bucket", "/releases/myapp/v1.10.0/app.js", (*string)(nil), (*service.Conditions)(nil)).Return(&s3.GetObjectOutput{
+		Body: io.NopCloser(bytes.NewBufferString("1.10.0")),
+	}, nil).Once()
+	mockAWS.On("S3get", mock.Anything, "bucket", "/releases/myapp/myapp-1.10.0.tar.gz", (*string)(nil), (*service.Conditions)(nil)).Return(&s3.GetObjectOutput{
+		Body: io.NopCloser(bytes.NewBufferString("tarball")),
+	}, nil).Once()
+
+	req, _ := http.NewRequest("GET", "/releases/myapp/latest/app.js", nil)
+	rr := httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "1.10.0", rr.Body.String())
+
+	// Directories and each extension resolve separately
+	req, _ = http.NewRequest("GET", "/releases/myapp/latest.tar.gz", nil)
+	rr = httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "tarball", rr.Body.String())
+
+	req, _ = http.NewRequest("GET", "/releases/myapp/latest.deb", nil)
+	rr = httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	// Resolved from the cache
+	req, _ = http.NewRequest("GET", "/releases/myapp/latest.tar.gz", nil)
+	rr = httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	mockAWS.AssertExpectations(t)
+	mockAWS.AssertNumberOfCalls(t, "S3listObjects", 3)
+}
+
+func TestInvalidateLatest(t *testing.T) {
+	httpCache = ccache.New(ccache.Configure[cachedResponse]().MaxSize(10))
+	defer func() { httpCache = nil }()
+	for _, dir := range []string{"/releases/", "/releases/v1.3/", "/other/"} {
+		httpCache.Set(latestCacheKey("bucket", dir, ".tar"), cachedResponse{}, time.Minute)
+	}
+
+	invalidateCache("bucket", "/releases/v1.3/app.tar")
+	assert.Nil(t, httpCache.Get(latestCacheKey("bucket", "/releases/", ".tar")))
+	assert.Nil(t, httpCache.Get(latestCacheKey("bucket", "/releases/v1.3/", ".tar")))
+	assert.NotNil(t, httpCache.Get(latestCacheKey("bucket", "/other/", ".tar")))
+}
+
+func TestAwsS3_LatestRedirectByDate(t *testing.T) {
+	mockAWS := setupLatest(t)
+	config.Config.LatestOrder = "date"
+	config.Config.LatestRedirect = true
+
+	req, _ := http.NewRequest("GET", "/releases/myapp/latest.tar.gz?mirror=eu", nil)
+	rr := httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusFound, rr.Code)
+	assert.Equal(t, "/releases/myapp/myapp-1.9.1.tar.gz?mirror=eu", rr.Header().Get("Location"))
+	mockAWS.AssertExpectations(t)
+}
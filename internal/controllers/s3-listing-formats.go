@@ -0,0 +1,276 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"html/template"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/patrickdk77/aws-s3-proxy/internal/config"
+)
+
+// listingContentTypes are the machine readable listing formats and what they are served as
+var listingContentTypes = map[string]string{
+	"json":  "application/json; charset=utf-8",
+	"jsonl": "application/x-ndjson; charset=utf-8",
+	"csv":   "text/csv; charset=utf-8",
+	"nginx": "application/json; charset=utf-8",
+	"caddy": "application/json; charset=utf-8",
+}
+
+// listingAccept maps the media types of Accept to listing formats
+var listingAccept = map[string]string{
+	"application/json":     "json",
+	"application/x-ndjson": "jsonl",
+	"application/jsonl":    "jsonl",
+	"text/csv":             "csv",
+	"text/html":            "html",
+}
+
+// listingFormat returns the listing format a request asks for with ?format= or Accept,
+// DIRECTORY_LISTINGS_FORMAT otherwise. It reports false for an unknown ?format=.
+func listingFormat(r *http.Request, c *config.Settings) (string, bool) {
+	configured := strings.ToLower(c.DirListingFormat)
+	if format := strings.ToLower(r.URL.Query().Get("format")); len(format) > 0 {
+		if _, ok := builtinListings[format]; ok {
+			return format, true
+		}
+		if _, ok := listingContentTypes[format]; ok || (format == "template" && len(c.DirListingTemplate) > 0) {
+			return format, true
+		}
+		return "", false
+	}
+	best, bestQ := "", 0.0
+	for _, mediaRange := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(mediaRange)
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if format, ok := listingAccept[mediaType]; ok && q > bestQ {
+			best, bestQ = format, q
+		}
+	}
+	switch {
+	case len(best) == 0:
+		return configured, true
+	// Browsers get the configured HTML listing, or the default one when none is
+	case best == "html" && (configured == "" || configured == "template" || builtinListings[configured] != nil):
+		return configured, true
+	case best == "json" && (configured == "nginx" || configured == "caddy"):
+		return configured, true
+	}
+	return best, true
+}
+
+// listingSettings returns the settings of a site listing in the format a request asks for,
+// false for an unknown ?format=
+func listingSettings(r *http.Request, c *config.Settings) (*config.Settings, bool) {
+	format, ok := listingFormat(r, c)
+	if !ok {
+		return c, false
+	}
+	if format != strings.ToLower(c.DirListingFormat) {
+		site := *c
+		site.DirListingFormat = format
+		c = &site
+	}
+	return c, true
+}
+
+// listingJSONEntry is an entry of the json, jsonl and csv listings
+type listingJSONEntry struct {
+	Name         string     `json:"name"`
+	Type         string     `json:"type"` // file or directory
+	Size         *int64     `json:"size,omitempty"`
+	ModTime      *time.Time `json:"mtime,omitempty"`
+	ETag         string     `json:"etag,omitempty"`
+	StorageClass string     `json:"storage_class,omitempty"`
+}
+
+// listingJSON is the json listing
+type listingJSON struct {
+	Path    string             `json:"path"`
+	Entries []listingJSONEntry `json:"entries"`
+	Next    string             `json:"next,omitempty"` // ?continuation-token= of the next page
+}
+
+// nginxEntry is an entry of nginx autoindex_format json
+type nginxEntry struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	MTime string `json:"mtime"`
+	Size  *int64 `json:"size,omitempty"`
+}
+
+// caddyEntry is an entry of the Caddy file_server browse JSON
+type caddyEntry struct {
+	Name      string      `json:"name"`
+	Size      int64       `json:"size"`
+	URL       string      `json:"url"`
+	ModTime   time.Time   `json:"mod_time"`
+	Mode      os.FileMode `json:"mode"`
+	IsDir     bool        `json:"is_dir"`
+	IsSymlink bool        `json:"is_symlink"`
+}
+
+func toJSONEntry(e listingEntry) listingJSONEntry {
+	entry := listingJSONEntry{Name: strings.TrimSuffix(e.Name, "/"), Type: "file", ETag: e.ETag, StorageClass: e.StorageClass}
+	if e.IsDir {
+		entry.Type = "directory"
+	} else {
+		size, modTime := e.Size, e.ModTime
+		entry.Size, entry.ModTime = &size, &modTime
+	}
+	return entry
+}
+
+func toNginxEntry(e listingEntry) nginxEntry {
+	entry := nginxEntry{Name: strings.TrimSuffix(e.Name, "/"), Type: "directory", MTime: e.ModTime.UTC().Format(http.TimeFormat)}
+	if !e.IsDir {
+		size := e.Size
+		entry.Type, entry.Size = "file", &size
+	}
+	return entry
+}
+
+func toCaddyEntry(e listingEntry) caddyEntry {
+	entry := caddyEntry{Name: e.Name, URL: "./" + (&url.URL{Path: e.Name}).EscapedPath(), ModTime: e.ModTime, Mode: 0o644, IsDir: e.IsDir}
+	if e.IsDir {
+		entry.Mode = os.ModeDir | 0o755
+	} else {
+		entry.Size = e.Size
+	}
+	return entry
+}
+
+// listingWriter writes a listing as its entries come, the header and footer around them.
+// Templates write their header, entry and footer blocks.
+type listingWriter struct {
+	w       io.Writer
+	format  string
+	tmpl    *template.Template
+	entries int
+}
+
+func (lw *listingWriter) header(page listingPage) error {
+	switch {
+	case lw.tmpl != nil:
+		return lw.tmpl.ExecuteTemplate(lw.w, "header", page)
+	case lw.format == "csv":
+		return lw.writeCSV([]string{"name", "type", "size", "mtime", "etag", "storage_class"})
+	case lw.format == "nginx" || lw.format == "caddy":
+		_, err := io.WriteString(lw.w, "[")
+		return err
+	case lw.format != "jsonl":
+		path, err := json.Marshal(page.Path)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(lw.w, `{"path":`+string(path)+`,"entries":[`)
+		return err
+	}
+	return nil
+}
+
+func (lw *listingWriter) write(entries []listingEntry) error {
+	for _, e := range entries {
+		var err error
+		switch {
+		case lw.tmpl != nil:
+			err = lw.tmpl.ExecuteTemplate(lw.w, "entry", e)
+		case lw.format == "jsonl":
+			err = json.NewEncoder(lw.w).Encode(toJSONEntry(e))
+		case lw.format == "csv":
+			entry := toJSONEntry(e)
+			size, modTime := "", ""
+			if entry.Size != nil {
+				size = strconv.FormatInt(*entry.Size, 10)
+				modTime = entry.ModTime.UTC().Format(time.RFC3339)
+			}
+			err = lw.writeCSV([]string{entry.Name, entry.Type, size, modTime, entry.ETag, entry.StorageClass})
+		case lw.format == "nginx":
+			err = lw.writeJSON(toNginxEntry(e))
+		case lw.format == "caddy":
+			err = lw.writeJSON(toCaddyEntry(e))
+		default:
+			err = lw.writeJSON(toJSONEntry(e))
+		}
+		if err != nil {
+			return err
+		}
+		lw.entries++
+	}
+	return nil
+}
+
+func (lw *listingWriter) footer(page listingPage) error {
+	switch {
+	case lw.tmpl != nil:
+		return lw.tmpl.ExecuteTemplate(lw.w, "footer", page)
+	case lw.format == "nginx" || lw.format == "caddy":
+		_, err := io.WriteString(lw.w, "]")
+		return err
+	case lw.format != "jsonl" && lw.format != "csv":
+		end := "]}"
+		if len(page.NextToken) > 0 {
+			next, err := json.Marshal(page.NextToken)
+			if err != nil {
+				return err
+			}
+			end = `],"next":` + string(next) + "}"
+		}
+		_, err := io.WriteString(lw.w, end)
+		return err
+	}
+	return nil
+}
+
+// writeJSON writes an element of a JSON array
+func (lw *listingWriter) writeJSON(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if lw.entries > 0 {
+		b = append([]byte(","), b...)
+	}
+	_, err = lw.w.Write(b)
+	return err
+}
+
+func (lw *listingWriter) writeCSV(record []string) error {
+	writer := csv.NewWriter(lw.w)
+	_ = writer.Write(record)
+	writer.Flush()
+	return writer.Error()
+}
+
+// renderListingData renders a listing page in a machine readable format
+func renderListingData(format string, page listingPage) ([]byte, error) {
+	var buf bytes.Buffer
+	lw := &listingWriter{w: &buf, format: format}
+	if err := lw.header(page); err != nil {
+		return nil, err
+	}
+	if err := lw.write(page.Entries); err != nil {
+		return nil, err
+	}
+	if err := lw.footer(page); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,125 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/patrickdk77/aws-s3-proxy/internal/service"
+)
+
+// maxListingLimit is the most keys S3 returns in a page
+const maxListingLimit = 1000
+
+// listingPageParams are the query parameters selecting a page of a listing
+var listingPageParams = []string{"limit", "after", "continuation-token", "prev"}
+
+// listingPageQuery checks the page parameters of a request and returns them for the cache key
+func listingPageQuery(r *http.Request) (string, error) {
+	query := r.URL.Query()
+	if limit := query.Get("limit"); len(limit) > 0 {
+		if n, err := strconv.Atoi(limit); err != nil || n < 1 || n > maxListingLimit {
+			return "", errors.New("limit must be between 1 and 1000")
+		}
+	}
+	page := url.Values{}
+	for _, param := range append(listingPageParams, listingRecursiveParams...) {
+		if query.Has(param) {
+			page.Set(param, query.Get(param))
+		}
+	}
+	return page.Encode(), nil
+}
+
+// listingPageOptions returns the page a request asks for, nil when it lists the whole directory
+func listingPageOptions(r *http.Request, prefix string) *service.ListOptions {
+	query := r.URL.Query()
+	if !query.Has("limit") && !query.Has("after") && !query.Has("continuation-token") {
+		return nil
+	}
+	opts := &service.ListOptions{MaxKeys: maxListingLimit}
+	if n, err := strconv.Atoi(query.Get("limit")); err == nil {
+		opts.MaxKeys = int32(n)
+	}
+	if token := query.Get("continuation-token"); len(token) > 0 {
+		opts.ContinuationToken = aws.String(token)
+	} else if after := query.Get("after"); len(after) > 0 {
+		// Names are relative to the directory listed
+		opts.StartAfter = aws.String(prefix + strings.TrimPrefix(after, "/"))
+	}
+	return opts
+}
+
+// setListingPages links a page to the first, previous and next ones. S3 only lists forward,
+// so the previous page is only known when the request came from the next link of a page.
+func setListingPages(r *http.Request, page *listingPage, result *s3.ListObjectsV2Output) {
+	query := r.URL.Query()
+	token := query.Get("continuation-token")
+	if aws.ToBool(result.IsTruncated) && result.NextContinuationToken != nil {
+		page.NextToken = aws.ToString(result.NextContinuationToken)
+		next := cloneValues(query)
+		next.Del("after")
+		next.Set("continuation-token", page.NextToken)
+		next.Set("prev", token)
+		page.Next = pageHref(next)
+	}
+	if len(token) == 0 && !query.Has("after") {
+		return
+	}
+	first := cloneValues(query)
+	for _, param := range listingPageParams[1:] {
+		first.Del(param)
+	}
+	page.First = pageHref(first)
+	if prev, ok := query["prev"]; ok {
+		previous := cloneValues(first)
+		if len(prev[0]) > 0 {
+			previous.Set("continuation-token", prev[0])
+		}
+		page.Previous = pageHref(previous)
+	}
+}
+
+// pageHref links to a page of the directory listed
+func pageHref(query url.Values) string {
+	if len(query) == 0 {
+		return "./"
+	}
+	return "?" + query.Encode()
+}
+
+func cloneValues(values url.Values) url.Values {
+	clone := url.Values{}
+	for key, v := range values {
+		clone[key] = append([]string{}, v...)
+	}
+	return clone
+}
+
+// links returns the Link header of a listing page
+func (page listingPage) links() string {
+	var links []string
+	if len(page.First) > 0 {
+		links = append(links, "<"+page.First+`>; rel="first"`)
+	}
+	if len(page.Previous) > 0 {
+		links = append(links, "<"+page.Previous+`>; rel="prev"`)
+	}
+	if len(page.Next) > 0 {
+		links = append(links, "<"+page.Next+`>; rel="next"`)
+	}
+	return strings.Join(links, ", ")
+}
+
+// writeListing answers with a rendered listing
+func writeListing(w http.ResponseWriter, obj cachedResponse) {
+	w.Header().Set("Content-Type", obj.ContentType)
+	if len(obj.Link) > 0 {
+		w.Header().Set("Link", obj.Link)
+	}
+	_, _ = w.Write(obj.Body)
+}
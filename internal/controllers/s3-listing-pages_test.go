@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/patrickdk77/aws-s3-proxy/internal/config"
+	"github.com/patrickdk77/aws-s3-proxy/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestAwsS3_ListingPages(t *testing.T) {
+	mockAWS := new(MockAWS)
+	setupAWS(t, mockAWS)
+	config.Config.DirectoryListing = true
+	config.Config.DirListingFormat = "html"
+
+	object := func(key string) types.Object {
+		return types.Object{Key: aws.String(key), Size: aws.Int64(1), LastModified: aws.Time(time.Unix(0, 0))}
+	}
+	mockAWS.On("S3listPage", mock.Anything, "bucket", "builds/", &service.ListOptions{MaxKeys: 2}).Return(&s3.ListObjectsV2Output{
+		Contents:              []types.Object{object("builds/1"), object("builds/2")},
+		IsTruncated:           aws.Bool(true),
+		NextContinuationToken: aws.String("t1"),
+	}, nil).Once()
+	mockAWS.On("S3listPage", mock.Anything, "bucket", "builds/", &service.ListOptions{MaxKeys: 2, ContinuationToken: aws.String("t1")}).Return(&s3.ListObjectsV2Output{
+		Contents:              []types.Object{object("builds/3"), object("builds/4")},
+		IsTruncated:           aws.Bool(true),
+		NextContinuationToken: aws.String("t2"),
+	}, nil).Once()
+	mockAWS.On("S3listPage", mock.Anything, "bucket", "builds/", &service.ListOptions{MaxKeys: 1000, StartAfter: aws.String("builds/4")}).Return(&s3.ListObjectsV2Output{
+		Contents: []types.Object{object("builds/5")},
+	}, nil).Once()
+
+	req, _ := http.NewRequest("GET", "/builds/?limit=2", nil)
+	rr := httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `<?continuation-token=t1&limit=2&prev=>; rel="next"`, rr.Header().Get("Link"))
+	assert.Contains(t, rr.Body.String(), `<a href="?continuation-token=t1&amp;limit=2&amp;prev=" rel="next">Next</a>`)
+
+	req, _ = http.NewRequest("GET", "/builds/?continuation-token=t1&limit=2&prev=&format=json", nil)
+	rr = httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `<?format=json&limit=2>; rel="first", <?format=json&limit=2>; rel="prev", `+
+		`<?continuation-token=t2&format=json&limit=2&prev=t1>; rel="next"`, rr.Header().Get("Link"))
+	assert.Contains(t, rr.Body.String(), `"next":"t2"`)
+
+	req, _ = http.NewRequest("GET", "/builds/?after=4", nil)
+	rr = httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `<./>; rel="first"`, rr.Header().Get("Link"))
+	assert.Contains(t, rr.Body.String(), `<a href="5">5</a>`)
+
+	req, _ = http.NewRequest("GET", "/builds/?limit=5000", nil)
+	rr = httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	// A directory served by its index document takes any ?limit=
+	config.Config.DirListingCheckIndex = true
+	config.Config.IndexDocument = "index.html"
+	mockAWS.On("S3exists", mock.Anything, "bucket", "/app/index.html").Return(true)
+	mockAWS.On("S3get", mock.Anything, "bucket", "/app/index.html", (*string)(nil), (*service.Conditions)(nil)).Return(&s3.GetObjectOutput{
+		Body:        io.NopCloser(bytes.NewBufferString("<h1>app</h1>")),
+		ContentType: aws.String("text/html"),
+	}, nil)
+	req, _ = http.NewRequest("GET", "/app/?limit=all", nil)
+	rr = httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "<h1>app</h1>", rr.Body.String())
+	mockAWS.AssertExpectations(t)
+}
+
+func TestAwsS3_ListingTruncated(t *testing.T) {
+	mockAWS := new(MockAWS)
+	setupAWS(t, mockAWS)
+	config.Config.DirectoryListing = true
+
+	// Listings cut at 1000 keys say so instead of stopping silently
+	mockAWS.On("S3listObjects", mock.Anything, "bucket", "builds/").Return(&s3.ListObjectsV2Output{
+		IsTruncated:           aws.Bool(true),
+		NextContinuationToken: aws.String("t1"),
+	}, nil).Once()
+
+	req, _ := http.NewRequest("GET", "/builds/", nil)
+	rr := httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `<?continuation-token=t1&prev=>; rel="next"`, rr.Header().Get("Link"))
+	assert.Equal(t, `{"path":"/builds/","entries":[],"next":"t1"}`, rr.Body.String())
+	mockAWS.AssertExpectations(t)
+}
@@ -0,0 +1,154 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/patrickdk77/aws-s3-proxy/internal/config"
+	"github.com/patrickdk77/aws-s3-proxy/internal/glob"
+)
+
+// listingSorts are the values of ?sort=
+var listingSorts = map[string]bool{"name": true, "date": true, "size": true, "version": true}
+
+// apacheColumns maps the ?C= columns of Apache listings to sorts
+var apacheColumns = map[string]string{"N": "name", "M": "date", "S": "size", "D": "name"}
+
+// listingQuery is the sort and filter a request asks of a listing
+type listingQuery struct {
+	sort      string // empty keeps SORT
+	desc      bool
+	search    string
+	match     *glob.Glob // ?q= with wildcards
+	entryType string     // dir or file
+}
+
+// parseListingQuery reads ?sort=, ?order=, ?q= and ?type=, and Apache's ?C= and ?O=
+func parseListingQuery(r *http.Request) (listingQuery, error) {
+	lq := listingQuery{}
+	// Apache separates them with ;, which url.ParseQuery drops
+	for _, param := range strings.FieldsFunc(r.URL.RawQuery, func(c rune) bool { return c == '&' || c == ';' }) {
+		key, value, _ := strings.Cut(param, "=")
+		switch key {
+		case "C":
+			lq.sort = apacheColumns[value]
+		case "O":
+			lq.desc = value == "D"
+		}
+	}
+	query := r.URL.Query()
+	if s := strings.ToLower(query.Get("sort")); len(s) > 0 {
+		if !listingSorts[s] {
+			return lq, errors.New("sort must be name, date, size or version")
+		}
+		lq.sort = s
+	}
+	switch strings.ToLower(query.Get("order")) {
+	case "":
+	case "asc":
+		lq.desc = false
+	case "desc":
+		lq.desc = true
+	default:
+		return lq, errors.New("order must be asc or desc")
+	}
+	if lq.desc && len(lq.sort) == 0 {
+		lq.sort = "name"
+	}
+	lq.search = strings.ToLower(query.Get("q"))
+	if strings.ContainsAny(lq.search, "*?{") {
+		match, err := glob.Compile(lq.search)
+		if err != nil {
+			return lq, err
+		}
+		lq.match = match
+	}
+	switch lq.entryType = strings.ToLower(query.Get("type")); lq.entryType {
+	case "", "dir", "file":
+	default:
+		return lq, errors.New("type must be dir or file")
+	}
+	return lq, nil
+}
+
+// String keys the cached listings of a query
+func (lq listingQuery) String() string {
+	return fmt.Sprintf("%s,%t,%s,%s", lq.sort, lq.desc, lq.entryType, lq.search)
+}
+
+// filter keeps the entries matching ?q= and ?type=
+func (lq listingQuery) filter(files s3objects) s3objects {
+	if len(lq.search) == 0 && len(lq.entryType) == 0 {
+		return files
+	}
+	filtered := s3objects{}
+	for _, file := range files {
+		isDir := strings.HasSuffix(file.file, "/")
+		if (lq.entryType == "dir" && !isDir) || (lq.entryType == "file" && isDir) {
+			continue
+		}
+		name := strings.ToLower(strings.TrimSuffix(file.file, "/"))
+		if lq.match != nil && !lq.match.Match(name) {
+			continue
+		}
+		if lq.match == nil && !strings.Contains(name, lq.search) {
+			continue
+		}
+		filtered = append(filtered, file)
+	}
+	return filtered
+}
+
+// apply filters files and sorts them, directories first as with SORT
+func (lq listingQuery) apply(files s3objects) s3objects {
+	files = lq.filter(files)
+	switch lq.sort {
+	case "name":
+		sort.Sort(sortedObjects{files, &config.Settings{SortFileAsc: !lq.desc, SortFileDesc: lq.desc}})
+	case "date":
+		sort.Sort(sortedObjects{files, &config.Settings{SortDateAsc: !lq.desc, SortDateDesc: lq.desc, SortFileAsc: true}})
+	case "size", "version":
+		sort.SliceStable(files, func(i, j int) bool {
+			iDir, jDir := strings.HasSuffix(files[i].file, "/"), strings.HasSuffix(files[j].file, "/")
+			if iDir != jDir {
+				return iDir
+			}
+			n := compareVersions(files[i].file, files[j].file)
+			if lq.sort == "size" && files[i].size != files[j].size {
+				n = 1
+				if files[i].size < files[j].size {
+					n = -1
+				}
+			}
+			if lq.desc {
+				return n > 0
+			}
+			return n < 0
+		})
+	}
+	return files
+}
+
+// setSortLinks links the columns of a listing to it sorted by them, the current column in the other order
+func (lq listingQuery) setSortLinks(r *http.Request, page *listingPage) {
+	page.Sort, page.Order = lq.sort, "asc"
+	if lq.desc {
+		page.Order = "desc"
+	}
+	page.SortLinks = map[string]string{}
+	for column := range listingSorts {
+		query := cloneValues(r.URL.Query())
+		for _, param := range append([]string{"C", "O"}, listingPageParams[1:]...) {
+			query.Del(param)
+		}
+		query.Set("sort", column)
+		query.Set("order", "asc")
+		if column == lq.sort && !lq.desc {
+			query.Set("order", "desc")
+		}
+		page.SortLinks[column] = pageHref(query)
+	}
+}
@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/patrickdk77/aws-s3-proxy/internal/config"
+	"github.com/patrickdk77/aws-s3-proxy/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func names(files s3objects) []string {
+	result := []string{}
+	for _, file := range files {
+		result = append(result, file.file)
+	}
+	return result
+}
+
+func TestListingQuery(t *testing.T) {
+	files := s3objects{
+		{file: "app-1.10.0.tar.gz", size: 30, updatedAt: time.Unix(100, 0)},
+		{file: "logs/", size: -1},
+		{file: "app-1.9.0.tar.gz", size: 10, updatedAt: time.Unix(300, 0)},
+		{file: "App-2.0.0.zip", size: 20, updatedAt: time.Unix(200, 0)},
+	}
+	for query, expected := range map[string][]string{
+		"?sort=size&order=desc":        {"logs/", "app-1.10.0.tar.gz", "App-2.0.0.zip", "app-1.9.0.tar.gz"},
+		"?sort=date&order=desc":        {"logs/", "app-1.9.0.tar.gz", "App-2.0.0.zip", "app-1.10.0.tar.gz"},
+		"?sort=version":                {"logs/", "app-1.9.0.tar.gz", "app-1.10.0.tar.gz", "App-2.0.0.zip"},
+		"?C=M;O=A":                     {"logs/", "app-1.10.0.tar.gz", "App-2.0.0.zip", "app-1.9.0.tar.gz"},
+		"?C=N;O=D&type=file":           {"app-1.9.0.tar.gz", "app-1.10.0.tar.gz", "App-2.0.0.zip"},
+		"?q=APP-1&sort=name":           {"app-1.10.0.tar.gz", "app-1.9.0.tar.gz"},
+		"?q=*.zip":                     {"App-2.0.0.zip"},
+		"?q=app-{1.9,2}*&sort=version": {"app-1.9.0.tar.gz", "App-2.0.0.zip"},
+		"?type=dir":                    {"logs/"},
+	} {
+		req, _ := http.NewRequest("GET", "/"+query, nil)
+		lq, err := parseListingQuery(req)
+		assert.NoError(t, err, query)
+		assert.Equal(t, expected, names(lq.apply(append(s3objects{}, files...))), query)
+	}
+	for _, query := range []string{"?sort=owner", "?order=up", "?type=link"} {
+		req, _ := http.NewRequest("GET", "/"+query, nil)
+		_, err := parseListingQuery(req)
+		assert.Error(t, err, query)
+	}
+}
+
+func TestAwsS3_ListingSortLinks(t *testing.T) {
+	mockAWS := new(MockAWS)
+	setupAWS(t, mockAWS)
+	config.Config.DirectoryListing = true
+	config.Config.DirListingFormat = "apache"
+
+	mockAWS.On("S3listObjects", mock.Anything, "bucket", "dist/").Return(&s3.ListObjectsV2Output{
+		Contents: []types.Object{
+			{Key: aws.String("dist/a.zip"), Size: aws.Int64(1), LastModified: aws.Time(time.Unix(0, 0))},
+			{Key: aws.String("dist/b.zip"), Size: aws.Int64(2), LastModified: aws.Time(time.Unix(0, 0))},
+		},
+	}, nil).Twice()
+
+	req, _ := http.NewRequest("GET", "/dist/?C=S;O=A", nil)
+	rr := httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	body := rr.Body.String()
+	assert.Contains(t, body, `<th><a href="?order=asc&amp;sort=name">Name</a></th>`)
+	assert.Contains(t, body, `<th><a href="?order=desc&amp;sort=size">Size</a></th>`)
+	assert.Less(t, strings.Index(body, "a.zip"), strings.Index(body, "b.zip"))
+
+	req, _ = http.NewRequest("GET", "/dist/?sort=size&order=desc&q=zip", nil)
+	rr = httptest.NewRecorder()
+	AwsS3(rr, req)
+	body = rr.Body.String()
+	assert.Contains(t, body, `<th><a href="?order=asc&amp;q=zip&amp;sort=size">Size</a></th>`)
+	assert.Greater(t, strings.Index(body, "a.zip"), strings.Index(body, "b.zip"))
+
+	req, _ = http.NewRequest("GET", "/dist/?sort=owner", nil)
+	rr = httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	mockAWS.AssertExpectations(t)
+}
+
+func TestAwsS3_ListingQueryIndexDocument(t *testing.T) {
+	mockAWS := new(MockAWS)
+	setupAWS(t, mockAWS)
+	config.Config.DirectoryListing = true
+	config.Config.DirListingCheckIndex = true
+	config.Config.IndexDocument = "index.html"
+
+	mockAWS.On("S3exists", mock.Anything, "bucket", "/index.html").Return(true)
+	mockAWS.On("S3get", mock.Anything, "bucket", "/index.html", (*string)(nil), (*service.Conditions)(nil)).Return(&s3.GetObjectOutput{
+		Body:        io.NopCloser(bytes.NewBufferString("<h1>home</h1>")),
+		ContentType: aws.String("text/html"),
+	}, nil)
+
+	// The query of a page served by its index document is the site's, not a listing's
+	req, _ := http.NewRequest("GET", "/?type=promo&sort=featured&order=popular", nil)
+	rr := httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "<h1>home</h1>", rr.Body.String())
+	mockAWS.AssertNotCalled(t, "S3listObjects", mock.Anything, mock.Anything, mock.Anything)
+}
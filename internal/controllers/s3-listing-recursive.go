@@ -0,0 +1,98 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/patrickdk77/aws-s3-proxy/internal/config"
+	"github.com/patrickdk77/aws-s3-proxy/internal/glob"
+	"github.com/patrickdk77/aws-s3-proxy/internal/metrics"
+	"github.com/patrickdk77/aws-s3-proxy/internal/service"
+)
+
+// listingRecursiveParams are the query parameters listing a subtree
+var listingRecursiveParams = []string{"recursive", "depth", "search"}
+
+// recursiveQuery is a listing of the subtree of a directory
+type recursiveQuery struct {
+	depth  int        // levels listed, deeper keys are shown as their directory, 0 for all
+	search *glob.Glob // keys to keep, matched from the directory listed
+}
+
+// parseRecursiveQuery reads ?recursive=, ?depth= and ?search=, nil when the request lists a single level
+// or DIRECTORY_LISTINGS_RECURSIVE is off
+func parseRecursiveQuery(r *http.Request, c *config.Settings) (*recursiveQuery, error) {
+	query := r.URL.Query()
+	recursive, _ := strconv.ParseBool(query.Get("recursive"))
+	if !c.DirListingRecursive || (!recursive && !query.Has("search")) {
+		return nil, nil
+	}
+	rq := &recursiveQuery{}
+	if depth := query.Get("depth"); len(depth) > 0 {
+		n, err := strconv.Atoi(depth)
+		if err != nil || n < 1 {
+			return nil, errors.New("depth must be a positive number")
+		}
+		rq.depth = n
+	}
+	if search := query.Get("search"); len(search) > 0 {
+		match, err := glob.Compile(strings.ToLower(search))
+		if err != nil {
+			return nil, err
+		}
+		rq.search, rq.depth = match, 0
+	}
+	return rq, nil
+}
+
+// listRecursive lists the keys below prefix, at most DIRECTORY_LISTINGS_MAX_KEYS or ?limit= of them.
+// The result links to the keys left like a page would.
+func listRecursive(r *http.Request, client service.AWS, c *config.Settings, prefix string, rq *recursiveQuery) (*s3.ListObjectsV2Output, error) {
+	opts := listingPageOptions(r, prefix)
+	maxKeys := c.DirListingMaxKeys
+	if opts == nil {
+		opts = &service.ListOptions{}
+	} else if r.URL.Query().Has("limit") {
+		maxKeys = int(opts.MaxKeys)
+	}
+	opts.Recursive = true
+
+	output := &s3.ListObjectsV2Output{}
+	dirs := map[string]bool{}
+	for scanned := 0; scanned < maxKeys; {
+		opts.MaxKeys = int32(min(maxListingLimit, maxKeys-scanned))
+		result, err := client.S3listPage(r.Context(), c.S3Bucket, prefix, opts)
+		metrics.UpdateS3Reads(err, metrics.ListObjectAction, metrics.ProxySource)
+		if err != nil {
+			return nil, err
+		}
+		scanned += len(result.Contents)
+		for _, obj := range result.Contents {
+			rel := strings.TrimPrefix(aws.ToString(obj.Key), prefix)
+			if rq.search != nil && !rq.search.Match(strings.ToLower(rel)) {
+				continue
+			}
+			// Keys deeper than ?depth= show as their directory at that depth
+			if segments := strings.Split(rel, "/"); rq.depth > 0 && len(segments) > rq.depth {
+				dir := prefix + strings.Join(segments[:rq.depth], "/") + "/"
+				if !dirs[dir] {
+					dirs[dir] = true
+					output.CommonPrefixes = append(output.CommonPrefixes, types.CommonPrefix{Prefix: aws.String(dir)})
+				}
+				continue
+			}
+			output.Contents = append(output.Contents, obj)
+		}
+		output.IsTruncated, output.NextContinuationToken = result.IsTruncated, result.NextContinuationToken
+		if !aws.ToBool(result.IsTruncated) || result.NextContinuationToken == nil {
+			break
+		}
+		opts.ContinuationToken, opts.StartAfter = result.NextContinuationToken, nil
+	}
+	return output, nil
+}
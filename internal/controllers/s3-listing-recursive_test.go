@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/karlseguin/ccache/v3"
+	"github.com/patrickdk77/aws-s3-proxy/internal/config"
+	"github.com/patrickdk77/aws-s3-proxy/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestAwsS3_RecursiveListing(t *testing.T) {
+	mockAWS := new(MockAWS)
+	setupAWS(t, mockAWS)
+	config.Config.DirectoryListing = true
+	config.Config.DirListingFormat = "jsonl"
+	config.Config.DirListingRecursive = true
+	config.Config.DirListingMaxKeys = 3
+
+	object := func(key string) types.Object {
+		return types.Object{Key: aws.String(key), Size: aws.Int64(1), LastModified: aws.Time(time.Unix(0, 0))}
+	}
+	mockAWS.On("S3listPage", mock.Anything, "bucket", "builds/", &service.ListOptions{MaxKeys: 3, Recursive: true}).Return(&s3.ListObjectsV2Output{
+		Contents:              []types.Object{object("builds/1/app.log"), object("builds/1/out/app.zip"), object("builds/2/app.log")},
+		IsTruncated:           aws.Bool(true),
+		NextContinuationToken: aws.String("t1"),
+	}, nil).Twice()
+
+	// Capped at DIRECTORY_LISTINGS_MAX_KEYS, with a link to the rest
+	req, _ := http.NewRequest("GET", "/builds/?recursive=1", nil)
+	rr := httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `{"name":"1/app.log","type":"file","size":1,"mtime":"1970-01-01T00:00:00Z"}`+"\n"+
+		`{"name":"1/out/app.zip","type":"file","size":1,"mtime":"1970-01-01T00:00:00Z"}`+"\n"+
+		`{"name":"2/app.log","type":"file","size":1,"mtime":"1970-01-01T00:00:00Z"}`+"\n", rr.Body.String())
+	assert.Equal(t, `<?continuation-token=t1&prev=&recursive=1>; rel="next"`, rr.Header().Get("Link"))
+
+	// Deeper keys show as their directory
+	req, _ = http.NewRequest("GET", "/builds/?recursive=1&depth=2", nil)
+	rr = httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, `{"name":"1/app.log","type":"file","size":1,"mtime":"1970-01-01T00:00:00Z"}`+"\n"+
+		`{"name":"1/out","type":"directory"}`+"\n"+
+		`{"name":"2/app.log","type":"file","size":1,"mtime":"1970-01-01T00:00:00Z"}`+"\n", rr.Body.String())
+
+	// Search goes on with the next pages up to the cap
+	mockAWS.On("S3listPage", mock.Anything, "bucket", "builds/", &service.ListOptions{MaxKeys: 5, Recursive: true}).Return(&s3.ListObjectsV2Output{
+		Contents:              []types.Object{object("builds/1/app.log"), object("builds/1/out/app.zip")},
+		IsTruncated:           aws.Bool(true),
+		NextContinuationToken: aws.String("t1"),
+	}, nil).Once()
+	mockAWS.On("S3listPage", mock.Anything, "bucket", "builds/", &service.ListOptions{MaxKeys: 3, Recursive: true, ContinuationToken: aws.String("t1")}).Return(&s3.ListObjectsV2Output{
+		Contents: []types.Object{object("builds/2/out/App.ZIP")},
+	}, nil).Once()
+	config.Config.DirListingMaxKeys = 5
+	req, _ = http.NewRequest("GET", "/builds/?search=*/out/*.zip", nil)
+	rr = httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `{"name":"1/out/app.zip","type":"file","size":1,"mtime":"1970-01-01T00:00:00Z"}`+"\n"+
+		`{"name":"2/out/App.ZIP","type":"file","size":1,"mtime":"1970-01-01T00:00:00Z"}`+"\n", rr.Body.String())
+
+	// A directory served by its index document ignores them
+	config.Config.DirListingCheckIndex = true
+	config.Config.IndexDocument = "index.html"
+	mockAWS.On("S3exists", mock.Anything, "bucket", "/docs/index.html").Return(true)
+	mockAWS.On("S3get", mock.Anything, "bucket", "/docs/index.html", (*string)(nil), (*service.Conditions)(nil)).Return(&s3.GetObjectOutput{
+		Body:        io.NopCloser(bytes.NewBufferString("<h1>docs</h1>")),
+		ContentType: aws.String("text/html"),
+	}, nil)
+	req, _ = http.NewRequest("GET", "/docs/?search=foo", nil)
+	rr = httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "<h1>docs</h1>", rr.Body.String())
+
+	// And a single level is listed when DIRECTORY_LISTINGS_RECURSIVE is off
+	config.Config.DirListingRecursive = false
+	mockAWS.On("S3exists", mock.Anything, "bucket", "/builds/index.html").Return(false)
+	mockAWS.On("S3listObjects", mock.Anything, "bucket", "builds/").Return(&s3.ListObjectsV2Output{
+		CommonPrefixes: []types.CommonPrefix{{Prefix: aws.String("builds/1/")}, {Prefix: aws.String("builds/2/")}},
+	}, nil).Times(3)
+	for _, query := range []string{"?search=*.zip", "?depth=2", "?recursive=1"} {
+		req, _ = http.NewRequest("GET", "/builds/"+query, nil)
+		rr = httptest.NewRecorder()
+		AwsS3(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code, query)
+		assert.Equal(t, `{"name":"1","type":"directory"}`+"\n"+`{"name":"2","type":"directory"}`+"\n", rr.Body.String(), query)
+	}
+	mockAWS.AssertExpectations(t)
+}
+
+func TestInvalidateListings(t *testing.T) {
+	httpCache = ccache.New(ccache.Configure[cachedResponse]().MaxSize(10))
+	defer func() { httpCache = nil }()
+	for _, dir := range []string{"/", "/builds/", "/builds/1/", "/other/"} {
+		httpCache.Set(listingCacheKey("bucket", dir, "jsonl&search=*.zip"), cachedResponse{}, time.Minute)
+	}
+
+	invalidateCache("bucket", "/builds/1/app.zip")
+	assert.Nil(t, httpCache.Get(listingCacheKey("bucket", "/", "jsonl&search=*.zip")))
+	assert.Nil(t, httpCache.Get(listingCacheKey("bucket", "/builds/", "jsonl&search=*.zip")))
+	assert.Nil(t, httpCache.Get(listingCacheKey("bucket", "/builds/1/", "jsonl&search=*.zip")))
+	assert.NotNil(t, httpCache.Get(listingCacheKey("bucket", "/other/", "jsonl&search=*.zip")))
+}
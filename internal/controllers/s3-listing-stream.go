@@ -0,0 +1,74 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/patrickdk77/aws-s3-proxy/internal/config"
+	"github.com/patrickdk77/aws-s3-proxy/internal/httperr"
+	"github.com/patrickdk77/aws-s3-proxy/internal/metrics"
+	"github.com/patrickdk77/aws-s3-proxy/internal/service"
+)
+
+// streamListing writes the listing of path as each page of S3 arrives, so only a page is held in memory.
+// Entries keep the order of S3 as sorting needs them all, ?q= and ?type= still apply, and the listing is not cached.
+// Requests with ?sort= or Apache's C= are listed at once instead, so the sort links work.
+func streamListing(w http.ResponseWriter, r *http.Request, client service.AWS, c *config.Settings, path string) {
+	prefix := strings.TrimPrefix(c.S3KeyPrefix+path, "/")
+	opts := &service.ListOptions{MaxKeys: maxListingLimit}
+	result, err := client.S3listPage(r.Context(), c.S3Bucket, prefix, opts)
+	metrics.UpdateS3Reads(err, metrics.ListObjectAction, metrics.ProxySource)
+	if err != nil {
+		code, message := toHTTPError(err)
+		writeS3Error(w, r, client, c, path, code, message)
+		return
+	}
+	tmpl, err := listingTemplate(r, client, c)
+	if err != nil {
+		httperr.Write(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	format := strings.ToLower(c.DirListingFormat)
+	contentType, ok := listingContentTypes[format]
+	if tmpl != nil {
+		contentType = "text/html; charset=utf-8"
+	} else if !ok {
+		contentType = listingContentTypes["json"]
+	}
+	w.Header().Set("Content-Type", contentType)
+
+	page := newListingPage(c, path, nil)
+	lq, _ := parseListingQuery(r)
+	lq.setSortLinks(r, &page)
+	lw := &listingWriter{w: w, format: format, tmpl: tmpl}
+	if err = lw.header(page); err != nil {
+		abortStream(r, err)
+	}
+	for {
+		if err = lw.write(newListingPage(c, path, lq.filter(toS3objects(result, prefix))).Entries); err != nil {
+			abortStream(r, err)
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		if result.IsTruncated == nil || !*result.IsTruncated || result.NextContinuationToken == nil {
+			break
+		}
+		opts.ContinuationToken = result.NextContinuationToken
+		result, err = client.S3listPage(r.Context(), c.S3Bucket, prefix, opts)
+		metrics.UpdateS3Reads(err, metrics.ListObjectAction, metrics.ProxySource)
+		if err != nil {
+			abortStream(r, err)
+		}
+	}
+	if err = lw.footer(page); err != nil {
+		abortStream(r, err)
+	}
+}
+
+// abortStream drops the connection of a listing or archive failing once started, so clients do not take it for complete
+func abortStream(r *http.Request, err error) {
+	log.Printf("[error] %s %s %s: %v", httperr.RequestID(r), r.Method, r.URL.Path, err)
+	panic(http.ErrAbortHandler)
+}
@@ -0,0 +1,101 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/patrickdk77/aws-s3-proxy/internal/config"
+	"github.com/patrickdk77/aws-s3-proxy/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func setupStream(t *testing.T, format string) *MockAWS {
+	mockAWS := new(MockAWS)
+	setupAWS(t, mockAWS)
+	config.Config.DirectoryListing = true
+	config.Config.DirListingStream = true
+	config.Config.DirListingFormat = format
+	config.Config.SortFileDesc = true
+
+	mockAWS.On("S3listPage", mock.Anything, "bucket", "nightly/", &service.ListOptions{MaxKeys: 1000}).Return(&s3.ListObjectsV2Output{
+		CommonPrefixes:        []types.CommonPrefix{{Prefix: aws.String("nightly/logs/")}},
+		Contents:              []types.Object{{Key: aws.String("nightly/a.bin"), Size: aws.Int64(1), LastModified: aws.Time(time.Unix(0, 0))}},
+		IsTruncated:           aws.Bool(true),
+		NextContinuationToken: aws.String("t1"),
+	}, nil).Once()
+	return mockAWS
+}
+
+func TestAwsS3_StreamListing(t *testing.T) {
+	mockAWS := setupStream(t, "jsonl")
+
+	mockAWS.On("S3listPage", mock.Anything, "bucket", "nightly/", &service.ListOptions{MaxKeys: 1000, ContinuationToken: aws.String("t1")}).Return(&s3.ListObjectsV2Output{
+		Contents: []types.Object{{Key: aws.String("nightly/b.bin"), Size: aws.Int64(2), LastModified: aws.Time(time.Unix(0, 0))}},
+	}, nil).Twice()
+
+	// Every page is written in the order of S3, whatever SORT says
+	req, _ := http.NewRequest("GET", "/nightly/", nil)
+	rr := httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.True(t, rr.Flushed)
+	assert.Equal(t, "application/x-ndjson; charset=utf-8", rr.Header().Get("Content-Type"))
+	assert.Equal(t, `{"name":"logs","type":"directory"}`+"\n"+
+		`{"name":"a.bin","type":"file","size":1,"mtime":"1970-01-01T00:00:00Z"}`+"\n"+
+		`{"name":"b.bin","type":"file","size":2,"mtime":"1970-01-01T00:00:00Z"}`+"\n", rr.Body.String())
+
+	// Templates write their blocks around the entries
+	mockAWS.On("S3listPage", mock.Anything, "bucket", "nightly/", &service.ListOptions{MaxKeys: 1000}).Return(&s3.ListObjectsV2Output{
+		Contents:              []types.Object{{Key: aws.String("nightly/a.bin"), Size: aws.Int64(1), LastModified: aws.Time(time.Unix(0, 0))}},
+		IsTruncated:           aws.Bool(true),
+		NextContinuationToken: aws.String("t1"),
+	}, nil).Once()
+	config.Config.DirListingFormat = "shtml"
+	req, _ = http.NewRequest("GET", "/nightly/", nil)
+	rr = httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, `<!DOCTYPE html><html><body><a href="a.bin">a.bin</a><br><a href="b.bin">b.bin</a><br></body></html>`, rr.Body.String())
+	mockAWS.AssertExpectations(t)
+}
+
+func TestAwsS3_StreamListingSorted(t *testing.T) {
+	mockAWS := setupStream(t, "jsonl")
+	mockAWS.ExpectedCalls = nil
+
+	// A sort asked for lists the directory at once
+	mockAWS.On("S3listObjects", mock.Anything, "bucket", "nightly/").Return(&s3.ListObjectsV2Output{
+		Contents: []types.Object{
+			{Key: aws.String("nightly/big.bin"), Size: aws.Int64(9), LastModified: aws.Time(time.Unix(0, 0))},
+			{Key: aws.String("nightly/small.bin"), Size: aws.Int64(1), LastModified: aws.Time(time.Unix(0, 0))},
+		},
+	}, nil).Once()
+	req, _ := http.NewRequest("GET", "/nightly/?sort=size&order=asc", nil)
+	rr := httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.False(t, rr.Flushed)
+	assert.Equal(t, `{"name":"small.bin","type":"file","size":1,"mtime":"1970-01-01T00:00:00Z"}`+"\n"+
+		`{"name":"big.bin","type":"file","size":9,"mtime":"1970-01-01T00:00:00Z"}`+"\n", rr.Body.String())
+	mockAWS.AssertExpectations(t)
+}
+
+func TestAwsS3_StreamListingAborts(t *testing.T) {
+	mockAWS := setupStream(t, "json")
+
+	mockAWS.On("S3listPage", mock.Anything, "bucket", "nightly/", &service.ListOptions{MaxKeys: 1000, ContinuationToken: aws.String("t1")}).Return(
+		nil, errors.New("connection reset")).Once()
+
+	req, _ := http.NewRequest("GET", "/nightly/", nil)
+	rr := httptest.NewRecorder()
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() { AwsS3(rr, req) })
+	assert.Equal(t, `{"path":"/nightly/","entries":[{"name":"logs","type":"directory"},{"name":"a.bin","type":"file","size":1,"mtime":"1970-01-01T00:00:00Z"}`,
+		rr.Body.String())
+	mockAWS.AssertExpectations(t)
+}
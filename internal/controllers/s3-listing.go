@@ -0,0 +1,198 @@
+package controllers
+
+import (
+	"bytes"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/karlseguin/ccache/v3"
+	"github.com/patrickdk77/aws-s3-proxy/internal/config"
+	"github.com/patrickdk77/aws-s3-proxy/internal/metrics"
+	"github.com/patrickdk77/aws-s3-proxy/internal/service"
+)
+
+// maxTemplateSize bounds the listing templates read into memory
+const maxTemplateSize = 1024 * 1024
+
+// listingPage is the model directory listing templates render
+type listingPage struct {
+	Path        string // directory listed, like /docs/
+	Parent      string // link to the parent directory, empty at the root
+	Breadcrumbs []breadcrumb
+	Entries     []listingEntry
+	Upload      bool   // DIRECTORY_LISTINGS_UPLOAD is on
+	Accept      string // extensions uploads accept
+	First       string // link to the first page, empty on it
+	Previous    string // link to the page before, when known
+	Next        string // link to the next page, empty on the last one
+	NextToken   string // continuation token of the next page
+	Sort        string // name, date, size or version when asked with ?sort=
+	Order       string // asc or desc
+	SortLinks   map[string]string
+	Downloads   []download // links to archives of the directory, with DIRECTORY_LISTINGS_ARCHIVE
+}
+
+type breadcrumb struct {
+	Name string
+	Link string
+}
+
+type download struct {
+	Format string // zip
+	Link   string
+}
+
+type listingEntry struct {
+	Name         string
+	Link         string
+	Size         int64 // -1 for directories
+	HumanSize    string
+	ModTime      time.Time
+	ETag         string
+	StorageClass string
+	IsDir        bool
+}
+
+// The built-in listings define header, entry and footer blocks, templates may redefine any of them
+const (
+	uploadTemplate = `{{define "upload"}}{{if .Upload}}<form method="POST" enctype="multipart/form-data">` +
+		`<input type="file" name="file" multiple{{if .Accept}} accept="{{.Accept}}"{{end}}> <input type="submit" value="Upload"></form>{{end}}{{end}}`
+	pagesTemplate = `{{define "pages"}}{{if or .First .Next}}<nav>{{if .First}}<a href="{{.First}}">First</a> {{end}}` +
+		`{{if .Previous}}<a href="{{.Previous}}" rel="prev">Previous</a> {{end}}{{if .Next}}<a href="{{.Next}}" rel="next">Next</a>{{end}}</nav>{{end}}{{end}}`
+	downloadsTemplate = `{{define "downloads"}}{{if .Downloads}}<p>Download{{range .Downloads}} <a href="{{.Link}}" download>{{.Format}}</a>{{end}}</p>{{end}}{{end}}`
+	htmlTemplate      = uploadTemplate + pagesTemplate + downloadsTemplate +
+		`{{block "header" .}}<!DOCTYPE html><html><head><meta name="viewport" content="width=device-width, initial-scale=1"></head><body><ul>{{end}}` +
+		`{{range .Entries}}{{block "entry" .}}<li><a href="{{.Link}}">{{.Name}}</a>` +
+		`{{if not .ModTime.IsZero}} {{.ModTime.Format "2006-01-02T15:04:05Z07:00"}}{{end}}</li>{{end}}{{end}}` +
+		`{{block "footer" .}}</ul>{{template "pages" .}}{{template "downloads" .}}{{template "upload" .}}</body></html>{{end}}`
+	apacheTemplate = uploadTemplate + pagesTemplate + downloadsTemplate +
+		`{{block "header" .}}<!DOCTYPE html><html><head><meta name="viewport" content="width=device-width, initial-scale=1"><title>Index of {{.Path}}</title></head>` +
+		`<body><h1>Index of {{.Path}}</h1><pre><table><tr>` +
+		`<th><a href="{{.SortLinks.name}}">Name</a></th><th><a href="{{.SortLinks.date}}">Last Modified</a></th><th><a href="{{.SortLinks.size}}">Size</a></th></tr>{{end}}` +
+		`{{range .Entries}}{{block "entry" .}}<tr><td><a href="{{.Link}}">{{.Name}}</a></td>` +
+		`<td>{{if .ModTime.IsZero}}-{{else}}{{.ModTime.Format "2006-01-02T15:04:05Z07:00"}}{{end}}</td><td>{{.HumanSize}}</td></tr>{{end}}{{end}}` +
+		`{{block "footer" .}}</table><hr></pre>{{template "pages" .}}{{template "downloads" .}}{{template "upload" .}}</body></html>{{end}}`
+	simpleHTMLTemplate = uploadTemplate +
+		`{{block "header" .}}<!DOCTYPE html><html><body>{{end}}` +
+		`{{range .Entries}}{{block "entry" .}}<a href="{{.Link}}">{{.Name}}</a><br>{{end}}{{end}}` +
+		`{{block "footer" .}}</body></html>{{end}}`
+)
+
+var builtinListings = map[string]*template.Template{
+	"html":   template.Must(template.New("html").Parse(htmlTemplate)),
+	"apache": template.Must(template.New("apache").Parse(apacheTemplate)),
+	"shtml":  template.Must(template.New("shtml").Parse(simpleHTMLTemplate)),
+}
+
+// listingTemplates holds the parsed DIRECTORY_LISTINGS_TEMPLATE of every site
+var listingTemplates = ccache.New(ccache.Configure[*template.Template]().MaxSize(128))
+
+// listingTemplate returns the template of a listing format, nil when it renders JSON
+func listingTemplate(r *http.Request, client service.AWS, c *config.Settings) (*template.Template, error) {
+	format := strings.ToLower(c.DirListingFormat)
+	if format == "template" {
+		return loadListingTemplate(r, client, c.DirListingTemplate, c.CacheTTLIndex)
+	}
+	return builtinListings[format], nil
+}
+
+// loadListingTemplate parses a template from a local file or an s3://bucket/key object over the html listing,
+// so it may only redefine some of its blocks
+func loadListingTemplate(r *http.Request, client service.AWS, source string, ttl time.Duration) (*template.Template, error) {
+	item, err := listingTemplates.Fetch(source, ttl, func() (*template.Template, error) {
+		var body []byte
+		var err error
+		if location, ok := strings.CutPrefix(source, "s3://"); ok {
+			bucket, key, _ := strings.Cut(location, "/")
+			obj, err := client.S3get(r.Context(), bucket, key, nil, nil)
+			metrics.UpdateS3Reads(err, metrics.GetObjectAction, metrics.ProxySource)
+			if err != nil {
+				return nil, err
+			}
+			defer obj.Body.Close()
+			body, err = io.ReadAll(io.LimitReader(obj.Body, maxTemplateSize))
+			if err != nil {
+				return nil, err
+			}
+		} else if body, err = os.ReadFile(source); err != nil { // nolint:gosec
+			return nil, err
+		}
+		tmpl, err := template.New("template").Parse(htmlTemplate)
+		if err != nil {
+			return nil, err
+		}
+		return tmpl.Parse(string(body))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return item.Value(), nil
+}
+
+// newListingPage builds the model of the listing of path
+func newListingPage(c *config.Settings, path string, files s3objects) listingPage {
+	page := listingPage{
+		Path:        path,
+		Breadcrumbs: []breadcrumb{{Name: "/", Link: c.StripPath + "/"}},
+		Upload:      c.WriteEnabled && c.DirListingUpload,
+		Accept:      strings.Join(c.UploadExtensions, ","),
+	}
+	link := c.StripPath + "/"
+	for _, name := range strings.Split(strings.Trim(path, "/"), "/") {
+		if len(name) == 0 {
+			continue
+		}
+		page.Parent = link
+		link += url.PathEscape(name) + "/"
+		page.Breadcrumbs = append(page.Breadcrumbs, breadcrumb{Name: name, Link: link})
+	}
+	if c.DirListingArchive {
+		for _, format := range archiveFormats {
+			page.Downloads = append(page.Downloads, download{Format: format, Link: "?download=" + format})
+		}
+	}
+	for _, file := range files {
+		page.Entries = append(page.Entries, listingEntry{
+			Name:         file.file,
+			Link:         (&url.URL{Path: file.file}).String(),
+			Size:         file.size,
+			HumanSize:    humanSize(file.size),
+			ModTime:      file.updatedAt,
+			ETag:         strings.Trim(file.etag, `"`),
+			StorageClass: file.storageClass,
+			IsDir:        strings.HasSuffix(file.file, "/"),
+		})
+	}
+	return page
+}
+
+// renderListing renders a listing page with tmpl
+func renderListing(tmpl *template.Template, page listingPage) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, page); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// humanSize shortens sizes over 2000 to k, M and G units, directories have none
+func humanSize(size int64) string {
+	if size < 0 {
+		return "-"
+	}
+	unit := ""
+	for _, next := range []string{"k", "M", "G"} {
+		if size <= 2000 {
+			break
+		}
+		unit = next
+		size /= 1024
+	}
+	return strconv.FormatInt(size, 10) + unit
+}
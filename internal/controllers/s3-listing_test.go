@@ -0,0 +1,169 @@
+package controllers
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/patrickdk77/aws-s3-proxy/internal/config"
+	"github.com/patrickdk77/aws-s3-proxy/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestNewListingPage(t *testing.T) {
+	c := &config.Settings{StripPath: "/files", WriteEnabled: true, DirListingUpload: true, UploadExtensions: []string{".pdf"}}
+	page := newListingPage(c, "/docs/a b/", s3objects{
+		{file: "sub/", size: -1},
+		{file: "report #1.pdf", size: 3 * 1024 * 1024, etag: `"abc"`, storageClass: "STANDARD"},
+	})
+	assert.Equal(t, "/files/docs/", page.Parent)
+	assert.Equal(t, []breadcrumb{{"/", "/files/"}, {"docs", "/files/docs/"}, {"a b", "/files/docs/a%20b/"}}, page.Breadcrumbs)
+	assert.True(t, page.Upload)
+	assert.Equal(t, ".pdf", page.Accept)
+	assert.Equal(t, listingEntry{Name: "sub/", Link: "sub/", Size: -1, HumanSize: "-", IsDir: true}, page.Entries[0])
+	assert.Equal(t, "report%20%231.pdf", page.Entries[1].Link)
+	assert.Equal(t, "3M", page.Entries[1].HumanSize)
+	assert.Equal(t, "abc", page.Entries[1].ETag)
+
+	page = newListingPage(&config.Settings{}, "/", nil)
+	assert.Empty(t, page.Parent)
+	assert.Equal(t, []breadcrumb{{"/", "/"}}, page.Breadcrumbs)
+}
+
+func setupListing(t *testing.T, format string) *MockAWS {
+	mockAWS := new(MockAWS)
+	setupAWS(t, mockAWS)
+	config.Config.DirectoryListing = true
+	config.Config.DirListingFormat = format
+
+	mockAWS.On("S3listObjects", mock.Anything, "bucket", "uploads/").Return(&s3.ListObjectsV2Output{
+		Contents: []types.Object{{Key: aws.String(`uploads/<img src=x onerror=alert(1)>.html`), Size: aws.Int64(1),
+			LastModified: aws.Time(time.Unix(0, 0)), StorageClass: types.ObjectStorageClassGlacier}},
+	}, nil)
+	return mockAWS
+}
+
+func TestAwsS3_ListingEscapesNames(t *testing.T) {
+	mockAWS := setupListing(t, "")
+
+	for _, format := range []string{"html", "apache", "shtml"} {
+		config.Config.DirListingFormat = format
+		req, _ := http.NewRequest("GET", "/uploads/", nil)
+		rr := httptest.NewRecorder()
+		AwsS3(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code, format)
+		assert.NotContains(t, rr.Body.String(), "<img", format)
+		assert.Contains(t, rr.Body.String(), "&lt;img src=x onerror=alert(1)&gt;.html", format)
+	}
+	mockAWS.AssertExpectations(t)
+}
+
+func TestAwsS3_ListingTemplate(t *testing.T) {
+	mockAWS := setupListing(t, "template")
+
+	// Templates may only redefine some blocks of the html listing
+	config.Config.DirListingTemplate = filepath.Join(t.TempDir(), "listing.html")
+	_ = os.WriteFile(config.Config.DirListingTemplate, []byte(
+		`{{define "entry"}}<li class="{{.StorageClass}}">{{.Name}} {{.HumanSize}}</li>{{end}}`), 0o600)
+
+	req, _ := http.NewRequest("GET", "/uploads/", nil)
+	rr := httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "text/html; charset=utf-8", rr.Header().Get("Content-Type"))
+	assert.Contains(t, rr.Body.String(), `<ul><li class="GLACIER">&lt;img src=x onerror=alert(1)&gt;.html 1</li></ul>`)
+
+	// or replace the whole page, from a bucket
+	config.Config.DirListingTemplate = "s3://templates/listing.html"
+	mockAWS.On("S3get", mock.Anything, "templates", "listing.html", (*string)(nil), (*service.Conditions)(nil)).Return(&s3.GetObjectOutput{
+		Body: io.NopCloser(bytes.NewBufferString(`<h1>{{.Path}}</h1>{{range .Entries}}{{.Link}}{{end}}`)),
+	}, nil).Once()
+	req, _ = http.NewRequest("GET", "/uploads/", nil)
+	rr = httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `<h1>/uploads/</h1>%3Cimg%20src=x%20onerror=alert%281%29%3E.html`, rr.Body.String())
+	mockAWS.AssertExpectations(t)
+}
+
+func TestAwsS3_ListingFormats(t *testing.T) {
+	mockAWS := new(MockAWS)
+	setupAWS(t, mockAWS)
+	config.Config.DirectoryListing = true
+	config.Config.DirListingFormat = "html"
+
+	mockAWS.On("S3listObjects", mock.Anything, "bucket", "pub/").Return(&s3.ListObjectsV2Output{
+		CommonPrefixes: []types.CommonPrefix{{Prefix: aws.String("pub/docs/")}},
+		Contents: []types.Object{{Key: aws.String("pub/a,b.txt"), Size: aws.Int64(12), LastModified: aws.Time(time.Unix(0, 0)),
+			ETag: aws.String(`"e1"`), StorageClass: types.ObjectStorageClassStandard}},
+	}, nil)
+
+	for _, tc := range []struct {
+		query, accept, contentType, body string
+	}{
+		{"", "text/html,application/xhtml+xml,*/*;q=0.8", "text/html; charset=utf-8", `<a href="docs/">docs/</a>`},
+		{"", "application/json", "application/json; charset=utf-8",
+			`{"path":"/pub/","entries":[{"name":"docs","type":"directory"},` +
+				`{"name":"a,b.txt","type":"file","size":12,"mtime":"1970-01-01T00:00:00Z","etag":"e1","storage_class":"STANDARD"}]}`},
+		{"?format=jsonl", "", "application/x-ndjson; charset=utf-8",
+			`{"name":"docs","type":"directory"}` + "\n" +
+				`{"name":"a,b.txt","type":"file","size":12,"mtime":"1970-01-01T00:00:00Z","etag":"e1","storage_class":"STANDARD"}` + "\n"},
+		{"", "text/csv", "text/csv; charset=utf-8",
+			"name,type,size,mtime,etag,storage_class\ndocs,directory,,,,\n\"a,b.txt\",file,12,1970-01-01T00:00:00Z,e1,STANDARD\n"},
+		{"?format=nginx", "", "application/json; charset=utf-8",
+			`[{"name":"docs","type":"directory","mtime":"Mon, 01 Jan 0001 00:00:00 GMT"},` +
+				`{"name":"a,b.txt","type":"file","mtime":"Thu, 01 Jan 1970 00:00:00 GMT","size":12}]`},
+		{"?format=caddy", "", "application/json; charset=utf-8",
+			`[{"name":"docs/","size":0,"url":"./docs/","mod_time":"0001-01-01T00:00:00Z","mode":2147484141,"is_dir":true,"is_symlink":false},` +
+				`{"name":"a,b.txt","size":12,"url":"./a,b.txt","mod_time":"1970-01-01T00:00:00Z","mode":420,"is_dir":false,"is_symlink":false}]`},
+	} {
+		req, _ := http.NewRequest("GET", "/pub/"+tc.query, nil)
+		req.Header.Set("Accept", tc.accept)
+		rr := httptest.NewRecorder()
+		AwsS3(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code, tc.query+tc.accept)
+		assert.Equal(t, tc.contentType, rr.Header().Get("Content-Type"), tc.query+tc.accept)
+		assert.Contains(t, rr.Body.String(), tc.body, tc.query+tc.accept)
+		assert.Equal(t, "Accept", rr.Header().Get("Vary"))
+	}
+
+	req, _ := http.NewRequest("GET", "/pub/?format=xml", nil)
+	rr := httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	// Browsers keep the default listing when DIRECTORY_LISTINGS_FORMAT is not set
+	config.Config.DirListingFormat = ""
+	req, _ = http.NewRequest("GET", "/pub/", nil)
+	rr = httptest.NewRecorder()
+	AwsS3(rr, req)
+	expected := rr.Body.String()
+	req, _ = http.NewRequest("GET", "/pub/", nil)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,*/*;q=0.8")
+	rr = httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, expected, rr.Body.String())
+
+	// A directory served by its index document takes any ?format=
+	config.Config.DirListingCheckIndex = true
+	config.Config.IndexDocument = "index.html"
+	mockAWS.On("S3exists", mock.Anything, "bucket", "/site/index.html").Return(true)
+	mockAWS.On("S3get", mock.Anything, "bucket", "/site/index.html", (*string)(nil), (*service.Conditions)(nil)).Return(&s3.GetObjectOutput{
+		Body:        io.NopCloser(bytes.NewBufferString("<h1>site</h1>")),
+		ContentType: aws.String("text/html"),
+	}, nil)
+	req, _ = http.NewRequest("GET", "/site/?format=xml", nil)
+	rr = httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "<h1>site</h1>", rr.Body.String())
+}
@@ -0,0 +1,156 @@
+package controllers
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/karlseguin/ccache/v3"
+	"github.com/patrickdk77/aws-s3-proxy/internal/config"
+	"github.com/patrickdk77/aws-s3-proxy/internal/httperr"
+	"github.com/patrickdk77/aws-s3-proxy/internal/manifest"
+	"github.com/patrickdk77/aws-s3-proxy/internal/metrics"
+	"github.com/patrickdk77/aws-s3-proxy/internal/service"
+)
+
+const (
+	redirectsKey = "/_redirects"
+	headersKey   = "/_headers"
+	// maxManifestSize bounds the manifests read into memory
+	maxManifestSize = 1024 * 1024
+	// manifestRetryTTL is how long a site is served without the manifests it failed to read
+	manifestRetryTTL = 10 * time.Second
+)
+
+// manifestCache holds the manifests of every site, whether or not CACHE_SIZE enables httpCache
+var manifestCache = ccache.New(ccache.Configure[*siteManifest]().MaxSize(1024))
+
+// siteManifest holds the _redirects and _headers files deployed at the root of a site
+type siteManifest struct {
+	redirects []manifest.Redirect
+	headers   []manifest.HeaderRule
+}
+
+func manifestCacheKey(bucket, prefix string) string {
+	return bucket + ":" + prefix
+}
+
+// loadManifest returns the manifests of the site, nil when NETLIFY_MANIFESTS is off and empty when they cannot be read
+func loadManifest(r *http.Request, client service.AWS, c *config.Settings) *siteManifest {
+	if !c.Manifests {
+		return nil
+	}
+	cacheKey := manifestCacheKey(c.S3Bucket, c.S3KeyPrefix)
+	item, err := manifestCache.Fetch(cacheKey, c.ManifestTTL, func() (*siteManifest, error) {
+		m := &siteManifest{}
+		body, err := readManifest(r, client, c.S3Bucket, c.S3KeyPrefix+redirectsKey)
+		if err != nil {
+			return nil, err
+		}
+		if m.redirects, err = manifest.ParseRedirects(strings.NewReader(body)); err != nil {
+			log.Printf("[manifest] %s%s: %v", c.S3Bucket, c.S3KeyPrefix, err)
+		}
+		if body, err = readManifest(r, client, c.S3Bucket, c.S3KeyPrefix+headersKey); err != nil {
+			return nil, err
+		}
+		if m.headers, err = manifest.ParseHeaders(strings.NewReader(body)); err != nil {
+			log.Printf("[manifest] %s%s: %v", c.S3Bucket, c.S3KeyPrefix, err)
+		}
+		return m, nil
+	})
+	if err != nil {
+		// Tried again after a while, not by every request while S3 fails
+		log.Printf("[manifest] %s%s: %v", c.S3Bucket, c.S3KeyPrefix, err)
+		m := &siteManifest{}
+		manifestCache.Set(cacheKey, m, min(manifestRetryTTL, c.ManifestTTL))
+		return m
+	}
+	return item.Value()
+}
+
+// readManifest returns the content of a manifest, empty when the site has none
+func readManifest(r *http.Request, client service.AWS, bucket, key string) (string, error) {
+	obj, err := client.S3get(r.Context(), bucket, key, nil, nil)
+	metrics.UpdateS3Reads(err, metrics.GetObjectAction, metrics.ProxySource)
+	if err != nil {
+		if code, _ := toHTTPError(err); code == http.StatusNotFound {
+			return "", nil
+		}
+		return "", err
+	}
+	defer obj.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(obj.Body, maxManifestSize))
+	return string(body), err
+}
+
+// isManifest reports if path is one of the manifests, they are not served
+func isManifest(path string) bool {
+	return path == redirectsKey || path == headersKey
+}
+
+// redirect applies the first matching rule of _redirects. Forced rules apply before the object is
+// fetched and the others once it turned out missing, so objects shadow them as on Netlify.
+// A forced rewrite returns the path to serve instead.
+func (m *siteManifest) redirect(w http.ResponseWriter, r *http.Request, client service.AWS, c *config.Settings,
+	path string, missing bool) (string, bool) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return "", false
+	}
+	for _, rule := range m.redirects {
+		if rule.Force == missing {
+			continue
+		}
+		target, ok := rule.Match(path, r.URL.Query())
+		if !ok {
+			continue
+		}
+		switch {
+		case rule.Status >= 300 && rule.Status < 400:
+			if strings.HasPrefix(target, "/") {
+				target = c.StripPath + target
+			}
+			http.Redirect(w, r, target, rule.Status)
+		case rule.Status == http.StatusOK && !missing:
+			return target, false
+		default:
+			serveRewrite(w, r, client, c, target, rule.Status)
+		}
+		return "", true
+	}
+	return "", false
+}
+
+// serveRewrite answers with the object at target and the status of a rewrite rule
+func serveRewrite(w http.ResponseWriter, r *http.Request, client service.AWS, c *config.Settings, target string, status int) {
+	target, _, _ = strings.Cut(target, "?")
+	if strings.HasSuffix(target, "/") {
+		target += c.IndexDocument
+	}
+	var obj interface{}
+	var err error
+	if r.Method == http.MethodHead {
+		obj, err = client.S3head(r.Context(), c.S3Bucket, c.S3KeyPrefix+target, nil, nil)
+	} else {
+		obj, err = client.S3get(r.Context(), c.S3Bucket, c.S3KeyPrefix+target, nil, nil)
+		metrics.UpdateS3Reads(err, metrics.GetObjectAction, metrics.ProxySource)
+	}
+	if err != nil {
+		code, message := toHTTPError(err)
+		httperr.Write(w, r, code, message)
+		return
+	}
+	setHeadersFromAwsResponse(w, obj, c)
+	w.WriteHeader(status)
+	if o, ok := obj.(*s3.GetObjectOutput); ok {
+		_, _ = io.Copy(w, o.Body)
+		o.Body.Close()
+	}
+}
+
+// headersFor returns the _headers headers for path
+func (m *siteManifest) headersFor(path string) http.Header {
+	return manifest.HeadersFor(m.headers, path)
+}
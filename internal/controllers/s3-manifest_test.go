@@ -0,0 +1,139 @@
+package controllers
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	"github.com/patrickdk77/aws-s3-proxy/internal/config"
+	"github.com/patrickdk77/aws-s3-proxy/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func setupManifest(t *testing.T, redirects, headers string) *MockAWS {
+	mockAWS := new(MockAWS)
+	setupAWS(t, mockAWS)
+	config.Config.S3KeyPrefix = "/site"
+	config.Config.SPA = false
+	config.Config.Manifests = true
+	config.Config.ManifestTTL = 1 * time.Minute
+
+	for key, body := range map[string]string{"/site/_redirects": redirects, "/site/_headers": headers} {
+		mockAWS.On("S3get", mock.Anything, "bucket", key, (*string)(nil), (*service.Conditions)(nil)).Return(&s3.GetObjectOutput{
+			Body: io.NopCloser(bytes.NewBufferString(body)),
+		}, nil).Once()
+	}
+	return mockAWS
+}
+
+func TestAwsS3_ManifestRedirects(t *testing.T) {
+	mockAWS := setupManifest(t, "/old/* /new/:splat 301!\n/app/* /app/index.html 200\n", "")
+
+	// Forced rules apply before S3 is asked
+	req, _ := http.NewRequest("GET", "/old/page.html", nil)
+	rr := httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusMovedPermanently, rr.Code)
+	assert.Equal(t, "/new/page.html", rr.Header().Get("Location"))
+
+	// Others once the object is missing
+	notFound := &smithy.GenericAPIError{Code: "NoSuchKey"}
+	mockAWS.On("S3get", mock.Anything, "bucket", "/site/app/users/1", (*string)(nil), (*service.Conditions)(nil)).Return(nil, notFound).Once()
+	mockAWS.On("S3get", mock.Anything, "bucket", "/site/app/index.html", (*string)(nil), (*service.Conditions)(nil)).Return(&s3.GetObjectOutput{
+		Body:        io.NopCloser(bytes.NewBufferString("app")),
+		ContentType: aws.String("text/html"),
+	}, nil).Once()
+	req, _ = http.NewRequest("GET", "/app/users/1", nil)
+	rr = httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "app", rr.Body.String())
+
+	// The manifests themselves are not served
+	req, _ = http.NewRequest("GET", "/_redirects", nil)
+	rr = httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	mockAWS.AssertExpectations(t)
+}
+
+func TestAwsS3_ManifestWrite(t *testing.T) {
+	mockAWS := setupManifest(t, "/old/* /new/:splat 301!\n", "")
+	config.Config.WriteEnabled = true
+
+	req, _ := http.NewRequest("GET", "/_redirects", nil)
+	rr := httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.NotNil(t, manifestCache.Get(manifestCacheKey("bucket", "/site")))
+
+	mockAWS.On("S3put", mock.Anything, "bucket", "/site/_redirects", mock.Anything, mock.Anything).Return(aws.String(`"etag"`), nil).Once()
+	req, _ = http.NewRequest("PUT", "/_redirects", strings.NewReader("/old/* /newer/:splat 301!\n"))
+	rr = httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.Nil(t, manifestCache.Get(manifestCacheKey("bucket", "/site")))
+
+	// Read again by the next request
+	for _, key := range []string{"/site/_redirects", "/site/_headers"} {
+		mockAWS.On("S3get", mock.Anything, "bucket", key, (*string)(nil), (*service.Conditions)(nil)).Return(&s3.GetObjectOutput{
+			Body: io.NopCloser(bytes.NewBufferString("")),
+		}, nil).Once()
+	}
+	mockAWS.On("S3delete", mock.Anything, "bucket", "/site/_headers").Return(nil).Once()
+	req, _ = http.NewRequest("DELETE", "/_headers", nil)
+	rr = httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	mockAWS.AssertExpectations(t)
+}
+
+func TestAwsS3_ManifestUnreadable(t *testing.T) {
+	mockAWS := new(MockAWS)
+	setupAWS(t, mockAWS)
+	config.Config.SPA = false
+	config.Config.Manifests = true
+	config.Config.ManifestTTL = 1 * time.Minute
+
+	denied := &smithy.GenericAPIError{Code: "AccessDenied", Message: "Access Denied"}
+	mockAWS.On("S3get", mock.Anything, "bucket", "/_redirects", (*string)(nil), (*service.Conditions)(nil)).Return(nil, denied).Once()
+
+	// The failure is remembered, not read again by every request
+	for range 2 {
+		mockAWS.On("S3get", mock.Anything, "bucket", "/index.txt", (*string)(nil), (*service.Conditions)(nil)).Return(&s3.GetObjectOutput{
+			Body: io.NopCloser(bytes.NewBufferString("index")),
+		}, nil).Once()
+		req, _ := http.NewRequest("GET", "/index.txt", nil)
+		rr := httptest.NewRecorder()
+		AwsS3(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "index", rr.Body.String())
+	}
+	mockAWS.AssertExpectations(t)
+}
+
+func TestAwsS3_ManifestHeaders(t *testing.T) {
+	mockAWS := setupManifest(t, "", "/assets/*\n  Cache-Control: public, max-age=31536000, immutable\n  X-Frame-Options: DENY\n")
+
+	mockAWS.On("S3get", mock.Anything, "bucket", "/site/assets/app.js", (*string)(nil), (*service.Conditions)(nil)).Return(&s3.GetObjectOutput{
+		Body:         io.NopCloser(bytes.NewBufferString("js")),
+		CacheControl: aws.String("no-cache"),
+	}, nil).Once()
+
+	req, _ := http.NewRequest("GET", "/assets/app.js", nil)
+	rr := httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, []string{"public, max-age=31536000, immutable"}, rr.Header().Values("Cache-Control"))
+	assert.Equal(t, "DENY", rr.Header().Get("X-Frame-Options"))
+	assert.Equal(t, "js", rr.Body.String())
+	mockAWS.AssertExpectations(t)
+}
@@ -1,13 +1,49 @@
 package controllers
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
 	"net/http"
+	"net/textproto"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/patrickdk77/aws-s3-proxy/internal/config"
+	"github.com/patrickdk77/aws-s3-proxy/internal/httperr"
+	"github.com/patrickdk77/aws-s3-proxy/internal/metrics"
+	"github.com/patrickdk77/aws-s3-proxy/internal/service"
 )
 
+// maxRanges bounds the parts of a multipart/byteranges response, every part of
+// an uncached object is its own S3 request
+const maxRanges = 32
+
+var (
+	errInvalidRange = errors.New("invalid range")
+	errNoOverlap    = errors.New("invalid range: failed to overlap")
+)
+
+// httpRange specifies the byte range to be sent to the client
+type httpRange struct {
+	start, length int64
+}
+
+func (ra httpRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", ra.start, ra.start+ra.length-1, size)
+}
+
+func (ra httpRange) mimeHeader(contentType string, size int64) textproto.MIMEHeader {
+	return textproto.MIMEHeader{
+		"Content-Range": {ra.contentRange(size)},
+		"Content-Type":  {contentType},
+	}
+}
+
 func determineHTTPStatus(obj *s3.GetObjectOutput) int {
 	if obj.ContentRange != nil && len(*obj.ContentRange) > 0 {
 		if !totalFileSizeEqualToContentRange(obj) {
@@ -37,3 +73,229 @@ func getFileSizeAsString(obj *s3.GetObjectOutput) string {
 	}
 	return ""
 }
+
+/*
+ * parseRange parses a Range header string as per https://www.rfc-editor.org/rfc/rfc9110#section-14.2
+ * errInvalidRange is returned for headers that should be ignored, and errNoOverlap
+ * when none of the ranges can be satisfied for an object of the given size.
+ */
+func parseRange(s string, size int64) ([]httpRange, error) {
+	const b = "bytes="
+	if !strings.HasPrefix(s, b) {
+		return nil, errInvalidRange
+	}
+	var ranges []httpRange
+	noOverlap := false
+	for _, ra := range strings.Split(s[len(b):], ",") {
+		ra = textproto.TrimString(ra)
+		if ra == "" {
+			continue
+		}
+		start, end, ok := strings.Cut(ra, "-")
+		if !ok {
+			return nil, errInvalidRange
+		}
+		start, end = textproto.TrimString(start), textproto.TrimString(end)
+		var r httpRange
+		if start == "" {
+			// suffix-byte-range-spec, the final N bytes of the object
+			if end == "" || end[0] == '-' {
+				return nil, errInvalidRange
+			}
+			i, err := strconv.ParseInt(end, 10, 64)
+			if i < 0 || err != nil {
+				return nil, errInvalidRange
+			}
+			if i == 0 {
+				noOverlap = true
+				continue
+			}
+			if i > size {
+				i = size
+			}
+			r.start = size - i
+			r.length = size - r.start
+		} else {
+			i, err := strconv.ParseInt(start, 10, 64)
+			if err != nil || i < 0 {
+				return nil, errInvalidRange
+			}
+			if i >= size {
+				noOverlap = true
+				continue
+			}
+			r.start = i
+			if end == "" {
+				r.length = size - r.start
+			} else {
+				i, err := strconv.ParseInt(end, 10, 64)
+				if err != nil || r.start > i {
+					return nil, errInvalidRange
+				}
+				if i >= size {
+					i = size - 1
+				}
+				r.length = i - r.start + 1
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	if noOverlap && len(ranges) == 0 {
+		return nil, errNoOverlap
+	}
+	return ranges, nil
+}
+
+func sumRangesSize(ranges []httpRange) (size int64) {
+	for _, ra := range ranges {
+		size += ra.length
+	}
+	return
+}
+
+// rangesMIMESize returns the number of bytes a multipart/byteranges body will use
+func rangesMIMESize(ranges []httpRange, boundary, contentType string, size int64) int64 {
+	var w countingWriter
+	mw := multipart.NewWriter(&w)
+	_ = mw.SetBoundary(boundary)
+	encSize := int64(0)
+	for _, ra := range ranges {
+		_, _ = mw.CreatePart(ra.mimeHeader(contentType, size))
+		encSize += ra.length
+	}
+	_ = mw.Close()
+	return encSize + int64(w)
+}
+
+type countingWriter int64
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	*w += countingWriter(len(p))
+	return len(p), nil
+}
+
+// writeRangeNotSatisfiable answers a Range request none of whose ranges overlap the object
+func writeRangeNotSatisfiable(w http.ResponseWriter, r *http.Request, size int64) {
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+	httperr.Write(w, r, http.StatusRequestedRangeNotSatisfiable, "")
+}
+
+/*
+ * writeMultipartRanges answers with a multipart/byteranges body, see
+ * https://www.rfc-editor.org/rfc/rfc9110#section-14.6
+ * Each part is read with open, headers have already been sent when it fails so the response is cut short.
+ * Only the headers are written when open is nil.
+ */
+func writeMultipartRanges(w http.ResponseWriter, obj interface{}, ranges []httpRange, size int64,
+	open func(ra httpRange) (io.ReadCloser, error), c *config.Settings) {
+	setHeadersFromAwsResponse(w, obj, c)
+	contentType := w.Header().Get("Content-Type")
+
+	mw := multipart.NewWriter(w)
+	w.Header().Del("Content-Range")
+	w.Header().Set("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+	if len(w.Header().Get("Content-Encoding")) == 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(rangesMIMESize(ranges, mw.Boundary(), contentType, size), 10))
+	} else {
+		w.Header().Del("Content-Length")
+	}
+	w.WriteHeader(http.StatusPartialContent)
+	if open == nil {
+		return
+	}
+
+	for _, ra := range ranges {
+		part, err := mw.CreatePart(ra.mimeHeader(contentType, size))
+		if err != nil {
+			return
+		}
+		body, err := open(ra)
+		if err != nil {
+			return
+		}
+		_, err = io.Copy(part, body)
+		body.Close()
+		if err != nil {
+			return
+		}
+	}
+	_ = mw.Close()
+}
+
+/*
+ * serveRanges answers a Range request for an object of the given size, reading each range with open,
+ * which is nil for HEAD requests. It returns false when the Range header is ignored and the whole
+ * object should be sent instead.
+ */
+func serveRanges(w http.ResponseWriter, r *http.Request, obj interface{}, size int64, rangeHeader string,
+	open func(ra httpRange) (io.ReadCloser, error), c *config.Settings) bool {
+	ranges, err := parseRange(rangeHeader, size)
+	if errors.Is(err, errNoOverlap) {
+		writeRangeNotSatisfiable(w, r, size)
+		return true
+	}
+	if err != nil || len(ranges) == 0 || len(ranges) > maxRanges || sumRangesSize(ranges) > size {
+		return false
+	}
+	if len(ranges) > 1 {
+		writeMultipartRanges(w, obj, ranges, size, open, c)
+		return true
+	}
+
+	ra := ranges[0]
+	setHeadersFromAwsResponse(w, obj, c)
+	w.Header().Set("Content-Range", ra.contentRange(size))
+	if len(w.Header().Get("Content-Encoding")) == 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(ra.length, 10))
+	}
+	// Matches determineHTTPStatus for ranges passed through to S3
+	if ra.length == size {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusPartialContent)
+	}
+	if open == nil {
+		return true
+	}
+	body, err := open(ra)
+	if err != nil {
+		return true
+	}
+	_, _ = io.Copy(w, body)
+	body.Close()
+	return true
+}
+
+/*
+ * serveS3Ranges answers a multiple range request for an uncached object, S3 only accepts a single
+ * range so every part is fetched with its own ranged GetObject. It returns false when the caller
+ * should fall back to fetching the whole object.
+ */
+func serveS3Ranges(w http.ResponseWriter, r *http.Request, client service.AWS, bucket, key, rangeHeader string,
+	cond *service.Conditions, c *config.Settings) bool {
+	head, err := client.S3head(r.Context(), bucket, key, nil, cond)
+	if err != nil || !ifRangeMatches(r, head.ETag, head.LastModified) {
+		return false
+	}
+	return serveRanges(w, r, head, aws.ToInt64(head.ContentLength), rangeHeader, func(ra httpRange) (io.ReadCloser, error) {
+		partRange := aws.String(fmt.Sprintf("bytes=%d-%d", ra.start, ra.start+ra.length-1))
+		// Fail instead of mixing parts from different versions of the object
+		obj, err := client.S3get(r.Context(), bucket, key, partRange, &service.Conditions{IfMatch: head.ETag})
+		metrics.UpdateS3Reads(err, metrics.GetObjectAction, metrics.ProxySource)
+		if err != nil {
+			return nil, err
+		}
+		return obj.Body, nil
+	}, c)
+}
+
+// objectMeta returns the size and validators of a GetObject or HeadObject output
+func objectMeta(obj interface{}) (size int64, etag *string, lastModified *time.Time) {
+	switch o := obj.(type) {
+	case *s3.GetObjectOutput:
+		return aws.ToInt64(o.ContentLength), o.ETag, o.LastModified
+	case *s3.HeadObjectOutput:
+		return aws.ToInt64(o.ContentLength), o.ETag, o.LastModified
+	}
+	return 0, nil, nil
+}
@@ -0,0 +1,201 @@
+package controllers
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/patrickdk77/aws-s3-proxy/internal/config"
+	"github.com/patrickdk77/aws-s3-proxy/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestParseRange(t *testing.T) {
+	ranges, err := parseRange("bytes=0-4", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []httpRange{{0, 5}}, ranges)
+
+	ranges, err = parseRange("bytes=-3", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []httpRange{{7, 3}}, ranges)
+
+	ranges, err = parseRange("bytes=8-, 0-0", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []httpRange{{8, 2}, {0, 1}}, ranges)
+
+	ranges, err = parseRange("bytes=5-100", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []httpRange{{5, 5}}, ranges)
+}
+
+func TestParseRangeErrors(t *testing.T) {
+	_, err := parseRange("bytes=20-30", 10)
+	assert.ErrorIs(t, err, errNoOverlap)
+
+	_, err = parseRange("items=0-1", 10)
+	assert.ErrorIs(t, err, errInvalidRange)
+
+	_, err = parseRange("bytes=5-1", 10)
+	assert.ErrorIs(t, err, errInvalidRange)
+}
+
+func setupRangeCache(t *testing.T) *MockAWS {
+	mockAWS := new(MockAWS)
+	setupAWS(t, mockAWS)
+	config.Config.CacheSize = 10 * 1024 * 1024
+	config.Config.CacheTTL = 1 * time.Minute
+	config.Config.CacheMaxFileSize = 1 * 1024 * 1024
+
+	mockAWS.On("S3get", mock.Anything, "bucket", "/range.txt", (*string)(nil), (*service.Conditions)(nil)).Return(&s3.GetObjectOutput{
+		Body:          io.NopCloser(bytes.NewBufferString("0123456789")),
+		ContentLength: aws.Int64(10),
+		ContentType:   aws.String("text/plain"),
+		ETag:          aws.String(`"abc"`),
+	}, nil).Once()
+
+	req, _ := http.NewRequest("GET", "/range.txt", nil)
+	rr := httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	return mockAWS
+}
+
+func TestAwsS3_CachedSingleRange(t *testing.T) {
+	mockAWS := setupRangeCache(t)
+
+	req, _ := http.NewRequest("GET", "/range.txt", nil)
+	req.Header.Set("Range", "bytes=2-5")
+	rr := httptest.NewRecorder()
+	AwsS3(rr, req)
+
+	assert.Equal(t, http.StatusPartialContent, rr.Code)
+	assert.Equal(t, "2345", rr.Body.String())
+	assert.Equal(t, "bytes 2-5/10", rr.Header().Get("Content-Range"))
+	assert.Equal(t, "4", rr.Header().Get("Content-Length"))
+	mockAWS.AssertExpectations(t)
+}
+
+func TestAwsS3_CachedMultipleRanges(t *testing.T) {
+	mockAWS := setupRangeCache(t)
+
+	req, _ := http.NewRequest("GET", "/range.txt", nil)
+	req.Header.Set("Range", "bytes=0-1,-2")
+	rr := httptest.NewRecorder()
+	AwsS3(rr, req)
+
+	assert.Equal(t, http.StatusPartialContent, rr.Code)
+	assert.Equal(t, strconv.Itoa(rr.Body.Len()), rr.Header().Get("Content-Length"))
+	mediaType, params, err := mime.ParseMediaType(rr.Header().Get("Content-Type"))
+	assert.NoError(t, err)
+	assert.Equal(t, "multipart/byteranges", mediaType)
+
+	mr := multipart.NewReader(rr.Body, params["boundary"])
+	expected := []struct{ body, contentRange string }{{"01", "bytes 0-1/10"}, {"89", "bytes 8-9/10"}}
+	for _, e := range expected {
+		part, err := mr.NextPart()
+		assert.NoError(t, err)
+		body, _ := io.ReadAll(part)
+		assert.Equal(t, e.body, string(body))
+		assert.Equal(t, e.contentRange, part.Header.Get("Content-Range"))
+		assert.Equal(t, "text/plain", part.Header.Get("Content-Type"))
+	}
+	_, err = mr.NextPart()
+	assert.Equal(t, io.EOF, err)
+	mockAWS.AssertExpectations(t)
+}
+
+func TestAwsS3_CachedRangeNotSatisfiable(t *testing.T) {
+	mockAWS := setupRangeCache(t)
+
+	req, _ := http.NewRequest("GET", "/range.txt", nil)
+	req.Header.Set("Range", "bytes=20-")
+	rr := httptest.NewRecorder()
+	AwsS3(rr, req)
+
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, rr.Code)
+	assert.Equal(t, "bytes */10", rr.Header().Get("Content-Range"))
+	mockAWS.AssertExpectations(t)
+}
+
+func TestAwsS3_CachedRangeStaleIfRange(t *testing.T) {
+	mockAWS := setupRangeCache(t)
+
+	req, _ := http.NewRequest("GET", "/range.txt", nil)
+	req.Header.Set("Range", "bytes=2-5")
+	req.Header.Set("If-Range", `"old"`)
+	rr := httptest.NewRecorder()
+	AwsS3(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "0123456789", rr.Body.String())
+	mockAWS.AssertExpectations(t)
+}
+
+func TestAwsS3_S3MultipleRanges(t *testing.T) {
+	mockAWS := new(MockAWS)
+	setupAWS(t, mockAWS)
+
+	etag := aws.String(`"abc"`)
+	mockAWS.On("S3head", mock.Anything, "bucket", "/multi.txt", (*string)(nil), (*service.Conditions)(nil)).Return(&s3.HeadObjectOutput{
+		ContentLength: aws.Int64(10),
+		ContentType:   aws.String("text/plain"),
+		ETag:          etag,
+	}, nil).Once()
+	mockAWS.On("S3get", mock.Anything, "bucket", "/multi.txt", aws.String("bytes=0-1"), &service.Conditions{IfMatch: etag}).Return(&s3.GetObjectOutput{
+		Body: io.NopCloser(bytes.NewBufferString("01")),
+	}, nil).Once()
+	mockAWS.On("S3get", mock.Anything, "bucket", "/multi.txt", aws.String("bytes=5-6"), &service.Conditions{IfMatch: etag}).Return(&s3.GetObjectOutput{
+		Body: io.NopCloser(bytes.NewBufferString("56")),
+	}, nil).Once()
+
+	req, _ := http.NewRequest("GET", "/multi.txt", nil)
+	req.Header.Set("Range", "bytes=0-1,5-6")
+	rr := httptest.NewRecorder()
+	AwsS3(rr, req)
+
+	assert.Equal(t, http.StatusPartialContent, rr.Code)
+	assert.Equal(t, strconv.Itoa(rr.Body.Len()), rr.Header().Get("Content-Length"))
+	_, params, err := mime.ParseMediaType(rr.Header().Get("Content-Type"))
+	assert.NoError(t, err)
+	mr := multipart.NewReader(rr.Body, params["boundary"])
+	for _, expected := range []string{"01", "56"} {
+		part, err := mr.NextPart()
+		assert.NoError(t, err)
+		body, _ := io.ReadAll(part)
+		assert.Equal(t, expected, string(body))
+	}
+	mockAWS.AssertExpectations(t)
+}
+
+func TestAwsS3_HeadRange(t *testing.T) {
+	mockAWS := setupRangeCache(t)
+
+	req, _ := http.NewRequest("HEAD", "/range.txt", nil)
+	req.Header.Set("Range", "bytes=-4")
+	rr := httptest.NewRecorder()
+	AwsS3(rr, req)
+
+	assert.Equal(t, http.StatusPartialContent, rr.Code)
+	assert.Equal(t, "bytes 6-9/10", rr.Header().Get("Content-Range"))
+	assert.Equal(t, "4", rr.Header().Get("Content-Length"))
+	assert.Equal(t, "", rr.Body.String())
+
+	req, _ = http.NewRequest("HEAD", "/range.txt", nil)
+	req.Header.Set("Range", "bytes=0-1,4-5")
+	rr = httptest.NewRecorder()
+	AwsS3(rr, req)
+
+	assert.Equal(t, http.StatusPartialContent, rr.Code)
+	assert.Contains(t, rr.Header().Get("Content-Type"), "multipart/byteranges")
+	assert.Equal(t, "", rr.Body.String())
+	mockAWS.AssertExpectations(t)
+}
@@ -11,9 +11,11 @@ import (
 type s3objects []s3item
 
 type s3item struct {
-	file      string
-	size      int64
-	updatedAt time.Time
+	file         string
+	size         int64
+	updatedAt    time.Time
+	etag         string
+	storageClass string
 }
 
 func (s s3objects) Len() int {
@@ -23,6 +25,20 @@ func (s s3objects) Swap(i, j int) {
 	s[i], s[j] = s[j], s[i]
 }
 func (s s3objects) Less(i, j int) bool {
+	return s.less(config.Config, i, j)
+}
+
+// sortedObjects sorts a listing by the sort order of a route
+type sortedObjects struct {
+	s3objects
+	c *config.Settings
+}
+
+func (s sortedObjects) Less(i, j int) bool {
+	return s.less(s.c, i, j)
+}
+
+func (s s3objects) less(c *config.Settings, i, j int) bool {
 	if strings.Contains(s[i].file, "/") {
 		if !strings.Contains(s[j].file, "/") {
 			return true
@@ -33,11 +49,11 @@ func (s s3objects) Less(i, j int) bool {
 		}
 	}
 
-	if config.Config.SortDateDesc && s[i].updatedAt != s[j].updatedAt {
+	if c.SortDateDesc && s[i].updatedAt != s[j].updatedAt {
 		return s[i].updatedAt.After(s[j].updatedAt)
 	}
 
-	if config.Config.SortDateAsc && s[i].updatedAt != s[j].updatedAt {
+	if c.SortDateAsc && s[i].updatedAt != s[j].updatedAt {
 		return s[i].updatedAt.Before(s[j].updatedAt)
 	}
 
@@ -47,12 +63,12 @@ func (s s3objects) Less(i, j int) bool {
 	max := len(irs)
 	if max > len(jrs) {
 		max = len(jrs)
-		if config.Config.SortNumeric {
-			return config.Config.SortFileDesc
+		if c.SortNumeric {
+			return c.SortFileDesc
 		}
 	} else if max < len(jrs) {
-		if config.Config.SortNumeric {
-			return config.Config.SortFileAsc
+		if c.SortNumeric {
+			return c.SortFileAsc
 		}
 	}
 
@@ -63,27 +79,27 @@ func (s s3objects) Less(i, j int) bool {
 		jrl := unicode.ToLower(jr)
 
 		if irl != jrl {
-			if config.Config.SortFileAsc {
+			if c.SortFileAsc {
 				return irl < jrl
 			}
-			if config.Config.SortFileDesc {
+			if c.SortFileDesc {
 				return irl > jrl
 			}
 		}
 		if ir != jr {
-			if config.Config.SortFileAsc {
+			if c.SortFileAsc {
 				return ir < jr
 			}
-			if config.Config.SortFileDesc {
+			if c.SortFileDesc {
 				return ir > jr
 			}
 		}
 	}
 	if len(irs) < len(jrs) {
-		return config.Config.SortFileAsc
+		return c.SortFileAsc
 	}
 	if len(irs) > len(jrs) {
-		return config.Config.SortFileDesc
+		return c.SortFileDesc
 	}
 	return false
 }
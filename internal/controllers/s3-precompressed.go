@@ -0,0 +1,81 @@
+package controllers
+
+import (
+	"mime"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/karlseguin/ccache/v3"
+	"github.com/patrickdk77/aws-s3-proxy/internal/config"
+	"github.com/patrickdk77/aws-s3-proxy/internal/service"
+)
+
+// precompressedEncodings are the content codings of PRECOMPRESSED extensions
+var precompressedEncodings = map[string]string{
+	"br":  "br",
+	"zst": "zstd",
+	"gz":  "gzip",
+}
+
+// precompressedCache remembers which siblings exist, whether or not CACHE_SIZE enables httpCache
+var precompressedCache = ccache.New(ccache.Configure[bool]().MaxSize(4096))
+
+func precompressedCacheKey(bucket, key string) string {
+	return "Precompressed:=" + bucket + ":" + key
+}
+
+// precompressedSibling returns the path of the sibling of path best matching Accept-Encoding, like app.js.br,
+// with the headers it is served with, or path itself when there is none
+func precompressedSibling(r *http.Request, client service.AWS, c *config.Settings, p string) (string, http.Header) {
+	candidates := []string{}
+	for _, ext := range c.Precompressed {
+		if encodingQuality(r, precompressedEncodings[ext]) > 0 {
+			candidates = append(candidates, ext)
+		}
+	}
+	// Highest quality first, the order of PRECOMPRESSED between equals
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return encodingQuality(r, precompressedEncodings[candidates[i]]) > encodingQuality(r, precompressedEncodings[candidates[j]])
+	})
+	for _, ext := range candidates {
+		key := c.S3KeyPrefix + p + "." + ext
+		item, err := precompressedCache.Fetch(precompressedCacheKey(c.S3Bucket, key), c.CacheTTLIndex, func() (bool, error) {
+			return client.S3exists(r.Context(), c.S3Bucket, key), nil
+		})
+		if err != nil || !item.Value() {
+			continue
+		}
+		headers := http.Header{"Content-Encoding": {precompressedEncodings[ext]}}
+		// The type of the original, unless CONTENT_TYPE forces one
+		if contentType := mime.TypeByExtension(path.Ext(p)); len(c.ContentType) == 0 && len(contentType) > 0 {
+			headers.Set("Content-Type", contentType)
+		}
+		return p + "." + ext, headers
+	}
+	return p, nil
+}
+
+// encodingQuality returns the q-value Accept-Encoding gives a content coding, or * when it is not listed
+func encodingQuality(r *http.Request, coding string) float64 {
+	wildcard := 0.0
+	for _, value := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(value), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		switch name = strings.TrimSpace(name); {
+		case strings.EqualFold(name, coding):
+			return q
+		case name == "*":
+			wildcard = q
+		}
+	}
+	return wildcard
+}
@@ -0,0 +1,110 @@
+package controllers
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	"github.com/patrickdk77/aws-s3-proxy/internal/config"
+	"github.com/patrickdk77/aws-s3-proxy/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func setupPrecompressed(t *testing.T) *MockAWS {
+	mockAWS := new(MockAWS)
+	setupAWS(t, mockAWS)
+	config.Config.Precompressed = []string{"br", "zst", "gz"}
+
+	mockAWS.On("S3exists", mock.Anything, "bucket", "/app.js.br").Return(true)
+	mockAWS.On("S3exists", mock.Anything, "bucket", "/app.js.zst").Return(false)
+	mockAWS.On("S3exists", mock.Anything, "bucket", "/app.js.gz").Return(true)
+	return mockAWS
+}
+
+func getObject(content, contentType string) *s3.GetObjectOutput {
+	return &s3.GetObjectOutput{
+		Body:          io.NopCloser(bytes.NewBufferString(content)),
+		ContentLength: aws.Int64(int64(len(content))),
+		ContentType:   aws.String(contentType),
+	}
+}
+
+func TestAwsS3_Precompressed(t *testing.T) {
+	mockAWS := setupPrecompressed(t)
+
+	mockAWS.On("S3get", mock.Anything, "bucket", "/app.js.br", (*string)(nil), (*service.Conditions)(nil)).
+		Return(getObject("brotli", "application/octet-stream"), nil).Once()
+	req, _ := http.NewRequest("GET", "/app.js", nil)
+	req.Header.Set("Accept-Encoding", "gzip, deflate, br")
+	rr := httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "brotli", rr.Body.String())
+	assert.Equal(t, "br", rr.Header().Get("Content-Encoding"))
+	assert.Equal(t, "text/javascript; charset=utf-8", rr.Header().Get("Content-Type"))
+	assert.Equal(t, "6", rr.Header().Get("Content-Length"))
+	assert.Equal(t, "Accept-Encoding", rr.Header().Get("Vary"))
+
+	// q-values come before the order of PRECOMPRESSED
+	mockAWS.On("S3get", mock.Anything, "bucket", "/app.js.gz", (*string)(nil), (*service.Conditions)(nil)).
+		Return(getObject("gzip", "application/gzip"), nil).Once()
+	req, _ = http.NewRequest("GET", "/app.js", nil)
+	req.Header.Set("Accept-Encoding", "br;q=0.5, gzip")
+	rr = httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, "gzip", rr.Header().Get("Content-Encoding"))
+	assert.Equal(t, "gzip", rr.Body.String())
+
+	// The original without a sibling accepted
+	mockAWS.On("S3get", mock.Anything, "bucket", "/app.js", (*string)(nil), (*service.Conditions)(nil)).
+		Return(getObject("plain", "text/javascript"), nil).Once()
+	req, _ = http.NewRequest("GET", "/app.js", nil)
+	req.Header.Set("Accept-Encoding", "zstd, identity")
+	rr = httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Empty(t, rr.Header().Get("Content-Encoding"))
+	assert.Equal(t, "plain", rr.Body.String())
+	assert.Equal(t, "Accept-Encoding", rr.Header().Get("Vary"))
+
+	// Existence is only checked once
+	mockAWS.AssertNumberOfCalls(t, "S3exists", 3)
+	mockAWS.AssertExpectations(t)
+}
+
+func TestAwsS3_PrecompressedMissing(t *testing.T) {
+	mockAWS := setupPrecompressed(t)
+
+	// A sibling removed since it was seen is not labelled with its encoding
+	mockAWS.On("S3get", mock.Anything, "bucket", "/app.js.br", (*string)(nil), (*service.Conditions)(nil)).
+		Return(nil, &smithy.GenericAPIError{Code: "NoSuchKey", Message: "The specified key does not exist."}).Once()
+	req, _ := http.NewRequest("GET", "/app.js", nil)
+	req.Header.Set("Accept-Encoding", "br")
+	rr := httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Empty(t, rr.Header().Get("Content-Encoding"))
+}
+
+func TestEncodingQuality(t *testing.T) {
+	for header, expected := range map[string]float64{
+		"":                     0,
+		"gzip":                 1,
+		"GZIP;q=0.5":           0.5,
+		"br, gzip;q=0":         0,
+		"*;q=0.3":              0.3,
+		"*;q=0.3, gzip;q=0.8":  0.8,
+		"gzip;q=bad, *;q=0.1":  0.1,
+		"deflate, identity":    0,
+		" br , gzip ; q=0.25 ": 0.25,
+	} {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", header)
+		assert.Equal(t, expected, encodingQuality(req, "gzip"), header)
+	}
+}
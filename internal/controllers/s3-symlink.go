@@ -0,0 +1,136 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/patrickdk77/aws-s3-proxy/internal/config"
+	"github.com/patrickdk77/aws-s3-proxy/internal/metrics"
+	"github.com/patrickdk77/aws-s3-proxy/internal/service"
+)
+
+const (
+	symlinkName = "symlink.json"
+	// maxSymlinkSize bounds the symlinks read into memory
+	maxSymlinkSize = 64 * 1024
+)
+
+var (
+	errSymlinkLoop   = errors.New("symlink loop")
+	errSymlinkTarget = errors.New("symlink target not allowed")
+)
+
+func symlinkCacheKey(bucket, key string) string {
+	return "Symlink:=" + bucket + ":" + key
+}
+
+// resolveSymlinks replaces every symlink.json of path with the URL it holds, following links to links.
+// Targets starting with / are relative to the site, others to the directory of the link, and neither
+// can leave the site. s3://bucket/key targets switch to a bucket of SYMLINK_BUCKETS.
+// The settings returned serve that bucket when a link led to another one.
+func resolveSymlinks(w http.ResponseWriter, r *http.Request, client service.AWS, c *config.Settings,
+	p string) (*config.Settings, string, error) {
+	bucket, prefix := c.S3Bucket, c.S3KeyPrefix
+	seen := map[string]bool{}
+	for hops := 0; ; hops++ {
+		idx := strings.Index(p, symlinkName)
+		if idx < 0 {
+			break
+		}
+		link, rest := p[:idx+len(symlinkName)], p[idx+len(symlinkName):]
+		key := prefix + link
+		if seen[bucket+":"+key] {
+			return c, p, fmt.Errorf("%w at %s", errSymlinkLoop, link)
+		}
+		if hops >= c.SymlinkMaxHops {
+			return c, p, fmt.Errorf("%w: more than %d hops at %s", errSymlinkLoop, c.SymlinkMaxHops, link)
+		}
+		seen[bucket+":"+key] = true
+
+		target, err := readSymlink(r, client, c, bucket, key)
+		if err != nil {
+			return c, p, err
+		}
+		hop := target
+		if s3url, ok := strings.CutPrefix(target, "s3://"); ok {
+			otherBucket, otherKey, _ := strings.Cut(s3url, "/")
+			if !allowedSymlinkBucket(c, otherBucket) {
+				return c, p, fmt.Errorf("%w: bucket %s", errSymlinkTarget, otherBucket)
+			}
+			bucket, prefix, target = otherBucket, "", "/"+otherKey
+		} else if strings.Contains(target, "://") {
+			return c, p, fmt.Errorf("%w: %s", errSymlinkTarget, target)
+		} else if !strings.HasPrefix(target, "/") {
+			target = path.Dir(link) + "/" + target
+		}
+		p = joinSymlinkTarget(target, rest)
+		if c.SymlinkDebug {
+			w.Header().Add("X-Symlink-Hops", link+" -> "+hop)
+		}
+	}
+	if bucket != c.S3Bucket || prefix != c.S3KeyPrefix {
+		site := *c
+		site.S3Bucket, site.S3KeyPrefix = bucket, prefix
+		return &site, p, nil
+	}
+	return c, p, nil
+}
+
+// joinSymlinkTarget cleans target so .. stops at the root of the site, and appends what followed the link
+func joinSymlinkTarget(target, rest string) string {
+	cleaned := path.Clean("/" + target)
+	if strings.HasSuffix(target, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	if strings.HasPrefix(rest, "/") {
+		return strings.TrimSuffix(cleaned, "/") + rest
+	}
+	return cleaned + rest
+}
+
+func allowedSymlinkBucket(c *config.Settings, bucket string) bool {
+	for _, allowed := range c.SymlinkBuckets {
+		if allowed == bucket {
+			return true
+		}
+	}
+	return false
+}
+
+// readSymlink returns the URL of a symlink, from httpCache for SYMLINK_CACHE_TTL
+func readSymlink(r *http.Request, client service.AWS, c *config.Settings, bucket, key string) (string, error) {
+	cacheKey := symlinkCacheKey(bucket, key)
+	if httpCache != nil && c.SymlinkCacheTTL > 0 {
+		if item := httpCache.Get(cacheKey); item != nil && !item.Expired() {
+			return string(item.Value().Body), nil
+		}
+	}
+	obj, err := client.S3get(r.Context(), bucket, key, nil, nil)
+	metrics.UpdateS3Reads(err, metrics.GetObjectAction, metrics.ProxySource)
+	if err != nil {
+		return "", err
+	}
+	defer obj.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(obj.Body, maxSymlinkSize))
+	if err != nil {
+		return "", err
+	}
+	link := struct {
+		URL string
+	}{}
+	if err = json.Unmarshal(body, &link); err != nil {
+		return "", fmt.Errorf("symlink %s: %w", key, err)
+	}
+	if len(link.URL) == 0 {
+		return "", fmt.Errorf("symlink %s: %w: empty URL", key, errSymlinkTarget)
+	}
+	if httpCache != nil && c.SymlinkCacheTTL > 0 {
+		httpCache.Set(cacheKey, cachedResponse{Body: []byte(link.URL), Exists: true}, c.SymlinkCacheTTL)
+	}
+	return link.URL, nil
+}
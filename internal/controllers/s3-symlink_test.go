@@ -0,0 +1,103 @@
+package controllers
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/patrickdk77/aws-s3-proxy/internal/config"
+	"github.com/patrickdk77/aws-s3-proxy/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func setupSymlinks(t *testing.T, links map[string]string) *MockAWS {
+	mockAWS := new(MockAWS)
+	setupAWS(t, mockAWS)
+	config.Config.S3KeyPrefix = "/site"
+	config.Config.CacheSize = 1024 * 1024
+	config.Config.CacheTTL = time.Minute
+	config.Config.CacheMaxFileSize = 1024
+	config.Config.SPA = false
+	config.Config.SymlinkMaxHops = 8
+	config.Config.SymlinkCacheTTL = time.Minute
+	config.Config.SymlinkDebug = true
+
+	for key, url := range links {
+		mockAWS.On("S3get", mock.Anything, "bucket", key, (*string)(nil), (*service.Conditions)(nil)).Return(&s3.GetObjectOutput{
+			Body: io.NopCloser(bytes.NewBufferString(`{"URL": "` + url + `"}`)),
+		}, nil).Once()
+	}
+	return mockAWS
+}
+
+func TestAwsS3_SymlinkChain(t *testing.T) {
+	mockAWS := setupSymlinks(t, map[string]string{
+		"/site/current/symlink.json":  "../releases/symlink.json",
+		"/site/releases/symlink.json": "/releases/v2/",
+	})
+
+	mockAWS.On("S3get", mock.Anything, "bucket", "/site/releases/v2/app.js", (*string)(nil), (*service.Conditions)(nil)).Return(&s3.GetObjectOutput{
+		Body: io.NopCloser(bytes.NewBufferString("v2")),
+	}, nil).Once()
+
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("GET", "/current/symlink.json/app.js", nil)
+		rr := httptest.NewRecorder()
+		AwsS3(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "v2", rr.Body.String())
+		assert.Equal(t, []string{"/current/symlink.json -> ../releases/symlink.json", "/releases/symlink.json -> /releases/v2/"},
+			rr.Header().Values("X-Symlink-Hops"))
+	}
+	// Links are read once and then served from the cache
+	mockAWS.AssertExpectations(t)
+}
+
+func TestAwsS3_SymlinkLoop(t *testing.T) {
+	mockAWS := setupSymlinks(t, map[string]string{
+		"/site/a/symlink.json": "/b/symlink.json",
+		"/site/b/symlink.json": "/a/symlink.json",
+	})
+
+	req, _ := http.NewRequest("GET", "/a/symlink.json", nil)
+	rr := httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusLoopDetected, rr.Code)
+	mockAWS.AssertExpectations(t)
+}
+
+func TestAwsS3_SymlinkConfined(t *testing.T) {
+	mockAWS := setupSymlinks(t, map[string]string{
+		"/site/up/symlink.json":    "../../../secret/",
+		"/site/other/symlink.json": "s3://private/key",
+		"/site/web/symlink.json":   "https://example.com/",
+		"/site/ok/symlink.json":    "s3://releases/v1/",
+	})
+	config.Config.SymlinkBuckets = []string{"releases"}
+
+	// .. stops at the root of the site
+	mockAWS.On("S3get", mock.Anything, "bucket", "/site/secret/index.html", (*string)(nil), (*service.Conditions)(nil)).Return(&s3.GetObjectOutput{
+		Body: io.NopCloser(bytes.NewBufferString("site")),
+	}, nil).Once()
+	mockAWS.On("S3get", mock.Anything, "releases", "/v1/index.html", (*string)(nil), (*service.Conditions)(nil)).Return(&s3.GetObjectOutput{
+		Body: io.NopCloser(bytes.NewBufferString("release")),
+	}, nil).Once()
+
+	for path, expected := range map[string]int{
+		"/up/symlink.json":    http.StatusOK,
+		"/other/symlink.json": http.StatusForbidden,
+		"/web/symlink.json":   http.StatusForbidden,
+		"/ok/symlink.json":    http.StatusOK,
+	} {
+		req, _ := http.NewRequest("GET", path, nil)
+		rr := httptest.NewRecorder()
+		AwsS3(rr, req)
+		assert.Equal(t, expected, rr.Code, path)
+	}
+	mockAWS.AssertExpectations(t)
+}
@@ -0,0 +1,88 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/patrickdk77/aws-s3-proxy/internal/config"
+)
+
+// websiteRedirect answers with the redirect stored in the x-amz-website-redirect-location
+// metadata of an object, like S3 static website hosting does
+func websiteRedirect(w http.ResponseWriter, r *http.Request, obj interface{}, c *config.Settings) bool {
+	var location string
+	switch o := obj.(type) {
+	case *s3.GetObjectOutput:
+		location = aws.ToString(o.WebsiteRedirectLocation)
+		if len(location) > 0 && o.Body != nil {
+			o.Body.Close()
+		}
+	case *s3.HeadObjectOutput:
+		location = aws.ToString(o.WebsiteRedirectLocation)
+	}
+	if len(location) == 0 {
+		return false
+	}
+	// Keys are relative to where the bucket is served
+	if strings.HasPrefix(location, "/") {
+		location = c.StripPath + location
+	}
+	http.Redirect(w, r, location, http.StatusMovedPermanently)
+	return true
+}
+
+// routingRuleRedirect answers with the redirect of the first routing rule matching the
+// requested path, and the status code of its S3 error when there was one
+func routingRuleRedirect(w http.ResponseWriter, r *http.Request, c *config.Settings, path string, code int) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	key := strings.TrimPrefix(path, "/")
+	for _, rule := range config.RoutingRules() {
+		if !strings.HasPrefix(key, rule.Condition.KeyPrefixEquals) {
+			continue
+		}
+		// Rules without an error code apply before the object is fetched
+		errorCode := rule.Condition.HTTPErrorCodeReturnedEquals
+		if (errorCode == "" && code != 0) || (errorCode != "" && errorCode != strconv.Itoa(code)) {
+			continue
+		}
+		http.Redirect(w, r, routingRuleLocation(r, c, rule, key), routingRuleStatus(rule))
+		return true
+	}
+	return false
+}
+
+func routingRuleLocation(r *http.Request, c *config.Settings, rule config.RoutingRule, key string) string {
+	switch {
+	case len(rule.Redirect.ReplaceKeyWith) > 0:
+		key = rule.Redirect.ReplaceKeyWith
+	case len(rule.Redirect.ReplaceKeyPrefixWith) > 0:
+		key = rule.Redirect.ReplaceKeyPrefixWith + strings.TrimPrefix(key, rule.Condition.KeyPrefixEquals)
+	}
+	if len(rule.Redirect.HostName) == 0 && len(rule.Redirect.Protocol) == 0 {
+		return c.StripPath + "/" + key
+	}
+	host := rule.Redirect.HostName
+	if len(host) == 0 {
+		host = r.Host
+	}
+	protocol := rule.Redirect.Protocol
+	if len(protocol) == 0 {
+		protocol = "http"
+		if r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") {
+			protocol = "https"
+		}
+	}
+	return protocol + "://" + host + "/" + key
+}
+
+func routingRuleStatus(rule config.RoutingRule) int {
+	if code, err := strconv.Atoi(rule.Redirect.HTTPRedirectCode); err == nil {
+		return code
+	}
+	return http.StatusMovedPermanently
+}
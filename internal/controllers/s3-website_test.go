@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	"github.com/patrickdk77/aws-s3-proxy/internal/config"
+	"github.com/patrickdk77/aws-s3-proxy/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func setupWebsite(t *testing.T, rules string) *MockAWS {
+	mockAWS := new(MockAWS)
+	setupAWS(t, mockAWS)
+	config.Config.SPA = false
+
+	config.Config.RoutingRulesFile = filepath.Join(t.TempDir(), "rules.json")
+	_ = os.WriteFile(config.Config.RoutingRulesFile, []byte(rules), 0o600)
+	assert.NoError(t, config.LoadRoutingRules())
+	t.Cleanup(func() {
+		_ = os.WriteFile(config.Config.RoutingRulesFile, []byte(`[]`), 0o600)
+		_ = config.LoadRoutingRules()
+		config.Config.RoutingRulesFile = ""
+	})
+	return mockAWS
+}
+
+func TestAwsS3_WebsiteRedirectLocation(t *testing.T) {
+	mockAWS := setupWebsite(t, `[]`)
+
+	mockAWS.On("S3get", mock.Anything, "bucket", "/old.html", (*string)(nil), (*service.Conditions)(nil)).Return(&s3.GetObjectOutput{
+		WebsiteRedirectLocation: aws.String("/new.html"),
+	}, nil).Once()
+	mockAWS.On("S3head", mock.Anything, "bucket", "/old.html", (*string)(nil), (*service.Conditions)(nil)).Return(&s3.HeadObjectOutput{
+		WebsiteRedirectLocation: aws.String("https://example.com/new.html"),
+	}, nil).Once()
+
+	req, _ := http.NewRequest("GET", "/old.html", nil)
+	rr := httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusMovedPermanently, rr.Code)
+	assert.Equal(t, "/new.html", rr.Header().Get("Location"))
+
+	req, _ = http.NewRequest("HEAD", "/old.html", nil)
+	rr = httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusMovedPermanently, rr.Code)
+	assert.Equal(t, "https://example.com/new.html", rr.Header().Get("Location"))
+	mockAWS.AssertExpectations(t)
+}
+
+func TestAwsS3_RoutingRules(t *testing.T) {
+	mockAWS := setupWebsite(t, `[
+		{"Condition": {"KeyPrefixEquals": "docs/"}, "Redirect": {"ReplaceKeyPrefixWith": "documents/"}},
+		{"Condition": {"KeyPrefixEquals": "images/", "HttpErrorCodeReturnedEquals": "404"},
+		 "Redirect": {"HostName": "cdn.example.com", "Protocol": "https", "HttpRedirectCode": "302"}}
+	]`)
+
+	// Prefix rules apply before S3 is asked
+	req, _ := http.NewRequest("GET", "/docs/guide.html", nil)
+	rr := httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusMovedPermanently, rr.Code)
+	assert.Equal(t, "/documents/guide.html", rr.Header().Get("Location"))
+
+	// Error code rules only when S3 answers with it
+	mockAWS.On("S3get", mock.Anything, "bucket", "/images/logo.png", (*string)(nil), (*service.Conditions)(nil)).Return(nil,
+		&smithy.GenericAPIError{Code: "NoSuchKey"}).Once()
+	req, _ = http.NewRequest("GET", "/images/logo.png", nil)
+	rr = httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusFound, rr.Code)
+	assert.Equal(t, "https://cdn.example.com/images/logo.png", rr.Header().Get("Location"))
+
+	mockAWS.On("S3get", mock.Anything, "bucket", "/images/private.png", (*string)(nil), (*service.Conditions)(nil)).Return(nil,
+		&smithy.GenericAPIError{Code: "AccessDenied"}).Once()
+	req, _ = http.NewRequest("GET", "/images/private.png", nil)
+	rr = httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	mockAWS.AssertExpectations(t)
+}
@@ -0,0 +1,205 @@
+package controllers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/patrickdk77/aws-s3-proxy/internal/config"
+	"github.com/patrickdk77/aws-s3-proxy/internal/httperr"
+	"github.com/patrickdk77/aws-s3-proxy/internal/metrics"
+	"github.com/patrickdk77/aws-s3-proxy/internal/service"
+)
+
+// putObject streams the request body into S3 at the requested key
+func putObject(w http.ResponseWriter, r *http.Request, client service.AWS, c *config.Settings, path string) {
+	if strings.HasSuffix(path, "/") {
+		httperr.WriteMessage(w, r, http.StatusBadRequest, "Cannot upload to a directory")
+		return
+	}
+	if c.UploadMaxSize > 0 {
+		if r.ContentLength > c.UploadMaxSize {
+			httperr.Write(w, r, http.StatusRequestEntityTooLarge, "")
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, c.UploadMaxSize)
+	}
+
+	opts := &service.PutOptions{
+		ContentType:        headerString(r, "Content-Type"),
+		ContentEncoding:    headerString(r, "Content-Encoding"),
+		ContentDisposition: headerString(r, "Content-Disposition"),
+		CacheControl:       headerString(r, "Cache-Control"),
+		ContentMD5:         headerString(r, "Content-MD5"),
+		IfMatch:            headerString(r, "If-Match"),
+		IfNoneMatch:        headerString(r, "If-None-Match"),
+		PartSize:           c.UploadPartSize,
+	}
+	etag, err := client.S3put(r.Context(), c.S3Bucket, c.S3KeyPrefix+path, r.Body, opts)
+	metrics.UpdateS3Writes(err, metrics.PutObjectAction, metrics.ProxySource)
+	if err != nil {
+		code, message := toHTTPError(err)
+		httperr.Write(w, r, code, message)
+		return
+	}
+	invalidateCache(c.S3Bucket, c.S3KeyPrefix+path)
+
+	setStrHeader(w, "ETag", etag)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// deleteObject removes the requested key from S3
+func deleteObject(w http.ResponseWriter, r *http.Request, client service.AWS, c *config.Settings, path string) {
+	if strings.HasSuffix(path, "/") {
+		httperr.WriteMessage(w, r, http.StatusBadRequest, "Cannot delete a directory")
+		return
+	}
+	err := client.S3delete(r.Context(), c.S3Bucket, c.S3KeyPrefix+path)
+	metrics.UpdateS3Writes(err, metrics.DeleteObjectAction, metrics.ProxySource)
+	if err != nil {
+		code, message := toHTTPError(err)
+		httperr.Write(w, r, code, message)
+		return
+	}
+	invalidateCache(c.S3Bucket, c.S3KeyPrefix+path)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// uploadFormFiles streams the files of a multipart/form-data POST from a directory
+// listing into that directory, then sends the browser back to the listing
+func uploadFormFiles(w http.ResponseWriter, r *http.Request, client service.AWS, c *config.Settings, path string) {
+	if !strings.HasSuffix(path, "/") {
+		httperr.WriteMessage(w, r, http.StatusBadRequest, "Uploads go to a directory")
+		return
+	}
+	// Browsers send credentials along with cross site form posts
+	if origin := r.Header.Get("Origin"); len(origin) > 0 {
+		if u, err := url.Parse(origin); err != nil || u.Host != r.Host {
+			httperr.Write(w, r, http.StatusForbidden, "")
+			return
+		}
+	}
+	if c.UploadMaxSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, c.UploadMaxSize)
+	}
+	mr, err := r.MultipartReader()
+	if err != nil {
+		httperr.Write(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	uploaded := 0
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			code, message := toHTTPError(err)
+			if code == http.StatusInternalServerError {
+				code = http.StatusBadRequest
+			}
+			httperr.Write(w, r, code, message)
+			return
+		}
+		// Other form fields, and browsers send an empty file input as a part without a name
+		name := uploadFileName(part.FileName())
+		if len(name) == 0 {
+			part.Close()
+			continue
+		}
+		if !allowedExtension(name, c.UploadExtensions) {
+			part.Close()
+			httperr.WriteMessage(w, r, http.StatusBadRequest, "File type not allowed: "+name)
+			return
+		}
+
+		opts := &service.PutOptions{PartSize: c.UploadPartSize}
+		if contentType := part.Header.Get("Content-Type"); len(contentType) > 0 {
+			opts.ContentType = aws.String(contentType)
+		}
+		_, err = client.S3put(r.Context(), c.S3Bucket, c.S3KeyPrefix+path+name, part, opts)
+		metrics.UpdateS3Writes(err, metrics.PutObjectAction, metrics.ProxySource)
+		part.Close()
+		if err != nil {
+			code, message := toHTTPError(err)
+			httperr.Write(w, r, code, message)
+			return
+		}
+		invalidateCache(c.S3Bucket, c.S3KeyPrefix+path+name)
+		uploaded++
+	}
+	if uploaded == 0 {
+		httperr.WriteMessage(w, r, http.StatusBadRequest, "No files uploaded")
+		return
+	}
+	http.Redirect(w, r, r.URL.RequestURI(), http.StatusSeeOther)
+}
+
+// uploadFileName keeps only the base name of an uploaded file, some browsers send the full local path
+func uploadFileName(name string) string {
+	name = strings.ReplaceAll(name, "\\", "/")
+	name = name[strings.LastIndex(name, "/")+1:]
+	if name == "." || name == ".." {
+		return ""
+	}
+	return name
+}
+
+// allowedExtension reports if name ends with one of the lowercase extensions, any name is allowed without them
+func allowedExtension(name string, extensions []string) bool {
+	if len(extensions) == 0 {
+		return true
+	}
+	name = strings.ToLower(name)
+	for _, extension := range extensions {
+		if strings.HasSuffix(name, extension) {
+			return true
+		}
+	}
+	return false
+}
+
+// invalidateCache drops a changed object, which may be an error document or manifest, and the listing of its directory from the cache
+func invalidateCache(bucket, key string) {
+	if dir, ok := strings.CutSuffix(key, redirectsKey); ok {
+		manifestCache.Delete(manifestCacheKey(bucket, dir))
+	} else if dir, ok := strings.CutSuffix(key, headersKey); ok {
+		manifestCache.Delete(manifestCacheKey(bucket, dir))
+	}
+	zipIndexes.Delete(zipIndexCacheKey(bucket, key))
+	precompressedCache.Delete(precompressedCacheKey(bucket, key))
+	if httpCache == nil {
+		return
+	}
+	httpCache.Delete(objectCacheKey(bucket, key))
+	httpCache.Delete(errorDocumentCacheKey(bucket, key))
+	httpCache.Delete(symlinkCacheKey(bucket, key))
+	// A new version, like releases/v1.3/app.tar, changes the latest of every directory above it,
+	// and the recursive listings and searches of those directories
+	for dir := key[:strings.LastIndex(key, "/")+1]; len(dir) > 0; dir = dir[:strings.LastIndex(strings.TrimSuffix(dir, "/"), "/")+1] {
+		httpCache.DeletePrefix(listingCacheKey(bucket, dir, ""))
+		httpCache.DeletePrefix(latestCacheKey(bucket, dir, ""))
+	}
+}
+
+// allowedMethods lists the methods AwsS3 answers for the Allow header
+func allowedMethods(c *config.Settings) string {
+	if c.WriteEnabled && c.DirListingUpload {
+		return "GET, HEAD, PUT, DELETE, POST"
+	}
+	if c.WriteEnabled {
+		return "GET, HEAD, PUT, DELETE"
+	}
+	return "GET, HEAD"
+}
+
+func headerString(r *http.Request, key string) *string {
+	if v := r.Header.Get(key); len(v) > 0 {
+		return aws.String(v)
+	}
+	return nil
+}
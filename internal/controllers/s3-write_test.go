@@ -0,0 +1,149 @@
+package controllers
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/patrickdk77/aws-s3-proxy/internal/config"
+	"github.com/patrickdk77/aws-s3-proxy/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func setupWrite(t *testing.T, enabled bool) *MockAWS {
+	mockAWS := new(MockAWS)
+	setupAWS(t, mockAWS)
+	config.Config.CacheSize = 10 * 1024 * 1024
+	config.Config.CacheTTL = 1 * time.Minute
+	config.Config.CacheMaxFileSize = 1 * 1024 * 1024
+	config.Config.WriteEnabled = enabled
+	config.Config.UploadMaxSize = 0
+	return mockAWS
+}
+
+func TestAwsS3_PutDisabled(t *testing.T) {
+	mockAWS := setupWrite(t, false)
+
+	req, _ := http.NewRequest("PUT", "/upload.txt", strings.NewReader("content"))
+	rr := httptest.NewRecorder()
+	AwsS3(rr, req)
+
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+	assert.Equal(t, "GET, HEAD", rr.Header().Get("Allow"))
+	mockAWS.AssertExpectations(t)
+}
+
+func TestAwsS3_Put(t *testing.T) {
+	mockAWS := setupWrite(t, true)
+
+	// Cache the old version first
+	mockAWS.On("S3get", mock.Anything, "bucket", "/upload.txt", (*string)(nil), (*service.Conditions)(nil)).Return(&s3.GetObjectOutput{
+		Body:          io.NopCloser(bytes.NewBufferString("old")),
+		ContentLength: aws.Int64(3),
+	}, nil).Once()
+	req, _ := http.NewRequest("GET", "/upload.txt", nil)
+	AwsS3(httptest.NewRecorder(), req)
+
+	opts := &service.PutOptions{
+		ContentType: aws.String("text/plain"),
+		ContentMD5:  aws.String("mgNkuembtIDdJeHwKEyFVQ=="),
+		PartSize:    config.Config.UploadPartSize,
+	}
+	mockAWS.On("S3put", mock.Anything, "bucket", "/upload.txt", mock.Anything, opts).Return(aws.String(`"etag"`), nil).Once()
+
+	req, _ = http.NewRequest("PUT", "/upload.txt", strings.NewReader("content"))
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("Content-MD5", "mgNkuembtIDdJeHwKEyFVQ==")
+	rr := httptest.NewRecorder()
+	AwsS3(rr, req)
+
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.Equal(t, `"etag"`, rr.Header().Get("ETag"))
+	assert.Nil(t, httpCache.Get(objectCacheKey("bucket", "/upload.txt")))
+	mockAWS.AssertExpectations(t)
+}
+
+func TestAwsS3_PutTooLarge(t *testing.T) {
+	mockAWS := setupWrite(t, true)
+	config.Config.UploadMaxSize = 4
+
+	req, _ := http.NewRequest("PUT", "/upload.txt", strings.NewReader("content"))
+	rr := httptest.NewRecorder()
+	AwsS3(rr, req)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+	mockAWS.AssertExpectations(t)
+}
+
+func TestAwsS3_Delete(t *testing.T) {
+	mockAWS := setupWrite(t, true)
+
+	mockAWS.On("S3delete", mock.Anything, "bucket", "/upload.txt").Return(nil).Once()
+
+	req, _ := http.NewRequest("DELETE", "/upload.txt", nil)
+	rr := httptest.NewRecorder()
+	AwsS3(rr, req)
+
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	mockAWS.AssertExpectations(t)
+}
+
+func TestUploadFileName(t *testing.T) {
+	assert.Equal(t, "report.pdf", uploadFileName("report.pdf"))
+	assert.Equal(t, "report.pdf", uploadFileName(`C:\Users\me\report.pdf`))
+	assert.Equal(t, "", uploadFileName(".."))
+}
+
+func TestAllowedExtension(t *testing.T) {
+	assert.True(t, allowedExtension("anything.exe", []string{}))
+	assert.True(t, allowedExtension("Report.PDF", []string{".png", ".pdf"}))
+	assert.False(t, allowedExtension("report.exe", []string{".png", ".pdf"}))
+}
+
+func TestAwsS3_UploadForm(t *testing.T) {
+	mockAWS := setupWrite(t, true)
+	config.Config.DirListingUpload = true
+	config.Config.UploadExtensions = []string{".txt"}
+
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	fw, _ := mw.CreateFormFile("file", "notes.txt")
+	_, _ = fw.Write([]byte("notes"))
+	_ = mw.Close()
+
+	mockAWS.On("S3put", mock.Anything, "bucket", "/shared/notes.txt", mock.Anything, &service.PutOptions{
+		ContentType: aws.String("application/octet-stream"),
+		PartSize:    config.Config.UploadPartSize,
+	}).Return(aws.String(`"etag"`), nil).Once()
+
+	req, _ := http.NewRequest("POST", "http://example.com/shared/", body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("Origin", "http://example.com")
+	rr := httptest.NewRecorder()
+	AwsS3(rr, req)
+
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Equal(t, "/shared/", rr.Header().Get("Location"))
+	mockAWS.AssertExpectations(t)
+}
+
+func TestAwsS3_UploadFormCrossOrigin(t *testing.T) {
+	mockAWS := setupWrite(t, true)
+	config.Config.DirListingUpload = true
+
+	req, _ := http.NewRequest("POST", "http://example.com/shared/", strings.NewReader(""))
+	req.Header.Set("Origin", "http://evil.example.net")
+	rr := httptest.NewRecorder()
+	AwsS3(rr, req)
+
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	mockAWS.AssertExpectations(t)
+}
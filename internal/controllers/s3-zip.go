@@ -0,0 +1,458 @@
+package controllers
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/karlseguin/ccache/v3"
+	"github.com/patrickdk77/aws-s3-proxy/internal/config"
+	"github.com/patrickdk77/aws-s3-proxy/internal/httperr"
+	"github.com/patrickdk77/aws-s3-proxy/internal/metrics"
+	"github.com/patrickdk77/aws-s3-proxy/internal/service"
+)
+
+// zipMemberSeparator separates an archive from the member served, like /reports/run-42.zip/!/index.html
+const zipMemberSeparator = "/!/"
+
+// zipReadAhead is read at once while parsing a central directory, archive/zip reads it in small pieces
+const zipReadAhead = 64 * 1024
+
+// Fixed sizes of the records of the zip format
+const (
+	zipLocalHeaderLen  = 30 // local file header before each member
+	zipDirHeaderLen    = 46 // central directory file header
+	zipEndLen          = 22 // end of central directory record
+	zipEnd64LocatorLen = 20 // zip64 end of central directory locator
+	zipEnd64Len        = 56 // zip64 end of central directory record
+)
+
+// zipIndexes holds the central directories of archives, whether or not CACHE_SIZE enables httpCache,
+// bounded by the bytes their entries take rather than their number
+var zipIndexes = ccache.New(ccache.Configure[*zipIndex]().MaxSize(64 * 1024 * 1024))
+
+var errZipChanged = errors.New("archive changed while read")
+
+// zipIndex is the central directory of an archive, without any reader so each request reads with its own client
+type zipIndex struct {
+	etag  string
+	files map[string]*zipEntry
+	dirs  map[string]bool // directories holding members, like docs/
+	size  int64
+}
+
+// zipEntry is a member and where its local file header starts, which archive/zip does not export
+type zipEntry struct {
+	zip.FileHeader
+	headerOffset int64
+}
+
+// Size weighs an index in zipIndexes, roughly the memory of its entries
+func (idx *zipIndex) Size() int64 {
+	return idx.size
+}
+
+func zipIndexCacheKey(bucket, key string) string {
+	return "ZipIndex:=" + bucket + ":" + key
+}
+
+// zipMemberPath splits a path into the archive and the member asked for
+func zipMemberPath(path string) (string, string, bool) {
+	i := strings.Index(strings.ToLower(path), ".zip"+zipMemberSeparator)
+	if i < 0 {
+		return "", "", false
+	}
+	return path[:i+len(".zip")], path[i+len(".zip"+zipMemberSeparator):], true
+}
+
+// s3ReaderAt reads the central directory of an object with ranged requests, failing once the object changed
+type s3ReaderAt struct {
+	ctx       context.Context
+	client    service.AWS
+	bucket    string
+	key       string
+	etag      string
+	size      int64
+	buf       []byte
+	bufOffset int64
+}
+
+func (ra *s3ReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off >= ra.size {
+		return 0, io.EOF
+	}
+	if off < ra.bufOffset || off+int64(len(p)) > ra.bufOffset+int64(len(ra.buf)) {
+		data, err := ra.get(off, min(ra.size, off+max(int64(len(p)), zipReadAhead)))
+		if err != nil {
+			return 0, err
+		}
+		ra.buf, ra.bufOffset = data, off
+	}
+	n := copy(p, ra.buf[off-ra.bufOffset:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// get reads the bytes from start to end, excluded
+func (ra *s3ReaderAt) get(start, end int64) ([]byte, error) {
+	obj, err := getZipRange(ra.ctx, ra.client, ra.bucket, ra.key, ra.etag, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+	data := make([]byte, end-start)
+	_, err = io.ReadFull(obj, data)
+	return data, err
+}
+
+// getZipRange reads the bytes of an archive from start to end, excluded, as long as it keeps its etag
+func getZipRange(ctx context.Context, client service.AWS, bucket, key, etag string, start, end int64) (io.ReadCloser, error) {
+	obj, err := client.S3get(ctx, bucket, key, aws.String(fmt.Sprintf("bytes=%d-%d", start, end-1)),
+		&service.Conditions{IfMatch: aws.String(etag)})
+	metrics.UpdateS3Reads(err, metrics.GetObjectAction, metrics.ProxySource)
+	if err != nil {
+		if code, _ := toHTTPError(err); code == http.StatusPreconditionFailed {
+			return nil, fmt.Errorf("%s: %w", key, errZipChanged)
+		}
+		return nil, err
+	}
+	return obj.Body, nil
+}
+
+// loadZipIndex reads the central directory of an archive with a few ranged requests, cached for CACHE_TTL_INDEX
+func loadZipIndex(r *http.Request, client service.AWS, c *config.Settings, key string) (*zipIndex, error) {
+	item, err := zipIndexes.Fetch(zipIndexCacheKey(c.S3Bucket, key), c.CacheTTLIndex, func() (*zipIndex, error) {
+		head, err := client.S3head(r.Context(), c.S3Bucket, key, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		ra := &s3ReaderAt{ctx: r.Context(), client: client, bucket: c.S3Bucket, key: key,
+			etag: aws.ToString(head.ETag), size: aws.ToInt64(head.ContentLength)}
+		zr, err := zip.NewReader(ra, ra.size)
+		if err != nil {
+			return nil, err
+		}
+		offsets, err := zipHeaderOffsets(ra, ra.size)
+		if err != nil {
+			return nil, err
+		}
+		if len(offsets) != len(zr.File) {
+			return nil, fmt.Errorf("%s: %w", key, zip.ErrFormat)
+		}
+		idx := &zipIndex{etag: ra.etag, files: map[string]*zipEntry{}, dirs: map[string]bool{"": true}}
+		for i, f := range zr.File {
+			name := strings.TrimPrefix(path.Clean("/"+f.Name), "/")
+			idx.size += int64(2*len(name)) + 128
+			if strings.HasSuffix(f.Name, "/") {
+				idx.dirs[name+"/"] = true
+			} else {
+				entry := &zipEntry{FileHeader: f.FileHeader, headerOffset: offsets[i]}
+				entry.Extra, entry.Comment = nil, ""
+				idx.files[name] = entry
+			}
+			for dir := path.Dir(name); dir != "." && dir != "/"; dir = path.Dir(dir) {
+				idx.dirs[dir+"/"] = true
+			}
+		}
+		return idx, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return item.Value(), nil
+}
+
+// zipHeaderOffsets reads where the local file header of each member starts, in the order of the central directory
+// like zip.Reader.File. Archives with data before them, like self-extracting ones, are read as archive/zip does.
+func zipHeaderOffsets(ra io.ReaderAt, size int64) ([]int64, error) {
+	// The end of central directory record, followed by a comment of up to 64KB
+	tail := make([]byte, min(size, zipEndLen+0xffff))
+	if _, err := ra.ReadAt(tail, size-int64(len(tail))); err != nil && err != io.EOF {
+		return nil, err
+	}
+	i := bytes.LastIndex(tail, []byte("PK\x05\x06"))
+	if i < 0 || len(tail)-i < zipEndLen {
+		return nil, zip.ErrFormat
+	}
+	end := size - int64(len(tail)-i)
+	count := uint64(binary.LittleEndian.Uint16(tail[i+10:]))
+	dirSize := uint64(binary.LittleEndian.Uint32(tail[i+12:]))
+	dirOffset := uint64(binary.LittleEndian.Uint32(tail[i+16:]))
+	if count == 0xffff || dirSize == 0xffffffff || dirOffset == 0xffffffff {
+		// Zip64, whose end of central directory record the locator right before points to
+		if i < zipEnd64LocatorLen || string(tail[i-zipEnd64LocatorLen:i-zipEnd64LocatorLen+4]) != "PK\x06\x07" {
+			return nil, zip.ErrFormat
+		}
+		end = int64(binary.LittleEndian.Uint64(tail[i-zipEnd64LocatorLen+8:]))
+		record := make([]byte, zipEnd64Len)
+		if _, err := ra.ReadAt(record, end); err != nil || string(record[:4]) != "PK\x06\x06" {
+			return nil, zip.ErrFormat
+		}
+		count = binary.LittleEndian.Uint64(record[32:])
+		dirSize = binary.LittleEndian.Uint64(record[40:])
+		dirOffset = binary.LittleEndian.Uint64(record[48:])
+	}
+	base := max(end-int64(dirSize)-int64(dirOffset), 0)
+	if dirSize > uint64(end) || count > dirSize/zipDirHeaderLen {
+		return nil, zip.ErrFormat
+	}
+	dir := make([]byte, dirSize)
+	if _, err := ra.ReadAt(dir, base+int64(dirOffset)); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	offsets := make([]int64, 0, count)
+	for len(dir) >= zipDirHeaderLen && string(dir[:4]) == "PK\x01\x02" {
+		nameLen := int(binary.LittleEndian.Uint16(dir[28:]))
+		extraLen := int(binary.LittleEndian.Uint16(dir[30:]))
+		recordLen := zipDirHeaderLen + nameLen + extraLen + int(binary.LittleEndian.Uint16(dir[32:]))
+		if len(dir) < recordLen {
+			return nil, zip.ErrFormat
+		}
+		offset := uint64(binary.LittleEndian.Uint32(dir[42:]))
+		if offset == 0xffffffff {
+			// In the zip64 extra field, after the sizes that overflow too
+			skip := 0
+			for _, field := range []int{24, 20} {
+				if binary.LittleEndian.Uint32(dir[field:]) == 0xffffffff {
+					skip += 8
+				}
+			}
+			extra := dir[zipDirHeaderLen+nameLen : zipDirHeaderLen+nameLen+extraLen]
+			for len(extra) >= 4 {
+				id, n := binary.LittleEndian.Uint16(extra), int(binary.LittleEndian.Uint16(extra[2:]))
+				if len(extra) < 4+n {
+					break
+				}
+				if id == 0x0001 && n >= skip+8 {
+					offset = binary.LittleEndian.Uint64(extra[4+skip:])
+					break
+				}
+				extra = extra[4+n:]
+			}
+		}
+		offsets = append(offsets, base+int64(offset))
+		dir = dir[recordLen:]
+	}
+	return offsets, nil
+}
+
+// serveZipMember answers with a member of an archive, or the listing of a directory in it
+func serveZipMember(w http.ResponseWriter, r *http.Request, client service.AWS, c *config.Settings, archive, member string) {
+	key := c.S3KeyPrefix + archive
+	idx, err := loadZipIndex(r, client, c, key)
+	if err != nil {
+		code, message := toHTTPError(err)
+		writeS3Error(w, r, client, c, archive, code, message)
+		return
+	}
+	dir := member == "" || strings.HasSuffix(member, "/")
+	switch {
+	case dir && idx.files[member+c.IndexDocument] != nil && (!c.DirectoryListing || c.DirListingCheckIndex):
+		member += c.IndexDocument
+	case dir && idx.dirs[member] && c.DirectoryListing:
+		serveZipListing(w, r, client, c, archive, member, idx)
+		return
+	case !dir && idx.files[member] == nil && idx.dirs[member+"/"]:
+		http.Redirect(w, r, r.URL.Path+"/", http.StatusMovedPermanently)
+		return
+	}
+	f := idx.files[member]
+	if f == nil {
+		writeS3Error(w, r, client, c, archive+zipMemberSeparator+member, http.StatusNotFound, "no "+member+" in "+key)
+		return
+	}
+	if f.Flags&0x1 != 0 || (f.Method != zip.Store && f.Method != zip.Deflate) {
+		code, message := toHTTPError(fmt.Errorf("%s in %s: %w", member, key, zip.ErrAlgorithm))
+		httperr.Write(w, r, code, message)
+		return
+	}
+
+	// Deflated members go out as they are stored, in a gzip envelope, to clients accepting gzip.
+	// That representation only weakly equals the inflated one, so it gets a weak ETag.
+	passthrough := f.Method == zip.Deflate && encodingQuality(r, "gzip") > 0
+	etag := fmt.Sprintf(`"%s-%08x"`, strings.Trim(idx.etag, `"`), f.CRC32)
+	if passthrough {
+		etag = "W/" + etag
+	}
+	modified := f.Modified
+	if status := checkPreconditions(r, &etag, &modified); status != 0 {
+		if status != http.StatusNotModified {
+			httperr.Write(w, r, status, "")
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+		writeNotModified(w)
+		return
+	}
+	// Opened before any header is set, an error answers in plain text
+	var body io.ReadCloser
+	if r.Method != http.MethodHead {
+		if body, err = openZipMember(r, client, c, key, idx, f); err != nil {
+			if errors.Is(err, errZipChanged) {
+				zipIndexes.Delete(zipIndexCacheKey(c.S3Bucket, key))
+				w.Header().Set("Retry-After", "1")
+				httperr.Write(w, r, http.StatusServiceUnavailable, err.Error())
+				return
+			}
+			code, message := toHTTPError(err)
+			httperr.Write(w, r, code, message)
+			return
+		}
+		defer body.Close()
+	}
+	contentType := mime.TypeByExtension(path.Ext(member))
+	if len(c.ContentType) > 0 {
+		contentType = c.ContentType
+	} else if len(contentType) == 0 {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	if len(c.HTTPCacheControl) > 0 {
+		w.Header().Set("Cache-Control", c.HTTPCacheControl)
+	}
+	if len(c.HTTPExpires) > 0 {
+		w.Header().Set("Expires", c.HTTPExpires)
+	}
+	length := f.UncompressedSize64
+	if f.Method == zip.Deflate {
+		w.Header().Add("Vary", "Accept-Encoding")
+	}
+	if passthrough {
+		w.Header().Set("Content-Encoding", "gzip")
+		length = f.CompressedSize64 + gzipEnvelopeSize
+	}
+	w.Header().Set("Content-Length", strconv.FormatUint(length, 10))
+	w.WriteHeader(http.StatusOK)
+	if body == nil {
+		return
+	}
+	if passthrough {
+		err = writeGzipEnvelope(w, body, f)
+	} else {
+		err = writeZipMember(w, body, f)
+	}
+	if err != nil {
+		abortStream(r, err)
+	}
+}
+
+// openZipMember reads the local file header of a member, then its compressed data, with the client and context of the request
+func openZipMember(r *http.Request, client service.AWS, c *config.Settings, key string, idx *zipIndex, f *zipEntry) (io.ReadCloser, error) {
+	if f.CompressedSize64 == 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	body, err := getZipRange(r.Context(), client, c.S3Bucket, key, idx.etag, f.headerOffset, f.headerOffset+zipLocalHeaderLen)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, zipLocalHeaderLen)
+	_, err = io.ReadFull(body, header)
+	body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint32(header) != 0x04034b50 {
+		return nil, fmt.Errorf("%s in %s: %w", f.Name, key, zip.ErrFormat)
+	}
+	// The name and extra field of the local header may differ from the central directory
+	offset := f.headerOffset + zipLocalHeaderLen + int64(binary.LittleEndian.Uint16(header[26:])) + int64(binary.LittleEndian.Uint16(header[28:]))
+	return getZipRange(r.Context(), client, c.S3Bucket, key, idx.etag, offset, offset+int64(f.CompressedSize64))
+}
+
+// writeZipMember writes a member inflated, failing when it does not match its checksum
+func writeZipMember(w io.Writer, body io.Reader, f *zipEntry) error {
+	if f.Method == zip.Deflate {
+		inflater := flate.NewReader(body)
+		defer inflater.Close()
+		body = inflater
+	}
+	hash := crc32.NewIEEE()
+	n, err := io.Copy(io.MultiWriter(w, hash), body)
+	if err != nil {
+		return err
+	}
+	if uint64(n) != f.UncompressedSize64 || hash.Sum32() != f.CRC32 {
+		return fmt.Errorf("%s: %w", f.Name, zip.ErrChecksum)
+	}
+	return nil
+}
+
+// gzipEnvelopeSize is the size of the gzip header and trailer around deflated data
+const gzipEnvelopeSize = 10 + 8
+
+// writeGzipEnvelope writes a deflated member as a gzip stream, whose trailer holds the CRC-32 and size zip has too
+func writeGzipEnvelope(w io.Writer, body io.Reader, f *zipEntry) error {
+	header := []byte{0x1f, 0x8b, 8, 0, 0, 0, 0, 0, 0, 255}
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if n, err := io.Copy(w, body); err != nil {
+		return err
+	} else if uint64(n) != f.CompressedSize64 {
+		return fmt.Errorf("%s: %w", f.Name, io.ErrUnexpectedEOF)
+	}
+	trailer := binary.LittleEndian.AppendUint32(nil, f.CRC32)
+	trailer = binary.LittleEndian.AppendUint32(trailer, uint32(f.UncompressedSize64))
+	_, err := w.Write(trailer)
+	return err
+}
+
+// serveZipListing lists a directory of an archive like a directory of the bucket
+func serveZipListing(w http.ResponseWriter, r *http.Request, client service.AWS, c *config.Settings, archive, dir string, idx *zipIndex) {
+	c, ok := listingSettings(r, c)
+	if !ok {
+		httperr.WriteMessage(w, r, http.StatusBadRequest, "Unknown listing format")
+		return
+	}
+	if _, err := parseListingQuery(r); err != nil {
+		httperr.WriteMessage(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	w.Header().Add("Vary", "Accept")
+	result := &s3.ListObjectsV2Output{}
+	for name := range idx.dirs {
+		if rest, ok := strings.CutPrefix(name, dir); ok && len(rest) > 0 && strings.Count(rest, "/") == 1 {
+			result.CommonPrefixes = append(result.CommonPrefixes, types.CommonPrefix{Prefix: aws.String(name)})
+		}
+	}
+	for name, f := range idx.files {
+		if rest, ok := strings.CutPrefix(name, dir); ok && !strings.Contains(rest, "/") {
+			result.Contents = append(result.Contents, types.Object{Key: aws.String(name), Size: aws.Int64(int64(f.UncompressedSize64)),
+				LastModified: aws.Time(f.Modified)})
+		}
+	}
+	// In key order like S3, when SORT leaves it
+	sort.Slice(result.CommonPrefixes, func(i, j int) bool {
+		return aws.ToString(result.CommonPrefixes[i].Prefix) < aws.ToString(result.CommonPrefixes[j].Prefix)
+	})
+	sort.Slice(result.Contents, func(i, j int) bool {
+		return aws.ToString(result.Contents[i].Key) < aws.ToString(result.Contents[j].Key)
+	})
+	obj, err := renderListingResult(r, client, c, archive+zipMemberSeparator+dir, dir, result)
+	if err != nil {
+		httperr.Write(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeListing(w, obj)
+}
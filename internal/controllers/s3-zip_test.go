@@ -0,0 +1,248 @@
+package controllers
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/patrickdk77/aws-s3-proxy/internal/config"
+	"github.com/patrickdk77/aws-s3-proxy/internal/service"
+	"github.com/stretchr/testify/assert"
+)
+
+// zipAWS serves an archive with ranged requests
+type zipAWS struct {
+	MockAWS
+	data  []byte
+	etag  string
+	gets  int
+	heads int
+}
+
+func (z *zipAWS) S3head(ctx context.Context, bucket, key string, rangeHeader *string, cond *service.Conditions) (*s3.HeadObjectOutput, error) {
+	z.heads++
+	return &s3.HeadObjectOutput{ContentLength: aws.Int64(int64(len(z.data))), ETag: aws.String(z.etag)}, nil
+}
+
+func (z *zipAWS) S3get(ctx context.Context, bucket, key string, rangeHeader *string, cond *service.Conditions) (*s3.GetObjectOutput, error) {
+	z.gets++
+	if cond == nil || aws.ToString(cond.IfMatch) != z.etag {
+		return nil, fmt.Errorf("read without the etag of the archive")
+	}
+	var start, end int
+	_, _ = fmt.Sscanf(aws.ToString(rangeHeader), "bytes=%d-%d", &start, &end)
+	return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(z.data[start : end+1]))}, nil
+}
+
+func setupZip(t *testing.T) *zipAWS {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	mtime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for _, member := range []struct {
+		name    string
+		method  uint16
+		content string
+	}{
+		{"index.html", zip.Deflate, strings.Repeat("<p>report</p>", 100)},
+		{"css/site.css", zip.Store, "body{}"},
+		{"logs/empty.txt", zip.Store, ""},
+	} {
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: member.name, Method: member.method, Modified: mtime})
+		assert.NoError(t, err)
+		_, _ = io.WriteString(fw, member.content)
+	}
+	assert.NoError(t, zw.Close())
+
+	z := &zipAWS{data: buf.Bytes(), etag: `"abc"`}
+	setupAWS(t, z)
+	config.Config.ZipMembers = true
+	return z
+}
+
+func TestZipMemberPath(t *testing.T) {
+	for path, expected := range map[string][]string{
+		"/reports/run-42.zip/!/index.html": {"/reports/run-42.zip", "index.html"},
+		"/reports/RUN.ZIP/!/":              {"/reports/RUN.ZIP", ""},
+		"/a.zip/!/b.zip/!/c":               {"/a.zip", "b.zip/!/c"},
+	} {
+		archive, member, ok := zipMemberPath(path)
+		assert.True(t, ok, path)
+		assert.Equal(t, expected, []string{archive, member}, path)
+	}
+	for _, path := range []string{"/reports/run-42.zip", "/reports/run-42.zip/index.html", "/zip/!/x"} {
+		_, _, ok := zipMemberPath(path)
+		assert.False(t, ok, path)
+	}
+}
+
+func TestZipHeaderOffsets(t *testing.T) {
+	// Data before the archive, like the program of a self-extracting one, with offsets relative to the archive or the file
+	for _, absolute := range []bool{false, true} {
+		var buf bytes.Buffer
+		buf.WriteString("#!/bin/sh\nexit 0\n")
+		zw := zip.NewWriter(&buf)
+		if absolute {
+			zw.SetOffset(int64(buf.Len()))
+		}
+		for _, name := range []string{"a.txt", "dir/", "dir/b.txt"} {
+			fw, err := zw.Create(name)
+			assert.NoError(t, err)
+			_, _ = io.WriteString(fw, strings.Repeat(name, 10))
+		}
+		assert.NoError(t, zw.Close())
+
+		data := bytes.NewReader(buf.Bytes())
+		zr, err := zip.NewReader(data, data.Size())
+		assert.NoError(t, err)
+		offsets, err := zipHeaderOffsets(data, data.Size())
+		assert.NoError(t, err)
+		assert.Len(t, offsets, len(zr.File))
+		for i, f := range zr.File {
+			dataOffset, err := f.DataOffset()
+			assert.NoError(t, err)
+			assert.Equal(t, dataOffset, offsets[i]+zipLocalHeaderLen+int64(len(f.Name)), f.Name)
+		}
+	}
+
+	_, err := zipHeaderOffsets(strings.NewReader("not a zip"), 9)
+	assert.ErrorIs(t, err, zip.ErrFormat)
+}
+
+func TestAwsS3_ZipMember(t *testing.T) {
+	z := setupZip(t)
+
+	req, _ := http.NewRequest("GET", "/reports/run-42.zip/!/css/site.css", nil)
+	rr := httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "body{}", rr.Body.String())
+	assert.Equal(t, "text/css; charset=utf-8", rr.Header().Get("Content-Type"))
+	assert.Equal(t, "6", rr.Header().Get("Content-Length"))
+	assert.Equal(t, "Wed, 01 May 2024 12:00:00 GMT", rr.Header().Get("Last-Modified"))
+	etag := rr.Header().Get("ETag")
+	assert.True(t, strings.HasPrefix(etag, `"abc-`), etag)
+
+	// The index is read once, an empty member not at all
+	reads := z.gets
+	req, _ = http.NewRequest("GET", "/reports/run-42.zip/!/logs/empty.txt", nil)
+	rr = httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "", rr.Body.String())
+	assert.Equal(t, 1, z.heads)
+	assert.Equal(t, reads, z.gets)
+
+	// Members are read with the client of the request, not the one the index was read with
+	other := &zipAWS{data: z.data, etag: z.etag}
+	NewClientFunc = func(ctx context.Context, region *string) service.AWS {
+		return other
+	}
+	req, _ = http.NewRequest("GET", "/reports/run-42.zip/!/css/site.css", nil)
+	rr = httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, "body{}", rr.Body.String())
+	assert.Equal(t, reads, z.gets)
+	assert.Equal(t, 2, other.gets)
+	assert.Equal(t, 0, other.heads)
+	item := zipIndexes.Get(zipIndexCacheKey("bucket", "/reports/run-42.zip"))
+	assert.Greater(t, item.Value().Size(), int64(0))
+
+	req, _ = http.NewRequest("GET", "/reports/run-42.zip/!/css/site.css", nil)
+	req.Header.Set("If-None-Match", etag)
+	rr = httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusNotModified, rr.Code)
+
+	for path, code := range map[string]int{
+		"/reports/run-42.zip/!/missing.html": http.StatusNotFound,
+		"/reports/run-42.zip/!/css":          http.StatusMovedPermanently,
+		"/reports/run-42.zip/!/logs/":        http.StatusNotFound,
+	} {
+		req, _ = http.NewRequest("GET", path, nil)
+		rr = httptest.NewRecorder()
+		AwsS3(rr, req)
+		assert.Equal(t, code, rr.Code, path)
+	}
+
+	req, _ = http.NewRequest("PUT", "/reports/run-42.zip/!/index.html", nil)
+	rr = httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+}
+
+func TestAwsS3_ZipMemberDeflated(t *testing.T) {
+	setupZip(t)
+	expected := strings.Repeat("<p>report</p>", 100)
+
+	// The index document of the archive, inflated
+	req, _ := http.NewRequest("GET", "/reports/run-42.zip/!/", nil)
+	rr := httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, expected, rr.Body.String())
+	assert.Equal(t, "Accept-Encoding", rr.Header().Get("Vary"))
+	assert.Empty(t, rr.Header().Get("Content-Encoding"))
+	etag := rr.Header().Get("ETag")
+	assert.True(t, strings.HasPrefix(etag, `"`), etag)
+
+	// Passed through as gzip, with a weak ETag as the bytes differ
+	req, _ = http.NewRequest("GET", "/reports/run-42.zip/!/index.html", nil)
+	req.Header.Set("Accept-Encoding", "br, gzip;q=0.8")
+	rr = httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "gzip", rr.Header().Get("Content-Encoding"))
+	assert.Equal(t, "W/"+etag, rr.Header().Get("ETag"))
+	assert.Equal(t, fmt.Sprint(rr.Body.Len()), rr.Header().Get("Content-Length"))
+	g, err := gzip.NewReader(rr.Body)
+	assert.NoError(t, err)
+	body, err := io.ReadAll(g)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, string(body))
+
+	req, _ = http.NewRequest("GET", "/reports/run-42.zip/!/index.html", nil)
+	req.Header.Set("Accept-Encoding", "gzip;q=0")
+	rr = httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Empty(t, rr.Header().Get("Content-Encoding"))
+	assert.Equal(t, expected, rr.Body.String())
+}
+
+func TestAwsS3_ZipListing(t *testing.T) {
+	setupZip(t)
+	config.Config.DirectoryListing = true
+	config.Config.DirListingFormat = "json"
+
+	req, _ := http.NewRequest("GET", "/reports/run-42.zip/!/", nil)
+	rr := httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `{"path":"/reports/run-42.zip/!/","entries":[{"name":"css","type":"directory"},{"name":"logs","type":"directory"},`+
+		`{"name":"index.html","type":"file","size":1300,"mtime":"2024-05-01T12:00:00Z"}]}`, rr.Body.String())
+
+	req, _ = http.NewRequest("GET", "/reports/run-42.zip/!/css/?format=csv", nil)
+	rr = httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "name,type,size,mtime,etag,storage_class\nsite.css,file,6,2024-05-01T12:00:00Z,,\n", rr.Body.String())
+}
+
+func TestAwsS3_NotZip(t *testing.T) {
+	z := setupZip(t)
+	z.data = []byte("not a zip archive")
+
+	req, _ := http.NewRequest("GET", "/notes.zip/!/index.html", nil)
+	rr := httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
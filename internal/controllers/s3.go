@@ -2,7 +2,7 @@ package controllers
 
 import (
 	"bytes"
-	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"net/url"
@@ -19,6 +19,7 @@ import (
 	"github.com/go-openapi/swag/typeutils"
 	"github.com/karlseguin/ccache/v3"
 	"github.com/patrickdk77/aws-s3-proxy/internal/config"
+	"github.com/patrickdk77/aws-s3-proxy/internal/httperr"
 	"github.com/patrickdk77/aws-s3-proxy/internal/metrics"
 	"github.com/patrickdk77/aws-s3-proxy/internal/service"
 )
@@ -33,100 +34,184 @@ type cachedResponse struct {
 	*s3.GetObjectOutput
 	Body        []byte
 	ContentType string
+	Link        string // pages of a listing
 	Exists      bool
 }
 
+// objectCacheKey keys cached objects by bucket, as routes may serve several
+func objectCacheKey(bucket, key string) string {
+	return bucket + ":" + key
+}
+
+// listingCacheKey keys cached listings by bucket and the variant rendered for a route,
+// an empty variant gives the prefix of every variant
+func listingCacheKey(bucket, key, variant string) string {
+	return "IndexCache:=" + bucket + ":" + key + "?" + variant
+}
+
+func listingVariant(c *config.Settings) string {
+	return fmt.Sprintf("%s,%s,%t,%t,%t,%t,%t", strings.ToLower(c.DirListingFormat), c.DirListingTemplate,
+		c.SortDateAsc, c.SortDateDesc, c.SortFileAsc, c.SortFileDesc, c.SortNumeric)
+}
+
 type ObjectOutput interface {
 	s3.GetObjectOutput | s3.HeadObjectOutput
 }
 
 // AwsS3 handles requests for Amazon S3
 func AwsS3(w http.ResponseWriter, r *http.Request) {
-	c := config.Config
+	c := config.FromRequest(r)
+
+	// Set up the cache first, so nothing below sees it nil
+	if c.CacheSize > 0 && c.CacheTTL > 0 {
+		cacheOnce.Do(func() {
+			httpCache = ccache.New(ccache.Configure[cachedResponse]().MaxSize(c.CacheSize))
+		})
+	}
+
+	if len(c.S3Bucket) == 0 {
+		// Only possible with routes and no AWS_S3_BUCKET for other hosts
+		httperr.Write(w, r, http.StatusNotFound, "no bucket for host "+r.Host)
+		return
+	}
 
 	// Strip the prefix, if it's present.
 	path := r.URL.Path
 	if len(c.StripPath) > 0 {
 		path = strings.TrimPrefix(path, c.StripPath)
 	}
+	// A mounted path without its trailing slash
+	if len(path) == 0 {
+		http.Redirect(w, r, r.URL.Path+"/", http.StatusMovedPermanently)
+		return
+	}
 
 	// Range header
 	var rangeHeader *string
 	if candidate := r.Header.Get("Range"); !typeutils.IsZero(candidate) {
 		rangeHeader = aws.String(candidate)
 	}
+	cond := conditionsFromRequest(r)
 
-	client := NewClientFunc(r.Context(), aws.String(config.Config.AwsRegion))
+	client := NewClientFunc(r.Context(), aws.String(c.AwsRegion))
+
+	if routingRuleRedirect(w, r, c, path, 0) {
+		return
+	}
+	// HEADER_RULES, with _headers of the site over them
+	headers := c.HeadersFor(headerRulePath(path, c))
+	m := loadManifest(r, client, c)
+	if m != nil {
+		for key, values := range m.headersFor(path) {
+			headers[key] = values
+		}
+	}
+	w = withHeaders(w, headers)
+
+	// _redirects of the site
+	if m != nil {
+		// Hidden from visitors, still uploaded and deleted like any object
+		if isManifest(path) && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+			httperr.Write(w, r, http.StatusNotFound, "")
+			return
+		}
+		rewritten, done := m.redirect(w, r, client, c, path, false)
+		if done {
+			return
+		}
+		if len(rewritten) > 0 {
+			path = rewritten
+		}
+	}
 
 	// Replace path with symlink.json
-	idx := strings.Index(path, "symlink.json")
-	if idx > -1 {
-		replaced, err := replacePathWithSymlink(r, client, c.S3Bucket, c.S3KeyPrefix+path[:idx+12])
+	var err error
+	c, path, err = resolveSymlinks(w, r, client, c, path)
+	if err != nil {
+		code, message := toHTTPError(err)
+		writeS3Error(w, r, client, c, path, code, message)
+		return
+	}
+
+	// Replace LATEST_NAME with the newest version
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		latest, err := resolveLatest(r, client, c, path)
 		if err != nil {
 			code, message := toHTTPError(err)
-			http.Error(w, message, code)
+			writeS3Error(w, r, client, c, path, code, message)
 			return
 		}
-		path = aws.ToString(replaced) + path[idx+12:]
+		if latest != path && c.LatestRedirect {
+			location := c.StripPath + latest
+			if len(r.URL.RawQuery) > 0 {
+				location += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, location, http.StatusFound)
+			return
+		}
+		path = latest
 	}
 
-	if c.CacheSize > 0 && c.CacheTTL > 0 && rangeHeader == nil {
-		cacheOnce.Do(func() {
-			httpCache = ccache.New(ccache.Configure[cachedResponse]().MaxSize(c.CacheSize))
-		})
+	// Members of ZIP archives, like /reports/run-42.zip/!/index.html
+	if archive, member, ok := zipMemberPath(path); ok && c.ZipMembers {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			httperr.Write(w, r, http.StatusMethodNotAllowed, "")
+			return
+		}
+		serveZipMember(w, r, client, c, archive, member)
+		return
+	}
+
+	// Uploads and deletes act on the key as given, never on a listing or index document
+	if r.Method == http.MethodPut || r.Method == http.MethodDelete || r.Method == http.MethodPost {
+		if !c.WriteEnabled || (r.Method == http.MethodPost && !c.DirListingUpload) {
+			w.Header().Set("Allow", allowedMethods(c))
+			httperr.Write(w, r, http.StatusMethodNotAllowed, "")
+			return
+		}
+		switch r.Method {
+		case http.MethodPut:
+			putObject(w, r, client, c, path)
+		case http.MethodDelete:
+			deleteObject(w, r, client, c, path)
+		default:
+			uploadFormFiles(w, r, client, c, path)
+		}
+		return
 	}
 
 	// Ends with / -> listing or index.html
 	if strings.HasSuffix(path, "/") {
 		if c.DirectoryListing {
-			cacheKey := "IndexCache:=" + c.S3KeyPrefix + path
-			var item *ccache.Item[cachedResponse]
-			if httpCache != nil {
-				item = httpCache.Get(cacheKey)
+			if download := r.URL.Query().Get("download"); len(download) > 0 && c.DirListingArchive {
+				downloadArchive(w, r, client, c, path, strings.ToLower(download))
+				return
 			}
-			if item != nil && !item.Expired() {
-				val := item.Value()
-				if !val.Exists {
-					w.Header().Set("Content-Type", val.ContentType)
-					_, _ = w.Write(val.Body)
-					return
-				}
-			} else {
-				if !c.DirListingCheckIndex || !client.S3exists(r.Context(), c.S3Bucket, c.S3KeyPrefix+path+c.IndexDocument) {
-					obj, err := s3listFiles(r, client, c.S3Bucket, c.S3KeyPrefix+path)
-					if err != nil {
-						if obj.Exists {
-							http.Error(w, err.Error(), http.StatusInternalServerError)
-						} else {
-							code, message := toHTTPError(err)
-							http.Error(w, message, code)
-						}
-						return
-					}
-					obj.Exists = false
-					if httpCache != nil {
-						httpCache.Set(cacheKey, obj, c.CacheTTLIndex)
-					}
-					w.Header().Set("Content-Type", obj.ContentType)
-					_, _ = w.Write(obj.Body)
-					return
-				} else if httpCache != nil {
-					obj := cachedResponse{Exists: true}
-					httpCache.Set(cacheKey, obj, c.CacheTTLIndex)
-
-				}
+			if !hasIndexDocument(r, client, c, path) {
+				serveListing(w, r, client, c, path)
+				return
 			}
 		}
 		path += c.IndexDocument
 	}
 
+	// PRECOMPRESSED siblings, like app.js.br for app.js, to clients accepting their encoding
+	if len(c.Precompressed) > 0 && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		var headers http.Header
+		if path, headers = precompressedSibling(r, client, c, path); headers != nil {
+			w = withHeaders(w, headers)
+		}
+	}
+
 	switch r.Method {
 	case "GET":
 		// Get a S3 object
 		var obj *s3.GetObjectOutput
 		var err error
 
-		cacheKey := c.S3KeyPrefix + path
+		cacheKey := objectCacheKey(c.S3Bucket, c.S3KeyPrefix+path)
 		var item *ccache.Item[cachedResponse]
 		if httpCache != nil {
 			item = httpCache.Get(cacheKey)
@@ -134,10 +219,30 @@ func AwsS3(w http.ResponseWriter, r *http.Request) {
 
 		if item != nil && !item.Expired() {
 			val := item.Value()
-			obj = val.GetObjectOutput
+			cached := *val.GetObjectOutput
+			obj = &cached
+			if websiteRedirect(w, r, obj, c) {
+				return
+			}
+			if status := checkPreconditions(r, obj.ETag, obj.LastModified); status != 0 {
+				writePreconditionResult(w, r, obj, status, c)
+				return
+			}
+			if rangeHeader != nil && ifRangeMatches(r, obj.ETag, obj.LastModified) &&
+				serveRanges(w, r, obj, int64(len(val.Body)), *rangeHeader, func(ra httpRange) (io.ReadCloser, error) {
+					return io.NopCloser(bytes.NewReader(val.Body[ra.start : ra.start+ra.length])), nil
+				}, c) {
+				return
+			}
 			obj.Body = io.NopCloser(bytes.NewReader(val.Body))
 		} else {
-			obj, err = client.S3get(r.Context(), c.S3Bucket, c.S3KeyPrefix+path, rangeHeader)
+			if rangeHeader != nil && strings.Contains(*rangeHeader, ",") {
+				if serveS3Ranges(w, r, client, c.S3Bucket, c.S3KeyPrefix+path, *rangeHeader, cond, c) {
+					return
+				}
+				rangeHeader = nil
+			}
+			obj, err = client.S3get(r.Context(), c.S3Bucket, c.S3KeyPrefix+path, rangeHeader, cond)
 			metrics.UpdateS3Reads(err, metrics.GetObjectAction, metrics.ProxySource)
 			if err != nil {
 				code, message := toHTTPError(err)
@@ -146,19 +251,39 @@ func AwsS3(w http.ResponseWriter, r *http.Request) {
 					if idx > -1 {
 						indexPath := c.S3KeyPrefix + path[:idx+1] + c.IndexDocument
 						var indexError error
-						obj, indexError = client.S3get(r.Context(), c.S3Bucket, indexPath, rangeHeader)
+						obj, indexError = client.S3get(r.Context(), c.S3Bucket, indexPath, rangeHeader, cond)
 						if indexError != nil {
 							code, message = toHTTPError(indexError)
-							http.Error(w, message, code)
+							if code == http.StatusNotModified {
+								writeS3NotModified(w, indexError, c)
+								return
+							}
+							writeS3Error(w, r, client, c, path, code, message)
 							return
 						}
 					}
+				} else if code == http.StatusNotModified {
+					writeS3NotModified(w, err, c)
+					return
 				} else {
-					http.Error(w, message, code)
+					writeS3Error(w, r, client, c, path, code, message)
+					return
+				}
+			}
+			// S3 ignores If-Range, so fetch the whole object when the validator is stale
+			if err == nil && rangeHeader != nil && !ifRangeMatches(r, obj.ETag, obj.LastModified) {
+				obj.Body.Close()
+				rangeHeader = nil
+				obj, err = client.S3get(r.Context(), c.S3Bucket, c.S3KeyPrefix+path, nil, cond)
+				metrics.UpdateS3Reads(err, metrics.GetObjectAction, metrics.ProxySource)
+				if err != nil {
+					code, message := toHTTPError(err)
+					writeS3Error(w, r, client, c, path, code, message)
 					return
 				}
 			}
-			if httpCache != nil && err == nil && aws.ToInt64(obj.ContentLength) <= c.CacheMaxFileSize {
+			// Partial content is never cached, later ranges are cut from a cached full object
+			if httpCache != nil && err == nil && rangeHeader == nil && aws.ToInt64(obj.ContentLength) <= c.CacheMaxFileSize {
 				buf := new(bytes.Buffer)
 				_, err := io.Copy(buf, obj.Body)
 				if err == nil {
@@ -186,7 +311,10 @@ func AwsS3(w http.ResponseWriter, r *http.Request) {
 				}
 			}
 		}
-		setHeadersFromAwsResponse(w, obj, c.HTTPCacheControl, c.HTTPExpires)
+		if websiteRedirect(w, r, obj, c) {
+			return
+		}
+		setHeadersFromAwsResponse(w, obj, c)
 		w.WriteHeader(determineHTTPStatus(obj))
 		_, _ = io.Copy(w, obj.Body) // nolint
 	case "HEAD":
@@ -194,7 +322,7 @@ func AwsS3(w http.ResponseWriter, r *http.Request) {
 		var obj interface{}
 		var err error
 
-		cacheKey := c.S3KeyPrefix + path
+		cacheKey := objectCacheKey(c.S3Bucket, c.S3KeyPrefix+path)
 		var item *ccache.Item[cachedResponse]
 		if httpCache != nil {
 			item = httpCache.Get(cacheKey)
@@ -203,8 +331,15 @@ func AwsS3(w http.ResponseWriter, r *http.Request) {
 		if item != nil && !item.Expired() {
 			val := item.Value()
 			obj = val.GetObjectOutput
+			if websiteRedirect(w, r, obj, c) {
+				return
+			}
+			if status := checkPreconditions(r, val.ETag, val.LastModified); status != 0 {
+				writePreconditionResult(w, r, obj, status, c)
+				return
+			}
 		} else {
-			obj, err = client.S3head(r.Context(), c.S3Bucket, c.S3KeyPrefix+path, rangeHeader)
+			obj, err = client.S3head(r.Context(), c.S3Bucket, c.S3KeyPrefix+path, nil, cond)
 			// metrics.UpdateS3Reads(err, metrics.GetObjectAction, metrics.ProxySource)
 			if err != nil {
 				code, message := toHTTPError(err)
@@ -213,48 +348,119 @@ func AwsS3(w http.ResponseWriter, r *http.Request) {
 					if idx > -1 {
 						indexPath := c.S3KeyPrefix + path[:idx+1] + c.IndexDocument
 						var indexError error
-						obj, indexError = client.S3head(r.Context(), c.S3Bucket, indexPath, rangeHeader)
+						obj, indexError = client.S3head(r.Context(), c.S3Bucket, indexPath, nil, cond)
 						if indexError != nil {
 							code, message = toHTTPError(indexError)
-							http.Error(w, message, code)
+							if code == http.StatusNotModified {
+								writeS3NotModified(w, indexError, c)
+								return
+							}
+							writeS3Error(w, r, client, c, path, code, message)
 							return
 						}
 					}
+				} else if code == http.StatusNotModified {
+					writeS3NotModified(w, err, c)
+					return
 				} else {
-					http.Error(w, message, code)
+					writeS3Error(w, r, client, c, path, code, message)
 					return
 				}
 			}
 		}
-		setHeadersFromAwsResponse(w, obj, c.HTTPCacheControl, c.HTTPExpires)
+		if websiteRedirect(w, r, obj, c) {
+			return
+		}
+		// Answer with the headers a GET of the same range would produce
+		if rangeHeader != nil {
+			size, etag, lastModified := objectMeta(obj)
+			if ifRangeMatches(r, etag, lastModified) &&
+				serveRanges(w, r, obj, size, *rangeHeader, nil, c) {
+				return
+			}
+		}
+		setHeadersFromAwsResponse(w, obj, c)
 		w.WriteHeader(http.StatusOK)
 	default:
 		// return method not allowed, 405
-		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		w.Header().Set("Allow", allowedMethods(c))
+		httperr.Write(w, r, http.StatusMethodNotAllowed, "")
 		return
 	}
 }
 
-func replacePathWithSymlink(r *http.Request, client service.AWS, bucket, symlinkPath string) (*string, error) {
-	obj, err := client.S3get(r.Context(), bucket, symlinkPath, nil)
-	metrics.UpdateS3Reads(err, metrics.GetObjectAction, metrics.ProxySource)
+// hasIndexDocument reports if DIRECTORY_LISTINGS_CHECK_INDEX finds the INDEX_DOCUMENT of a directory,
+// which is served instead of its listing
+func hasIndexDocument(r *http.Request, client service.AWS, c *config.Settings, path string) bool {
+	if !c.DirListingCheckIndex {
+		return false
+	}
+	cacheKey := listingCacheKey(c.S3Bucket, c.S3KeyPrefix+path, "index")
+	if httpCache != nil {
+		if item := httpCache.Get(cacheKey); item != nil && !item.Expired() {
+			return item.Value().Exists
+		}
+	}
+	exists := client.S3exists(r.Context(), c.S3Bucket, c.S3KeyPrefix+path+c.IndexDocument)
+	if httpCache != nil {
+		httpCache.Set(cacheKey, cachedResponse{Exists: exists}, c.CacheTTLIndex)
+	}
+	return exists
+}
+
+// serveListing answers with the listing of a directory, checking its query parameters only now
+// as a directory served by its index document may be given any
+func serveListing(w http.ResponseWriter, r *http.Request, client service.AWS, c *config.Settings, path string) {
+	var ok bool
+	if c, ok = listingSettings(r, c); !ok {
+		httperr.WriteMessage(w, r, http.StatusBadRequest, "Unknown listing format")
+		return
+	}
+	pageQuery, err := listingPageQuery(r)
 	if err != nil {
-		return nil, err
+		httperr.WriteMessage(w, r, http.StatusBadRequest, err.Error())
+		return
 	}
-	link := struct {
-		URL string
-	}{}
-	buf := new(bytes.Buffer)
-	if _, err = buf.ReadFrom(obj.Body); err != nil {
-		return nil, err
+	lq, err := parseListingQuery(r)
+	if err != nil {
+		httperr.WriteMessage(w, r, http.StatusBadRequest, err.Error())
+		return
 	}
-	if err = json.Unmarshal(buf.Bytes(), &link); err != nil {
-		return nil, err
+	rq, err := parseRecursiveQuery(r, c)
+	if err != nil {
+		httperr.WriteMessage(w, r, http.StatusBadRequest, err.Error())
+		return
 	}
-	return aws.String(link.URL), nil
+	w.Header().Add("Vary", "Accept")
+	cacheKey := listingCacheKey(c.S3Bucket, c.S3KeyPrefix+path, listingVariant(c)+"&"+pageQuery+"&"+lq.String())
+	if httpCache != nil {
+		if item := httpCache.Get(cacheKey); item != nil && !item.Expired() {
+			writeListing(w, item.Value())
+			return
+		}
+	}
+	// Sorting needs every entry, so sorted listings are not streamed
+	if c.DirListingStream && listingPageOptions(r, "") == nil && rq == nil && len(lq.sort) == 0 {
+		streamListing(w, r, client, c, path)
+		return
+	}
+	obj, err := s3listFiles(r, client, c, path)
+	if err != nil {
+		if obj.Exists {
+			httperr.Write(w, r, http.StatusInternalServerError, err.Error())
+		} else {
+			code, message := toHTTPError(err)
+			writeS3Error(w, r, client, c, path, code, message)
+		}
+		return
+	}
+	if httpCache != nil {
+		httpCache.Set(cacheKey, obj, c.CacheTTLIndex)
+	}
+	writeListing(w, obj)
 }
 
-func setHeadersFromAwsResponse(w http.ResponseWriter, obj interface{}, httpCacheControl, httpExpires string) {
+func setHeadersFromAwsResponse(w http.ResponseWriter, obj interface{}, c *config.Settings) {
 	v := reflect.ValueOf(obj)
 	if v.Kind() == reflect.Ptr {
 		v = v.Elem()
@@ -284,14 +490,14 @@ func setHeadersFromAwsResponse(w http.ResponseWriter, obj interface{}, httpCache
 	}
 
 	// Cache-Control
-	if len(httpCacheControl) > 0 {
-		setStrHeader(w, "Cache-Control", &httpCacheControl)
+	if len(c.HTTPCacheControl) > 0 {
+		setStrHeader(w, "Cache-Control", &c.HTTPCacheControl)
 	} else {
 		setStrHeader(w, "Cache-Control", getString("CacheControl"))
 	}
 	// Expires
-	if len(httpExpires) > 0 {
-		setStrHeader(w, "Expires", &httpExpires)
+	if len(c.HTTPExpires) > 0 {
+		setStrHeader(w, "Expires", &c.HTTPExpires)
 	} else {
 		// Try ExpiresString first (if it exists in generic object), then Expires
 		if s := getString("ExpiresString"); s != nil {
@@ -305,19 +511,20 @@ func setHeadersFromAwsResponse(w http.ResponseWriter, obj interface{}, httpCache
 		setIntHeader(w, "Content-Length", getInt64("ContentLength"))
 	}
 	setStrHeader(w, "Content-Range", getString("ContentRange"))
+	setStrHeader(w, "Accept-Ranges", getString("AcceptRanges"))
 
 	contentType := getString("ContentType")
-	if config.Config.ContentType == "" {
+	if c.ContentType == "" {
 		setStrHeader(w, "Content-Type", contentType)
 	} else {
-		setStrHeader(w, "Content-Type", &config.Config.ContentType)
+		setStrHeader(w, "Content-Type", &c.ContentType)
 	}
 
 	contentDisposition := getString("ContentDisposition")
-	if config.Config.ContentDisposition == "" {
+	if c.ContentDisposition == "" {
 		setStrHeader(w, "Content-Disposition", contentDisposition)
 	} else {
-		setStrHeader(w, "Content-Disposition", &config.Config.ContentDisposition)
+		setStrHeader(w, "Content-Disposition", &c.ContentDisposition)
 	}
 	setStrHeader(w, "ETag", getString("ETag"))
 	setTimeHeader(w, "Last-Modified", getTime("LastModified"))
@@ -325,7 +532,7 @@ func setHeadersFromAwsResponse(w http.ResponseWriter, obj interface{}, httpCache
 	// Location, rewrite to our own
 	if len(w.Header().Get("Location")) > 0 {
 		l, err := url.Parse(w.Header().Get("Location"))
-		if err == nil && strings.Contains(l.Host, config.Config.S3Bucket) {
+		if err == nil && strings.Contains(l.Host, c.S3Bucket) {
 			path := l.RequestURI()
 			setStrHeader(w, "Location", &path)
 		}
@@ -350,48 +557,81 @@ func setTimeHeader(w http.ResponseWriter, key string, value *time.Time) {
 	}
 }
 
-func s3listFiles(r *http.Request, client service.AWS, bucket, prefix string) (cachedResponse, error) {
-	prefix = strings.TrimPrefix(prefix, "/")
+func s3listFiles(r *http.Request, client service.AWS, c *config.Settings, path string) (cachedResponse, error) {
+	prefix := strings.TrimPrefix(c.S3KeyPrefix+path, "/")
 
-	result, err := client.S3listObjects(r.Context(), bucket, prefix)
-	metrics.UpdateS3Reads(err, metrics.ListObjectAction, metrics.ProxySource)
+	var result *s3.ListObjectsV2Output
+	rq, err := parseRecursiveQuery(r, c)
+	if err != nil {
+		return cachedResponse{Exists: true}, err
+	}
+	switch opts := listingPageOptions(r, prefix); {
+	case rq != nil:
+		result, err = listRecursive(r, client, c, prefix, rq)
+	case opts != nil:
+		result, err = client.S3listPage(r.Context(), c.S3Bucket, prefix, opts)
+		metrics.UpdateS3Reads(err, metrics.ListObjectAction, metrics.ProxySource)
+	default:
+		result, err = client.S3listObjects(r.Context(), c.S3Bucket, prefix)
+		metrics.UpdateS3Reads(err, metrics.ListObjectAction, metrics.ProxySource)
+	}
 	if err != nil {
 		return cachedResponse{}, err
 	}
-	files := convertToMaps(result, prefix)
+	return renderListingResult(r, client, c, path, prefix, result)
+}
+
+// renderListingResult renders the listing of path in the format of the site, entries named after their key below prefix
+func renderListingResult(r *http.Request, client service.AWS, c *config.Settings, path, prefix string, result *s3.ListObjectsV2Output) (cachedResponse, error) {
+	lq, _ := parseListingQuery(r)
+	page := newListingPage(c, path, lq.apply(convertToMaps(c, result, prefix)))
+	setListingPages(r, &page, result)
+	lq.setSortLinks(r, &page)
 
 	// Output as a HTML
-	if strings.EqualFold(config.Config.DirListingFormat, "html") {
-		return cachedResponse{
-			Body:        []byte(toHTML(files)),
-			ContentType: "text/html; charset=utf-8",
-		}, nil
-	}
-	if strings.EqualFold(config.Config.DirListingFormat, "apache") {
-		return cachedResponse{
-			Body:        []byte(toApache(prefix, files)),
-			ContentType: "text/html; charset=utf-8",
-		}, nil
+	tmpl, err := listingTemplate(r, client, c)
+	if err != nil {
+		return cachedResponse{Exists: true}, err
 	}
-	if strings.EqualFold(config.Config.DirListingFormat, "shtml") {
+	if tmpl != nil {
+		body, err := renderListing(tmpl, page)
+		if err != nil {
+			return cachedResponse{Exists: true}, err
+		}
 		return cachedResponse{
-			Body:        []byte(toSimpleHTML(files)),
+			Body:        body,
 			ContentType: "text/html; charset=utf-8",
+			Link:        page.links(),
 		}, nil
 	}
 
-	// Output as a JSON
-	jsonBytes, merr := json.Marshal(files)
-	if merr != nil {
-		return cachedResponse{Exists: true}, merr
+	// Output as JSON, JSON lines or CSV
+	format := strings.ToLower(c.DirListingFormat)
+	body, err := renderListingData(format, page)
+	if err != nil {
+		return cachedResponse{Exists: true}, err
+	}
+	contentType, ok := listingContentTypes[format]
+	if !ok {
+		contentType = listingContentTypes["json"]
 	}
 	return cachedResponse{
-		Body:        jsonBytes,
-		ContentType: "application/json; charset=utf-8",
+		Body:        body,
+		ContentType: contentType,
+		Link:        page.links(),
 	}, nil
 }
 
-func convertToMaps(s3output *s3.ListObjectsV2Output, prefix string) s3objects {
+func convertToMaps(c *config.Settings, s3output *s3.ListObjectsV2Output, prefix string) s3objects {
+	candidates := toS3objects(s3output, prefix)
+	// Sort
+	sort.Sort(sortedObjects{candidates, c})
+
+	return candidates
+}
+
+// toS3objects returns the entries of a listing in the order of S3
+func toS3objects(s3output *s3.ListObjectsV2Output, prefix string) s3objects {
 	var candidates s3objects
 
 	// Prefixes
@@ -400,7 +640,7 @@ func convertToMaps(s3output *s3.ListObjectsV2Output, prefix string) s3objects {
 		if len(candidate) == 0 {
 			continue
 		}
-		candidates = append(candidates, s3item{candidate, -1, time.Time{}})
+		candidates = append(candidates, s3item{file: candidate, size: -1})
 	}
 	// Contents
 	for _, obj := range s3output.Contents {
@@ -408,64 +648,8 @@ func convertToMaps(s3output *s3.ListObjectsV2Output, prefix string) s3objects {
 		if len(candidate) == 0 {
 			continue
 		}
-		candidates = append(candidates, s3item{candidate, *obj.Size, *obj.LastModified})
+		candidates = append(candidates, s3item{file: candidate, size: aws.ToInt64(obj.Size), updatedAt: aws.ToTime(obj.LastModified),
+			etag: aws.ToString(obj.ETag), storageClass: string(obj.StorageClass)})
 	}
-	// Sort
-	sort.Sort(candidates)
-
 	return candidates
 }
-
-func toHTML(files s3objects) string {
-	html := "<!DOCTYPE html><html><head><meta name=\"viewport\" content=\"width=device-width, initial-scale=1\"></head><body><ul>"
-	for _, file := range files {
-		html += "<li><a href=\"" + file.file + "\">" + file.file + "</a>"
-		if !file.updatedAt.IsZero() {
-			html += " " + file.updatedAt.Format(time.RFC3339)
-		}
-		html += "</li>"
-	}
-	return html + "</ul></body></html>"
-}
-
-func toApache(prefix string, files s3objects) string {
-	html := "<!DOCTYPE html><html><head><meta name=\"viewport\" content=\"width=device-width, initial-scale=1\"><title>Index of " + prefix + "</title></head>"
-	html += "<body><h1>Index of " + prefix + "</h1><pre><table><tr><th>Name</th><th>Last Modified</th><th>Size</th></tr>"
-	for _, file := range files {
-		html += "<tr><td><a href=\"" + file.file + "\">" + file.file + "</a></td>"
-		if !file.updatedAt.IsZero() {
-			html += "<td>" + file.updatedAt.Format(time.RFC3339) + "</td>"
-		} else {
-			html += "<td>-</td>"
-		}
-		if file.size >= 0 {
-			fsizeMod := ""
-			if file.size > 2000 {
-				fsizeMod = "k"
-				file.size /= 1024
-			}
-			if file.size > 2000 {
-				fsizeMod = "M"
-				file.size /= 1024
-			}
-			if file.size > 2000 {
-				fsizeMod = "G"
-				file.size /= 1024
-			}
-			html += "<td>" + strconv.FormatInt(file.size, 10) + fsizeMod + "</td>"
-		} else {
-			html += "<td>-</td>"
-		}
-		html += "</tr>"
-	}
-	return html + "</table><hr></pre></body></html>"
-}
-
-func toSimpleHTML(files s3objects) string {
-
-	html := "<!DOCTYPE html><html><body>"
-	for _, file := range files {
-		html += "<a href=\"" + file.file + "\">" + file.file + "</a><br>"
-	}
-	return html + "</body></html>"
-}
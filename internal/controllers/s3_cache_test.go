@@ -12,6 +12,7 @@ import (
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/patrickdk77/aws-s3-proxy/internal/config"
 	"github.com/patrickdk77/aws-s3-proxy/internal/service"
 	"github.com/stretchr/testify/assert"
@@ -23,16 +24,16 @@ type MockAWS struct {
 	mock.Mock
 }
 
-func (m *MockAWS) S3get(ctx context.Context, bucket, key string, rangeHeader *string) (*s3.GetObjectOutput, error) {
-	args := m.Called(ctx, bucket, key, rangeHeader)
+func (m *MockAWS) S3get(ctx context.Context, bucket, key string, rangeHeader *string, cond *service.Conditions) (*s3.GetObjectOutput, error) {
+	args := m.Called(ctx, bucket, key, rangeHeader, cond)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
 	return args.Get(0).(*s3.GetObjectOutput), args.Error(1)
 }
 
-func (m *MockAWS) S3head(ctx context.Context, bucket, key string, rangeHeader *string) (*s3.HeadObjectOutput, error) {
-	args := m.Called(ctx, bucket, key, rangeHeader)
+func (m *MockAWS) S3head(ctx context.Context, bucket, key string, rangeHeader *string, cond *service.Conditions) (*s3.HeadObjectOutput, error) {
+	args := m.Called(ctx, bucket, key, rangeHeader, cond)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
@@ -52,6 +53,55 @@ func (m *MockAWS) S3listObjects(ctx context.Context, bucket, prefix string) (*s3
 	return args.Get(0).(*s3.ListObjectsV2Output), args.Error(1)
 }
 
+func (m *MockAWS) S3listPage(ctx context.Context, bucket, prefix string, opts *service.ListOptions) (*s3.ListObjectsV2Output, error) {
+	args := m.Called(ctx, bucket, prefix, opts)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*s3.ListObjectsV2Output), args.Error(1)
+}
+
+func (m *MockAWS) S3put(ctx context.Context, bucket, key string, body io.Reader, opts *service.PutOptions) (*string, error) {
+	args := m.Called(ctx, bucket, key, body, opts)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*string), args.Error(1)
+}
+
+func (m *MockAWS) S3delete(ctx context.Context, bucket, key string) error {
+	args := m.Called(ctx, bucket, key)
+	return args.Error(0)
+}
+
+// setupAWS serves the requests of a test from client, with an empty cache, the bucket "bucket" and CACHE_SIZE off.
+// Settings, the client and the caches are restored once the test ends.
+func setupAWS(t *testing.T, client service.AWS) {
+	t.Helper()
+	settings, newClient := *config.Config, NewClientFunc
+	resetCaches := func() {
+		httpCache = nil
+		cacheOnce = *new(sync.Once)
+		manifestCache.Clear()
+		listingTemplates.Clear()
+		zipIndexes.Clear()
+		precompressedCache.Clear()
+	}
+	t.Cleanup(func() {
+		*config.Config = settings
+		NewClientFunc = newClient
+		resetCaches()
+	})
+	config.Config.AwsRegion = "us-east-1"
+	config.Config.S3Bucket = "bucket"
+	config.Config.S3KeyPrefix = ""
+	config.Config.CacheSize = 0
+	NewClientFunc = func(ctx context.Context, region *string) service.AWS {
+		return client
+	}
+	resetCaches()
+}
+
 func TestAwsS3_Caching(t *testing.T) {
 	// Setup
 	config.Config.AwsRegion = "us-east-1"
@@ -70,7 +120,7 @@ func TestAwsS3_Caching(t *testing.T) {
 	cacheOnce = *new(sync.Once)
 
 	// Test Case 1: Cache Miss
-	mockAWS.On("S3get", mock.Anything, "bucket", "/file.txt", (*string)(nil)).Return(&s3.GetObjectOutput{
+	mockAWS.On("S3get", mock.Anything, "bucket", "/file.txt", (*string)(nil), (*service.Conditions)(nil)).Return(&s3.GetObjectOutput{
 		Body:          io.NopCloser(bytes.NewBufferString("content")),
 		ContentLength: aws.Int64(7),
 		ContentType:   aws.String("text/plain"),
@@ -115,7 +165,7 @@ func TestAwsS3_CacheMaxAge(t *testing.T) {
 	cacheOnce = *new(sync.Once)
 
 	// Response with Cache-Control: max-age=1
-	mockAWS.On("S3get", mock.Anything, "bucket", "/max-age.txt", (*string)(nil)).Return(&s3.GetObjectOutput{
+	mockAWS.On("S3get", mock.Anything, "bucket", "/max-age.txt", (*string)(nil), (*service.Conditions)(nil)).Return(&s3.GetObjectOutput{
 		Body:          io.NopCloser(bytes.NewBufferString("expires quickly")),
 		ContentLength: aws.Int64(15),
 		ContentType:   aws.String("text/plain"),
@@ -140,7 +190,7 @@ func TestAwsS3_CacheMaxAge(t *testing.T) {
 	time.Sleep(1100 * time.Millisecond)
 
 	// 4. Request after expiry (Cache Miss again)
-	mockAWS.On("S3get", mock.Anything, "bucket", "/max-age.txt", (*string)(nil)).Return(&s3.GetObjectOutput{
+	mockAWS.On("S3get", mock.Anything, "bucket", "/max-age.txt", (*string)(nil), (*service.Conditions)(nil)).Return(&s3.GetObjectOutput{
 		Body:          io.NopCloser(bytes.NewBufferString("new content")),
 		ContentLength: aws.Int64(11),
 		ContentType:   aws.String("text/plain"),
@@ -173,7 +223,7 @@ func TestAwsS3_CacheHEAD(t *testing.T) {
 	cacheOnce = *new(sync.Once)
 
 	// 1. Initial GET Request (Cache Miss)
-	mockAWS.On("S3get", mock.Anything, "bucket", "/head-cache.txt", (*string)(nil)).Return(&s3.GetObjectOutput{
+	mockAWS.On("S3get", mock.Anything, "bucket", "/head-cache.txt", (*string)(nil), (*service.Conditions)(nil)).Return(&s3.GetObjectOutput{
 		Body:          io.NopCloser(bytes.NewBufferString("head content")),
 		ContentLength: aws.Int64(12),
 		ContentType:   aws.String("text/plain"),
@@ -220,7 +270,7 @@ func TestAwsS3_CacheMaxFileSize(t *testing.T) {
 	largeSize := int64(10*1024*1024 + 1)
 
 	// 1. Initial Request (Should not cache)
-	mockAWS.On("S3get", mock.Anything, "bucket", "/large.txt", (*string)(nil)).Return(&s3.GetObjectOutput{
+	mockAWS.On("S3get", mock.Anything, "bucket", "/large.txt", (*string)(nil), (*service.Conditions)(nil)).Return(&s3.GetObjectOutput{
 		Body:          io.NopCloser(bytes.NewReader([]byte("small body"))),
 		ContentLength: aws.Int64(largeSize),
 		ContentType:   aws.String("text/plain"),
@@ -232,7 +282,7 @@ func TestAwsS3_CacheMaxFileSize(t *testing.T) {
 	assert.Equal(t, http.StatusOK, rr.Code)
 
 	// 2. Second Request (Should trigger S3get again because it wasn't cached)
-	mockAWS.On("S3get", mock.Anything, "bucket", "/large.txt", (*string)(nil)).Return(&s3.GetObjectOutput{
+	mockAWS.On("S3get", mock.Anything, "bucket", "/large.txt", (*string)(nil), (*service.Conditions)(nil)).Return(&s3.GetObjectOutput{
 		Body:          io.NopCloser(bytes.NewReader([]byte("small body"))),
 		ContentLength: aws.Int64(largeSize),
 		ContentType:   aws.String("text/plain"),
@@ -245,3 +295,69 @@ func TestAwsS3_CacheMaxFileSize(t *testing.T) {
 
 	mockAWS.AssertExpectations(t)
 }
+
+func TestAwsS3_CachePerRoute(t *testing.T) {
+	mockAWS := new(MockAWS)
+	setupAWS(t, mockAWS)
+	config.Config.CacheSize = 10 * 1024 * 1024
+	config.Config.CacheTTL = 1 * time.Minute
+	config.Config.CacheMaxFileSize = 1 * 1024 * 1024
+	config.Config.Routes = []*config.Route{{Host: "*.example.com", S3Bucket: "site-{subdomain}"}}
+
+	for _, bucket := range []string{"site-a", "site-b"} {
+		mockAWS.On("S3get", mock.Anything, bucket, "/index.txt", (*string)(nil), (*service.Conditions)(nil)).Return(&s3.GetObjectOutput{
+			Body:          io.NopCloser(bytes.NewBufferString(bucket)),
+			ContentLength: aws.Int64(int64(len(bucket))),
+		}, nil).Once()
+	}
+
+	// Same path on two hosts, each served and cached from its own bucket
+	for i := 0; i < 2; i++ {
+		for _, host := range []string{"a", "b"} {
+			req, _ := http.NewRequest("GET", "http://"+host+".example.com/index.txt", nil)
+			rr := httptest.NewRecorder()
+			AwsS3(rr, req)
+			assert.Equal(t, http.StatusOK, rr.Code)
+			assert.Equal(t, "site-"+host, rr.Body.String())
+		}
+	}
+	mockAWS.AssertExpectations(t)
+}
+
+func TestAwsS3_MountedListings(t *testing.T) {
+	mockAWS := new(MockAWS)
+	setupAWS(t, mockAWS)
+	config.Config.CacheSize = 10 * 1024 * 1024
+	config.Config.CacheTTL = 1 * time.Minute
+	config.Config.CacheTTLIndex = 1 * time.Minute
+	config.Config.DirectoryListing = true
+	config.Config.Routes = []*config.Route{
+		{Path: "/docs/", S3Bucket: "docs-bucket", S3KeyPrefix: "/site", DirListingFormat: "shtml"},
+		{Path: "/mirror/", S3Bucket: "docs-bucket", S3KeyPrefix: "/site"},
+	}
+
+	mockAWS.On("S3listObjects", mock.Anything, "docs-bucket", "site/").Return(&s3.ListObjectsV2Output{
+		Contents: []types.Object{{Key: aws.String("site/a.html"), Size: aws.Int64(1), LastModified: aws.Time(time.Unix(0, 0))}},
+	}, nil).Twice()
+
+	req, _ := http.NewRequest("GET", "/docs", nil)
+	rr := httptest.NewRecorder()
+	AwsS3(rr, req)
+	assert.Equal(t, http.StatusMovedPermanently, rr.Code)
+	assert.Equal(t, "/docs/", rr.Header().Get("Location"))
+
+	// Both mounts list the same keys, each in its own format
+	for i := 0; i < 2; i++ {
+		req, _ = http.NewRequest("GET", "/docs/", nil)
+		rr = httptest.NewRecorder()
+		AwsS3(rr, req)
+		assert.Equal(t, "text/html; charset=utf-8", rr.Header().Get("Content-Type"))
+		assert.Contains(t, rr.Body.String(), `<a href="a.html">`)
+
+		req, _ = http.NewRequest("GET", "/mirror/", nil)
+		rr = httptest.NewRecorder()
+		AwsS3(rr, req)
+		assert.Equal(t, "application/json; charset=utf-8", rr.Header().Get("Content-Type"))
+	}
+	mockAWS.AssertExpectations(t)
+}
@@ -0,0 +1,64 @@
+// Package glob matches request paths against the patterns of header rules
+package glob
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Glob is a compiled pattern. * matches any characters, / included, ? a single character
+// other than / and {a,b} one of the alternatives. Patterns without a / match the file name.
+type Glob struct {
+	pattern  string
+	re       *regexp.Regexp
+	fileName bool
+}
+
+// Compile parses a pattern
+func Compile(pattern string) (*Glob, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	inBraces := false
+	for _, c := range pattern {
+		switch {
+		case c == '*':
+			b.WriteString(".*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '{' && !inBraces:
+			inBraces = true
+			b.WriteString("(?:")
+		case c == '}' && inBraces:
+			inBraces = false
+			b.WriteString(")")
+		case c == ',' && inBraces:
+			b.WriteString("|")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	if inBraces {
+		return nil, fmt.Errorf("unclosed { in '%s'", pattern)
+	}
+	b.WriteString("$")
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern '%s': %w", pattern, err)
+	}
+	return &Glob{pattern: pattern, re: re, fileName: !strings.Contains(pattern, "/")}, nil
+}
+
+// Match reports if p matches the pattern
+func (g *Glob) Match(p string) bool {
+	if g.fileName {
+		p = path.Base(p)
+	}
+	return g.re.MatchString(p)
+}
+
+// String returns the pattern
+func (g *Glob) String() string {
+	return g.pattern
+}
@@ -0,0 +1,38 @@
+package glob
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		match   bool
+	}{
+		{"*.html", "/index.html", true},
+		{"*.html", "/docs/guide/index.html", true},
+		{"*.html", "/index.htm", false},
+		{"/assets/*", "/assets/app.js", true},
+		{"/assets/*", "/assets/img/logo.png", true},
+		{"/assets/*", "/static/assets/app.js", false},
+		{"*.{js,css}", "/app.css", true},
+		{"*.{js,css}", "/app.json", false},
+		{"/docs/v?/*", "/docs/v2/index.html", true},
+		{"/docs/v?/*", "/docs/v10/index.html", false},
+		{"report.pdf", "/files/report.pdf", true},
+		{"*", "/", true},
+	}
+	for _, test := range tests {
+		g, err := Compile(test.pattern)
+		assert.NoError(t, err)
+		assert.Equal(t, test.match, g.Match(test.path), "%s %s", test.pattern, test.path)
+	}
+}
+
+func TestCompileError(t *testing.T) {
+	_, err := Compile("*.{js,css")
+	assert.Error(t, err)
+}
@@ -0,0 +1,164 @@
+package http
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zlib"
+	"github.com/klauspost/compress/zstd"
+	"github.com/patrickdk77/aws-s3-proxy/internal/config"
+)
+
+// compressor writes a content coding, reset to reuse it for another response
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// encoder creates the compressors of a content coding and pools them by level
+type encoder struct {
+	level int // unless COMPRESSION_LEVELS sets one
+	new   func(level int) (compressor, error)
+	pools sync.Map
+}
+
+// encoders are the content codings COMPRESSION_ENCODINGS may list
+var encoders = map[string]*encoder{
+	// The default of brotli, 6, is slow for bodies compressed on the fly
+	"br": {level: 4, new: func(level int) (compressor, error) {
+		return brotli.NewWriterLevel(nil, level), nil
+	}},
+	"zstd": {level: 3, new: func(level int) (compressor, error) {
+		zw, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)), zstd.WithEncoderConcurrency(1))
+		return zw, err
+	}},
+	"gzip": {level: gzip.DefaultCompression, new: func(level int) (compressor, error) {
+		zw, err := gzip.NewWriterLevel(nil, level)
+		return zw, err
+	}},
+	"deflate": {level: zlib.DefaultCompression, new: func(level int) (compressor, error) {
+		zw, err := zlib.NewWriterLevel(nil, level)
+		return zw, err
+	}},
+}
+
+func (e *encoder) pool(level int) *sync.Pool {
+	pool, _ := e.pools.LoadOrStore(level, &sync.Pool{})
+	return pool.(*sync.Pool)
+}
+
+// get returns a compressor writing to w
+func (e *encoder) get(w io.Writer, level int) (compressor, error) {
+	zw, ok := e.pool(level).Get().(compressor)
+	if !ok {
+		var err error
+		if zw, err = e.new(level); err != nil {
+			return nil, err
+		}
+	}
+	zw.Reset(w)
+	return zw, nil
+}
+
+// put returns a closed compressor to its pool
+func (e *encoder) put(zw compressor, level int) {
+	e.pool(level).Put(zw)
+}
+
+// compression is how CONTENT_ENCODING applies to the response of a request
+type compression struct {
+	encoding  string // negotiated with Accept-Encoding, empty when none is accepted
+	level     int
+	minSize   int64
+	types     []string
+	skipTypes []string
+	head      bool // HEAD responses only get the headers
+}
+
+// newCompression returns the compression of a request, or nil when CONTENT_ENCODING is off
+func newCompression(r *http.Request, c *config.Settings) *compression {
+	if !c.ContentEncoding || len(c.CompressionEncodings) == 0 {
+		return nil
+	}
+	p := &compression{
+		encoding:  negotiateEncoding(r, c.CompressionEncodings),
+		minSize:   c.CompressionMinSize,
+		types:     c.CompressionTypes,
+		skipTypes: c.CompressionSkipTypes,
+		head:      r.Method == http.MethodHead,
+	}
+	if e, found := encoders[p.encoding]; found {
+		p.level = e.level
+	}
+	if level, found := c.CompressionLevels[p.encoding]; found {
+		p.level = level
+	}
+	return p
+}
+
+// compressible reports if bodies of a Content-Type are compressed, matching COMPRESSION_TYPES but not COMPRESSION_SKIP_TYPES
+func (p *compression) compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return matchMediaType(p.types, mediaType) && !matchMediaType(p.skipTypes, mediaType)
+}
+
+// matchMediaType reports if a media type matches one of patterns, like text/* or application/*+json
+func matchMediaType(patterns []string, mediaType string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, mediaType); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateEncoding returns the encoding Accept-Encoding gives the highest q-value,
+// the first of encodings between equals, or an empty string when it accepts none
+func negotiateEncoding(r *http.Request, encodings []string) string {
+	value, found := header(r, "Accept-Encoding")
+	if !found {
+		return ""
+	}
+	accepted := acceptedEncodings(value)
+	best, bestQ := "", 0.0
+	for _, encoding := range encodings {
+		q, listed := accepted[encoding]
+		if !listed {
+			q = accepted["*"]
+		}
+		if q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+// acceptedEncodings returns the q-value of each content coding of Accept-Encoding
+func acceptedEncodings(value string) map[string]float64 {
+	accepted := map[string]float64{}
+	for _, coding := range splitCsvLine(value) {
+		name, params, _ := strings.Cut(coding, ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if name = strings.ToLower(strings.TrimSpace(name)); len(name) > 0 {
+			accepted[name] = q
+		}
+	}
+	return accepted
+}
@@ -0,0 +1,80 @@
+package http
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/patrickdk77/aws-s3-proxy/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	encodings := []string{"br", "zstd", "gzip", "deflate"}
+	for header, expected := range map[string]string{
+		"":                         "",
+		"identity":                 "",
+		"gzip, deflate":            "gzip",
+		"deflate, gzip, br":        "br",
+		"GZIP, zstd":               "zstd",
+		"br;q=0.5, gzip":           "gzip",
+		"br;q=0, gzip;q=0":         "",
+		"*":                        "br",
+		"*;q=0.2, deflate;q=0.5":   "deflate",
+		"br;q=0, *":                "zstd",
+		"gzip;q=bad, deflate;q=.1": "deflate",
+	} {
+		req, _ := http.NewRequest("GET", "/", nil)
+		if len(header) > 0 {
+			req.Header.Set("Accept-Encoding", header)
+		}
+		assert.Equal(t, expected, negotiateEncoding(req, encodings), header)
+	}
+
+	// Only COMPRESSION_ENCODINGS, in its order between equals
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "br, gzip, deflate")
+	assert.Equal(t, "deflate", negotiateEncoding(req, []string{"deflate", "gzip"}))
+}
+
+func TestCompressible(t *testing.T) {
+	p := &compression{
+		types:     []string{"text/*", "application/json", "application/*+json", "image/svg+xml"},
+		skipTypes: []string{"text/event-stream"},
+	}
+	for contentType, expected := range map[string]bool{
+		"text/html; charset=utf-8":    true,
+		"Application/JSON":            true,
+		"application/ld+json":         true,
+		"image/svg+xml":               true,
+		"text/event-stream":           false,
+		"image/jpeg":                  false,
+		"application/zip":             false,
+		"video/mp4":                   false,
+		"":                            false,
+		"application/vnd.x/evil+json": false,
+	} {
+		assert.Equal(t, expected, p.compressible(contentType), contentType)
+	}
+}
+
+func TestNewCompression(t *testing.T) {
+	c := &config.Settings{
+		ContentEncoding:      true,
+		CompressionEncodings: []string{"br", "gzip"},
+		CompressionLevels:    map[string]int{"gzip": 9},
+		CompressionMinSize:   512,
+	}
+	req, _ := http.NewRequest("HEAD", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip, br")
+	p := newCompression(req, c)
+	assert.Equal(t, "br", p.encoding)
+	assert.Equal(t, 4, p.level)
+	assert.Equal(t, int64(512), p.minSize)
+	assert.True(t, p.head)
+
+	req.Header.Set("Accept-Encoding", "gzip")
+	assert.Equal(t, 9, newCompression(req, c).level)
+
+	c.ContentEncoding = false
+	assert.Nil(t, newCompression(req, c))
+}
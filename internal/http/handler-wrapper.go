@@ -1,9 +1,6 @@
 package http
 
 import (
-	"compress/gzip"
-	"compress/zlib"
-	"io"
 	"net"
 	"net/http"
 	"strconv"
@@ -12,6 +9,7 @@ import (
 
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/patrickdk77/aws-s3-proxy/internal/config"
+	"github.com/patrickdk77/aws-s3-proxy/internal/httperr"
 )
 
 type ReqInfo struct {
@@ -26,13 +24,17 @@ type ReqInfo struct {
 	referer   string
 	userAgent string
 	user      string
+	writer    string // the user of basic auth or of a verified JWT, trusted to write unlike USERNAME_HEADER
 	host      string
 }
 
 // WrapHandler wraps every handlers
 func WrapHandler(handler func(w http.ResponseWriter, r *http.Request)) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		c := config.Config
+		// Settings of the route matching the Host header
+		c := config.Config.ForRequest(r)
+		r = r.WithContext(config.NewContext(r.Context(), c))
+		r = httperr.WithRequestID(w, r)
 
 		addr := getIP(r)
 		rawIP, rawPort, _ := net.SplitHostPort(r.RemoteAddr)
@@ -74,7 +76,7 @@ func WrapHandler(handler func(w http.ResponseWriter, r *http.Request)) http.Hand
 				}
 			}
 			if !found {
-				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				httperr.Write(w, r, http.StatusUnauthorized, "")
 				ri.status = http.StatusUnauthorized
 				accessLog(ri)
 				return
@@ -98,7 +100,7 @@ func WrapHandler(handler func(w http.ResponseWriter, r *http.Request)) http.Hand
 		if (len(c.BasicAuthUser) > 0) && (len(c.BasicAuthPass) > 0) &&
 			!auth(r, c.BasicAuthUser, c.BasicAuthPass, ri) {
 			w.Header().Set("WWW-Authenticate", `Basic realm="REALM"`)
-			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			httperr.Write(w, r, http.StatusUnauthorized, "")
 			ri.status = http.StatusUnauthorized
 			accessLog(ri)
 			return
@@ -106,33 +108,32 @@ func WrapHandler(handler func(w http.ResponseWriter, r *http.Request)) http.Hand
 		// Auth with JWT
 		if (len(c.JwtUserField) > 0 || len(c.JwtSecretKey) > 0) && !isValidJwt(r, ri) {
 			w.Header().Set("WWW-Authenticate", `Basic realm="REALM"`)
-			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			httperr.Write(w, r, http.StatusUnauthorized, "")
 			ri.status = http.StatusUnauthorized
 			accessLog(ri)
 			return
 		}
-		// Content-Encoding
-		ioWriter := w.(io.Writer)
-		if encodings, found := header(r, "Accept-Encoding"); found && c.ContentEncoding {
-			for _, encoding := range splitCsvLine(encodings) {
-				if encoding == "gzip" {
-					w.Header().Set("Content-Encoding", "gzip")
-					g := gzip.NewWriter(w)
-					defer g.Close()
-					ioWriter = g
-					break
-				}
-				if encoding == "deflate" {
-					w.Header().Set("Content-Encoding", "deflate")
-					z := zlib.NewWriter(w)
-					defer z.Close()
-					ioWriter = z
-					break
-				}
-			}
+		// Uploads and deletes need a user allowed to write
+		if c.WriteEnabled && isWriteMethod(r.Method) && !canWrite(ri.writer, c.WriteUsers) {
+			httperr.Write(w, r, http.StatusForbidden, "")
+			ri.status = http.StatusForbidden
+			accessLog(ri)
+			return
 		}
+		// Content-Encoding, negotiated now and applied once the response starts
+		writer := &custom{Writer: w, ResponseWriter: w, status: http.StatusOK, compression: newCompression(r, c)}
+		defer writer.Close()
+		// A handler dropping a response it started, like a failing stream, is still logged
+		defer func() {
+			if err := recover(); err != nil {
+				writer.aborted = true
+				ri.status = writer.status
+				ri.size = writer.Written
+				accessLog(ri)
+				panic(err)
+			}
+		}()
 		// Handle HTTP requests
-		writer := &custom{Writer: ioWriter, ResponseWriter: w, status: http.StatusOK}
 		handler(writer, r)
 
 		ri.status = writer.status
@@ -162,6 +163,7 @@ func auth(r *http.Request, authUser, authPass []string, ri *ReqInfo) bool {
 		for i := 0; i < len(authUser); i++ {
 			if username == authUser[i] && password == authPass[i] {
 				ri.user = authUser[i]
+				ri.writer = authUser[i]
 				return true
 			}
 		}
@@ -169,6 +171,27 @@ func auth(r *http.Request, authUser, authPass []string, ri *ReqInfo) bool {
 	return false
 }
 
+func isWriteMethod(method string) bool {
+	return method == http.MethodPut || method == http.MethodDelete || method == http.MethodPost
+}
+
+// canWrite reports if the user of basic auth or of a verified JWT may upload and delete objects,
+// every one of them may when no WRITE_USERS are configured
+func canWrite(user string, writeUsers []string) bool {
+	if user == "-" || user == "" {
+		return false
+	}
+	if len(writeUsers) == 0 {
+		return true
+	}
+	for _, writeUser := range writeUsers {
+		if user == writeUser {
+			return true
+		}
+	}
+	return false
+}
+
 func header(r *http.Request, key string) (string, bool) {
 	if r.Header == nil {
 		return "", false
@@ -189,10 +212,11 @@ func splitCsvLine(data string) []string {
 }
 
 func isValidJwt(r *http.Request, ri *ReqInfo) bool {
-	value := len(config.Config.JwtSecretKey) == 0
+	c := config.FromRequest(r)
+	value := len(c.JwtSecretKey) == 0
 	reqToken := r.Header.Get("Authorization")
-	if len(config.Config.JwtHeader) > 0 {
-		reqToken = r.Header.Get(config.Config.JwtHeader)
+	if len(c.JwtHeader) > 0 {
+		reqToken = r.Header.Get(c.JwtHeader)
 	} else {
 		splitToken := strings.Split(reqToken, "Bearer")
 		if len(splitToken) != 2 {
@@ -205,13 +229,17 @@ func isValidJwt(r *http.Request, ri *ReqInfo) bool {
 		return value
 	}
 	token, err := jwt.Parse(reqToken, func(t *jwt.Token) (interface{}, error) {
-		secretKey := config.Config.JwtSecretKey
+		secretKey := c.JwtSecretKey
 		return []byte(secretKey), nil
 	})
 	claims := token.Claims.(jwt.MapClaims)
-	if len(config.Config.JwtUserField) > 0 {
-		if user, ok := claims[config.Config.JwtUserField].(string); ok {
+	if len(c.JwtUserField) > 0 {
+		if user, ok := claims[c.JwtUserField].(string); ok {
 			ri.user = user
+			// Only a token checked against JWT_SECRET_KEY names who writes
+			if !value && err == nil && token.Valid {
+				ri.writer = user
+			}
 		}
 	}
 	if value {
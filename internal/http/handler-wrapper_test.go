@@ -1,8 +1,10 @@
 package http
 
 import (
+	"bytes"
 	"encoding/base64"
 	"fmt"
+	"log"
 	"net"
 	"net/http"
 	"net/http/httptest"
@@ -133,3 +135,83 @@ func TestTrimmedSplitCsvLine(t *testing.T) {
 	assert.Equal(t, "2", lines[1])
 	assert.Equal(t, "3", lines[2])
 }
+
+func TestCanWrite(t *testing.T) {
+	assert.False(t, canWrite("-", []string{}))
+	assert.True(t, canWrite("user", []string{}))
+	assert.True(t, canWrite("user", []string{"admin", "user"}))
+	assert.False(t, canWrite("guest", []string{"admin", "user"}))
+}
+
+func TestWriteIdentity(t *testing.T) {
+	saved := *config.Config
+	defer func() { *config.Config = saved }()
+	config.Config.WriteEnabled = true
+	config.Config.UsernameHeader = "X-User"
+	config.Config.JwtUserField = "sub"
+	config.Config.JwtSecretKey = ""
+	handler := WrapHandler(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	put := func(header, value string) int {
+		req := httptest.NewRequest(http.MethodPut, sample, nil)
+		req.Header.Set(header, value)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "admin"}).SignedString([]byte("secret"))
+
+	// Neither basic auth nor JWT_SECRET_KEY, nobody writes
+	assert.Equal(t, http.StatusForbidden, put("X-User", "admin"))
+	assert.Equal(t, http.StatusForbidden, put("Authorization", "Bearer "+token))
+
+	config.Config.JwtSecretKey = "secret"
+	assert.Equal(t, http.StatusNoContent, put("Authorization", "Bearer "+token))
+	forged, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "admin"}).SignedString([]byte("guess"))
+	assert.Equal(t, http.StatusUnauthorized, put("Authorization", "Bearer "+forged))
+
+	config.Config.JwtUserField = ""
+	config.Config.JwtSecretKey = ""
+	config.Config.BasicAuthUser = []string{"user"}
+	config.Config.BasicAuthPass = []string{"pass"}
+	assert.Equal(t, http.StatusNoContent, put("Authorization", "Basic "+basicAuth("user", "pass")))
+}
+
+func TestIsWriteMethod(t *testing.T) {
+	assert.True(t, isWriteMethod(http.MethodPut))
+	assert.True(t, isWriteMethod(http.MethodDelete))
+	assert.True(t, isWriteMethod(http.MethodPost))
+	assert.False(t, isWriteMethod(http.MethodGet))
+}
+
+func TestAbortedResponse(t *testing.T) {
+	saved, savedLog := *config.Config, config.AccessLog
+	defer func() { *config.Config, config.AccessLog = saved, savedLog }()
+	var logged bytes.Buffer
+	config.Config.AccessLog = true
+	config.AccessLog = log.New(&logged, "", 0)
+	config.Config.BasicAuthUser, config.Config.JwtUserField, config.Config.JwtSecretKey = nil, "", ""
+	config.Config.ContentEncoding = true
+	config.Config.CompressionEncodings = []string{"gzip"}
+	config.Config.CompressionTypes = []string{"text/*"}
+	config.Config.CompressionMinSize = 0
+
+	var flushed int
+	rr := httptest.NewRecorder()
+	handler := WrapHandler(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte("first page"))
+		w.(http.Flusher).Flush()
+		flushed = rr.Body.Len()
+		panic(http.ErrAbortHandler)
+	})
+	req := httptest.NewRequest(http.MethodGet, sample, nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() { handler.ServeHTTP(rr, req) })
+	assert.Equal(t, "gzip", rr.Header().Get("Content-Encoding"))
+	// No gzip trailer makes the body pass for complete
+	assert.Equal(t, flushed, rr.Body.Len())
+	assert.Contains(t, logged.String(), `"GET http://example.com/foo HTTP/1.1" 200 10`)
+}
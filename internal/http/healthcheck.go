@@ -3,6 +3,7 @@ package http
 import (
 	"context"
 	"encoding/json"
+	"log"
 	"net/http"
 	"strconv"
 	"time"
@@ -32,7 +33,7 @@ type HealthcheckResponse struct {
 }
 
 func executeHealthCheck(ctx context.Context, awsClient service.AWS) error {
-	_, err := awsClient.S3get(ctx, config.Config.S3Bucket, config.Config.HealthCheckPath, nil)
+	_, err := awsClient.S3get(ctx, config.Config.S3Bucket, config.Config.HealthCheckPath, nil, nil)
 
 	metrics.UpdateS3Reads(err, metrics.GetObjectAction, metrics.HealthcheckSource)
 	// if file exists, return ok
@@ -68,7 +69,13 @@ func HealthcheckHandler(w http.ResponseWriter, req *http.Request) {
 	if err == nil {
 		httpRes.S3Bucket.Healthy = true
 	} else {
-		httpRes.S3Bucket.Error = err.Error()
+		// The endpoint is not authenticated, only the error code goes out
+		log.Printf("[healthcheck] %v", err)
+		httpRes.S3Bucket.Error = "unhealthy"
+		var ae smithy.APIError
+		if errors.As(err, &ae) {
+			httpRes.S3Bucket.Error = ae.ErrorCode()
+		}
 	}
 	// marshal response
 	body, err := json.Marshal(httpRes)
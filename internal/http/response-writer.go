@@ -3,25 +3,167 @@ package http
 import (
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
 )
 
 type custom struct {
 	io.Writer
 	http.ResponseWriter
-	status  int
-	Written int64
+	status      int
+	Written     int64
+	compression *compression // CONTENT_ENCODING of the request, applied when the response starts
+	wroteHeader bool         // the status is sent with the headers once the response starts
+	started     bool
+	closed      bool
+	buffer      []byte // the start of a body of unknown length, held until it reaches COMPRESSION_MIN_SIZE
+	compressor  compressor
+	aborted     bool // the handler gave up on the response, which is dropped rather than ended
 }
 
 func (c *custom) Write(b []byte) (int, error) {
 	if c.Header().Get("Content-Type") == "" {
 		c.Header().Set("Content-Type", http.DetectContentType(b))
 	}
+	if !c.started && c.waiting(len(b)) {
+		c.buffer = append(c.buffer, b...)
+		c.Written += int64(len(b))
+		return len(b), nil
+	}
+	c.start()
 	n, err := c.Writer.Write(b)
 	c.Written += int64(n)
 	return n, err
 }
 
 func (c *custom) WriteHeader(status int) {
-	c.ResponseWriter.WriteHeader(status)
+	if c.started || (status >= 100 && status < 200) {
+		c.ResponseWriter.WriteHeader(status)
+		return
+	}
 	c.status = status
+	c.wroteHeader = true
+	if !c.waiting(0) {
+		c.start()
+	}
+}
+
+// waiting reports if the response is held back until its Content-Type is sniffed from the body,
+// or until a body of unknown length reaches COMPRESSION_MIN_SIZE
+func (c *custom) waiting(n int) bool {
+	if !c.compressing() {
+		return false
+	}
+	contentType := c.Header().Get("Content-Type")
+	if len(contentType) == 0 {
+		return true
+	}
+	return c.compression.compressible(contentType) && len(c.Header().Get("Content-Length")) == 0 &&
+		int64(len(c.buffer)+n) < c.compression.minSize
+}
+
+// compressing reports if the response could be compressed with the encoding negotiated, before its type and size are known.
+// Bodies the handler encoded already, like a precompressed object or a ZIP member passed through, and ranges are left alone.
+func (c *custom) compressing() bool {
+	return c.compression != nil && len(c.compression.encoding) > 0 &&
+		c.status != http.StatusNoContent && c.status != http.StatusPartialContent && c.status != http.StatusNotModified &&
+		len(c.Header().Get("Content-Encoding")) == 0 && len(c.Header().Get("Content-Range")) == 0 &&
+		!strings.Contains(c.Header().Get("Cache-Control"), "no-transform")
+}
+
+// size returns the length of the body, or -1 while it is still streaming
+func (c *custom) size() int64 {
+	if length, err := strconv.ParseInt(c.Header().Get("Content-Length"), 10, 64); err == nil {
+		return length
+	}
+	if c.closed {
+		return int64(len(c.buffer))
+	}
+	return -1
+}
+
+// start sends the headers, compressing the body when its type and size allow it
+func (c *custom) start() {
+	if c.started {
+		return
+	}
+	c.started = true
+	if c.compression != nil && len(c.Header().Get("Content-Encoding")) == 0 &&
+		c.compression.compressible(c.Header().Get("Content-Type")) {
+		// Compressed or not depending on Accept-Encoding
+		if !varies(c.Header(), "Accept-Encoding") {
+			c.Header().Add("Vary", "Accept-Encoding")
+		}
+		if size := c.size(); c.compressing() && (size < 0 || (size > 0 && size >= c.compression.minSize)) {
+			c.compress()
+		}
+	}
+	if c.wroteHeader {
+		c.ResponseWriter.WriteHeader(c.status)
+	}
+	if len(c.buffer) > 0 {
+		_, _ = c.Writer.Write(c.buffer)
+		c.buffer = nil
+	}
+}
+
+// compress sets Content-Encoding and writes the body through a compressor
+func (c *custom) compress() {
+	if !c.compression.head {
+		zw, err := encoders[c.compression.encoding].get(c.ResponseWriter, c.compression.level)
+		if err != nil {
+			return
+		}
+		c.compressor = zw
+		c.Writer = zw
+	}
+	c.Header().Set("Content-Encoding", c.compression.encoding)
+	c.Header().Del("Content-Length")
+	// Other bytes than the object's, so its ETag only weakly matches them and If-Range or If-Match never do
+	if etag := c.Header().Get("ETag"); len(etag) > 0 && !strings.HasPrefix(etag, "W/") {
+		c.Header().Set("ETag", "W/"+etag)
+	}
+}
+
+// Close sends a body held back and ends the compressed one
+func (c *custom) Close() error {
+	c.closed = true
+	if c.aborted {
+		// Ending the compressed body would make it pass for complete
+		c.compressor = nil
+		return nil
+	}
+	if c.wroteHeader || len(c.buffer) > 0 {
+		c.start()
+	}
+	if c.compressor == nil {
+		return nil
+	}
+	err := c.compressor.Close()
+	encoders[c.compression.encoding].put(c.compressor, c.compression.level)
+	c.compressor = nil
+	return err
+}
+
+// Flush sends what was written so far, through the compressor when there is one
+func (c *custom) Flush() {
+	c.start()
+	if f, ok := c.Writer.(interface{ Flush() error }); ok {
+		_ = f.Flush()
+	}
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// varies reports if the Vary header lists a request header already
+func varies(h http.Header, name string) bool {
+	for _, value := range h.Values("Vary") {
+		for _, field := range strings.Split(value, ",") {
+			if field = strings.TrimSpace(field); field == "*" || strings.EqualFold(field, name) {
+				return true
+			}
+		}
+	}
+	return false
 }
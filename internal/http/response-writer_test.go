@@ -1,9 +1,16 @@
 package http
 
 import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -47,3 +54,185 @@ func TestWriteHeader(t *testing.T) {
 	assert.Equal(t, expected, c.status)
 	assert.Equal(t, expected, w.Result().StatusCode)
 }
+
+func TestFlushCompressed(t *testing.T) {
+	w := httptest.NewRecorder()
+	g := gzip.NewWriter(w)
+	c := custom{Writer: g, ResponseWriter: w}
+	_, _ = c.Write([]byte("hello"))
+	written := w.Body.Len()
+
+	c.Flush()
+	assert.True(t, w.Flushed)
+	assert.Greater(t, w.Body.Len(), written)
+}
+
+// textGzip compresses text of any size with gzip
+func textGzip() *compression {
+	return &compression{encoding: "gzip", level: gzip.DefaultCompression, types: []string{"text/*"}}
+}
+
+func TestWriteCompressesLazily(t *testing.T) {
+	w := httptest.NewRecorder()
+	c := custom{Writer: w, ResponseWriter: w, status: http.StatusOK, compression: textGzip()}
+	c.Header().Set("Content-Length", "5")
+	c.Header().Set("ETag", `"abc"`)
+	_, _ = c.Write([]byte("hello"))
+	_ = c.Close()
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.Empty(t, w.Header().Get("Content-Length"))
+	// The object's strong ETag does not name the compressed bytes
+	assert.Equal(t, `W/"abc"`, w.Header().Get("ETag"))
+	g, err := gzip.NewReader(w.Body)
+	assert.NoError(t, err)
+	body, _ := io.ReadAll(g)
+	assert.Equal(t, "hello", string(body))
+
+	// Bodies the handler encoded pass through
+	w = httptest.NewRecorder()
+	c = custom{Writer: w, ResponseWriter: w, status: http.StatusOK, compression: textGzip()}
+	c.Header().Set("Content-Encoding", "br")
+	c.Header().Set("ETag", `"def"`)
+	_, _ = c.Write([]byte("encoded"))
+	_ = c.Close()
+	assert.Equal(t, "br", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, `"def"`, w.Header().Get("ETag"))
+	assert.Equal(t, "encoded", w.Body.String())
+
+	// Not Modified has no body to compress
+	w = httptest.NewRecorder()
+	c = custom{Writer: w, ResponseWriter: w, status: http.StatusOK, compression: textGzip()}
+	c.WriteHeader(http.StatusNotModified)
+	_ = c.Close()
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, 0, w.Body.Len())
+}
+
+func TestWriteEncoders(t *testing.T) {
+	body := strings.Repeat("compressed on the fly ", 100)
+	decoders := map[string]func(r io.Reader) (io.Reader, error){
+		"br": func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil },
+		"zstd": func(r io.Reader) (io.Reader, error) {
+			return zstd.NewReader(r)
+		},
+		"gzip": func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		"deflate": func(r io.Reader) (io.Reader, error) {
+			return zlib.NewReader(r)
+		},
+	}
+	for encoding, decoder := range decoders {
+		// Twice, the second compressor comes from the pool
+		for range 2 {
+			w := httptest.NewRecorder()
+			p := textGzip()
+			p.encoding = encoding
+			p.level = encoders[encoding].level
+			c := custom{Writer: w, ResponseWriter: w, status: http.StatusOK, compression: p}
+			_, _ = c.Write([]byte(body))
+			assert.NoError(t, c.Close())
+			assert.Equal(t, encoding, w.Header().Get("Content-Encoding"))
+			assert.Less(t, w.Body.Len(), len(body), encoding)
+			r, err := decoder(w.Body)
+			assert.NoError(t, err, encoding)
+			decoded, _ := io.ReadAll(r)
+			assert.Equal(t, body, string(decoded), encoding)
+		}
+	}
+}
+
+func TestWriteMinSize(t *testing.T) {
+	p := textGzip()
+	p.minSize = 10
+
+	// Held back until it is known to be too small
+	w := httptest.NewRecorder()
+	c := custom{Writer: w, ResponseWriter: w, status: http.StatusOK, compression: p}
+	c.WriteHeader(http.StatusOK)
+	_, _ = c.Write([]byte("tiny"))
+	assert.Equal(t, 0, w.Body.Len())
+	_ = c.Close()
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+	assert.Equal(t, "tiny", w.Body.String())
+	assert.Equal(t, int64(4), c.Written)
+
+	// Compressed once it grows past it
+	w = httptest.NewRecorder()
+	c = custom{Writer: w, ResponseWriter: w, status: http.StatusOK, compression: p}
+	_, _ = c.Write([]byte("hello "))
+	_, _ = c.Write([]byte("world"))
+	_ = c.Close()
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	g, err := gzip.NewReader(w.Body)
+	assert.NoError(t, err)
+	body, _ := io.ReadAll(g)
+	assert.Equal(t, "hello world", string(body))
+
+	// Or right away with its Content-Length
+	w = httptest.NewRecorder()
+	c = custom{Writer: w, ResponseWriter: w, status: http.StatusOK, compression: p}
+	c.Header().Set("Content-Type", "text/plain")
+	c.Header().Set("Content-Length", "5")
+	c.WriteHeader(http.StatusOK)
+	assert.True(t, c.started)
+	_, _ = c.Write([]byte("hello"))
+	_ = c.Close()
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "5", w.Header().Get("Content-Length"))
+
+	// A flushed stream is compressed whatever its size
+	w = httptest.NewRecorder()
+	c = custom{Writer: w, ResponseWriter: w, status: http.StatusOK, compression: p}
+	_, _ = c.Write([]byte("event"))
+	c.Flush()
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	_ = c.Close()
+}
+
+func TestWriteSkipsRangesAndTypes(t *testing.T) {
+	w := httptest.NewRecorder()
+	c := custom{Writer: w, ResponseWriter: w, status: http.StatusOK, compression: textGzip()}
+	c.Header().Set("Content-Type", "text/plain")
+	c.Header().Set("Content-Range", "bytes 0-4/11")
+	c.WriteHeader(http.StatusPartialContent)
+	_, _ = c.Write([]byte("hello"))
+	_ = c.Close()
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "hello", w.Body.String())
+
+	// Compressed already
+	w = httptest.NewRecorder()
+	c = custom{Writer: w, ResponseWriter: w, status: http.StatusOK, compression: textGzip()}
+	c.Header().Set("Content-Type", "image/jpeg")
+	_, _ = c.Write([]byte("\xff\xd8\xff"))
+	_ = c.Close()
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Empty(t, w.Header().Get("Vary"))
+
+	// Denied by COMPRESSION_SKIP_TYPES
+	p := textGzip()
+	p.skipTypes = []string{"text/event-stream"}
+	w = httptest.NewRecorder()
+	c = custom{Writer: w, ResponseWriter: w, status: http.StatusOK, compression: p}
+	c.Header().Set("Content-Type", "text/event-stream")
+	_, _ = c.Write([]byte("data: 1\n\n"))
+	_ = c.Close()
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+}
+
+func TestWriteHead(t *testing.T) {
+	p := textGzip()
+	p.head = true
+	w := httptest.NewRecorder()
+	c := custom{Writer: w, ResponseWriter: w, status: http.StatusOK, compression: p}
+	c.Header().Set("Content-Type", "text/html")
+	c.Header().Set("Content-Length", "2048")
+	c.Header().Set("ETag", `W/"abc"`)
+	c.WriteHeader(http.StatusOK)
+	_ = c.Close()
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, `W/"abc"`, w.Header().Get("ETag"))
+	assert.Empty(t, w.Header().Get("Content-Length"))
+	assert.Equal(t, 0, w.Body.Len())
+}
@@ -0,0 +1,144 @@
+// Package httperr writes error responses that never show internal details to clients.
+// Details are logged with a correlation ID, which is also sent in the X-Request-Id header
+// and the response body so a report from a user can be matched with the log.
+package httperr
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"html"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// RequestIDHeader carries the correlation ID of a request
+const RequestIDHeader = "X-Request-Id"
+
+// maxRequestIDLength bounds the IDs accepted from clients and proxies in front of us
+const maxRequestIDLength = 128
+
+type contextKey struct{}
+
+// WithRequestID takes the correlation ID of the request from an upstream proxy, or creates one,
+// and sends it back in the response headers. Handlers find it in the returned request.
+func WithRequestID(w http.ResponseWriter, r *http.Request) *http.Request {
+	id := r.Header.Get(RequestIDHeader)
+	if !validRequestID(id) {
+		id = newRequestID()
+	}
+	w.Header().Set(RequestIDHeader, id)
+	return r.WithContext(context.WithValue(r.Context(), contextKey{}, id))
+}
+
+// RequestID returns the correlation ID stored by WithRequestID
+func RequestID(r *http.Request) string {
+	id, _ := r.Context().Value(contextKey{}).(string)
+	return id
+}
+
+// Write logs detail and answers with the generic text of the status code.
+// detail may hold anything, like AWS request IDs and bucket names, it is never sent.
+func Write(w http.ResponseWriter, r *http.Request, code int, detail string) {
+	id := requestID(w, r)
+	if len(detail) > 0 {
+		log.Printf("[error] %s %d %s %s: %s", id, code, r.Method, r.URL.Path, detail)
+	}
+	write(w, r, id, code, http.StatusText(code))
+}
+
+// WriteMessage answers with a message meant for the client, it must not hold internal details
+func WriteMessage(w http.ResponseWriter, r *http.Request, code int, message string) {
+	write(w, r, requestID(w, r), code, message)
+}
+
+func requestID(w http.ResponseWriter, r *http.Request) string {
+	if id := RequestID(r); len(id) > 0 {
+		return id
+	}
+	id := newRequestID()
+	w.Header().Set(RequestIDHeader, id)
+	return id
+}
+
+func write(w http.ResponseWriter, r *http.Request, id string, code int, message string) {
+	var body string
+	switch negotiate(r.Header.Get("Accept")) {
+	case "application/json":
+		b, _ := json.Marshal(struct {
+			Status    int    `json:"status"`
+			Error     string `json:"error"`
+			RequestID string `json:"request_id"`
+		}{code, message, id})
+		body = string(b) + "\n"
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	case "text/html":
+		title := html.EscapeString(strconv.Itoa(code) + " " + http.StatusText(code))
+		body = "<!DOCTYPE html><html><head><meta name=\"viewport\" content=\"width=device-width, initial-scale=1\"><title>" + title + "</title></head>" +
+			"<body><h1>" + title + "</h1><p>" + html.EscapeString(message) + "</p><p>Request ID: " + html.EscapeString(id) + "</p></body></html>"
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	default:
+		body = message + "\nRequest ID: " + id + "\n"
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	// Like http.Error, Content-Encoding stays for the compressing writer of WrapHandler
+	w.Header().Del("Content-Length")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	if r.Method != http.MethodHead {
+		_, _ = w.Write([]byte(body))
+	}
+}
+
+// negotiate picks the error format with the highest quality in an Accept header,
+// plain text when nothing else is preferred
+func negotiate(accept string) string {
+	best, bestQ := "text/plain", 0.0
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(mediaRange)
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		format := ""
+		switch mediaType {
+		case "application/json", "application/problem+json":
+			format = "application/json"
+		case "text/html", "application/xhtml+xml":
+			format = "text/html"
+		case "text/plain", "text/*", "*/*":
+			format = "text/plain"
+		}
+		if len(format) > 0 && q > bestQ {
+			best, bestQ = format, q
+		}
+	}
+	return best
+}
+
+func validRequestID(id string) bool {
+	if len(id) == 0 || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,67 @@
+package httperr
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiate(t *testing.T) {
+	assert.Equal(t, "text/plain", negotiate(""))
+	assert.Equal(t, "text/plain", negotiate("*/*"))
+	assert.Equal(t, "application/json", negotiate("application/json"))
+	assert.Equal(t, "text/html", negotiate("text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"))
+	assert.Equal(t, "application/json", negotiate("text/html;q=0.5, application/json"))
+	assert.Equal(t, "text/plain", negotiate("image/png"))
+}
+
+func TestWriteHidesDetail(t *testing.T) {
+	r, _ := http.NewRequest("GET", "/missing", nil)
+	r.Header.Set("Accept", "application/json")
+	rr := httptest.NewRecorder()
+	r = WithRequestID(rr, r)
+	Write(rr, r, http.StatusNotFound, "operation error S3: GetObject, RequestID: ABC123, bucket secret-bucket")
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Equal(t, "application/json; charset=utf-8", rr.Header().Get("Content-Type"))
+	assert.NotContains(t, rr.Body.String(), "ABC123")
+	assert.NotContains(t, rr.Body.String(), "secret-bucket")
+
+	body := struct {
+		Status    int    `json:"status"`
+		Error     string `json:"error"`
+		RequestID string `json:"request_id"`
+	}{}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &body))
+	assert.Equal(t, http.StatusNotFound, body.Status)
+	assert.Equal(t, "Not Found", body.Error)
+	assert.Equal(t, rr.Header().Get(RequestIDHeader), body.RequestID)
+	assert.Len(t, body.RequestID, 32)
+}
+
+func TestWriteMessageHTML(t *testing.T) {
+	r, _ := http.NewRequest("POST", "/uploads/", nil)
+	r.Header.Set("Accept", "text/html")
+	rr := httptest.NewRecorder()
+	WriteMessage(rr, r, http.StatusBadRequest, "File type not allowed: <script>.exe")
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "File type not allowed: &lt;script&gt;.exe")
+	assert.Contains(t, rr.Body.String(), rr.Header().Get(RequestIDHeader))
+}
+
+func TestWithRequestID(t *testing.T) {
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.Header.Set(RequestIDHeader, "upstream-id.1")
+	rr := httptest.NewRecorder()
+	assert.Equal(t, "upstream-id.1", RequestID(WithRequestID(rr, r)))
+	assert.Equal(t, "upstream-id.1", rr.Header().Get(RequestIDHeader))
+
+	// Not echoed when it could inject into headers or the log
+	r.Header.Set(RequestIDHeader, "bad id\n")
+	rr = httptest.NewRecorder()
+	assert.Len(t, RequestID(WithRequestID(rr, r)), 32)
+}
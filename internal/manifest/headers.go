@@ -0,0 +1,98 @@
+package manifest
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// HeaderRule holds the headers of a path block in a _headers file
+type HeaderRule struct {
+	Path    string // * matches anything and :placeholders a segment
+	Headers http.Header
+	pattern *regexp.Regexp
+}
+
+// ParseHeaders reads the path blocks of a _headers file, lines it cannot use are reported in the error
+func ParseHeaders(r io.Reader) ([]HeaderRule, error) {
+	var rules []HeaderRule
+	var errs []error
+	// Headers of an invalid path are dropped with it
+	block := -1
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		text := scanner.Text()
+		line := strings.TrimSpace(text)
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// Paths start a block, the indented lines below are its headers
+		if text[0] != ' ' && text[0] != '\t' {
+			block = -1
+			if !strings.HasPrefix(line, "/") {
+				errs = append(errs, fmt.Errorf("_headers line %d: '%s' is not a path", n, line))
+				continue
+			}
+			rules = append(rules, HeaderRule{Path: line, Headers: http.Header{}, pattern: pathPattern(line)})
+			block = len(rules) - 1
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if !ok || len(key) == 0 {
+			errs = append(errs, fmt.Errorf("_headers line %d: invalid header '%s'", n, line))
+			continue
+		}
+		if block >= 0 {
+			rules[block].Headers.Add(key, value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	return rules, errors.Join(errs...)
+}
+
+// pathPattern turns a _headers path into an anchored regular expression
+func pathPattern(path string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+	for i, segment := range strings.Split(path, "/") {
+		if i > 0 {
+			b.WriteString("/")
+		}
+		if name, ok := strings.CutPrefix(segment, ":"); ok && len(name) > 0 {
+			b.WriteString("[^/]+")
+			continue
+		}
+		b.WriteString(strings.ReplaceAll(regexp.QuoteMeta(segment), `\*`, ".*"))
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+// Match reports if the rule applies to path
+func (rule HeaderRule) Match(path string) bool {
+	return rule.pattern != nil && rule.pattern.MatchString(path)
+}
+
+// HeadersFor collects the headers of every rule matching path, values for the same header are joined
+func HeadersFor(rules []HeaderRule, path string) http.Header {
+	headers := http.Header{}
+	for _, rule := range rules {
+		if !rule.Match(path) {
+			continue
+		}
+		for key, values := range rule.Headers {
+			headers[key] = append(headers[key], values...)
+		}
+	}
+	for key, values := range headers {
+		headers[key] = []string{strings.Join(values, ", ")}
+	}
+	return headers
+}
@@ -0,0 +1,105 @@
+package manifest
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRedirects(t *testing.T) {
+	rules, err := ParseRedirects(strings.NewReader(`
+# Comments and blank lines are skipped
+/home              /
+/blog/:year/:slug  /posts/:year-:slug  302
+/news/*            /blog/:splat        301!
+/store id=:id      /products/:id
+/app/*             /app/index.html     200
+/api/*             https://api.example.com/:splat  200
+/fr/*              /fr/404.html        404 Country=fr
+`))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "line 8")
+	assert.Contains(t, err.Error(), "line 9")
+	assert.Equal(t, []Redirect{
+		{From: "/home", To: "/", Status: 301},
+		{From: "/blog/:year/:slug", To: "/posts/:year-:slug", Status: 302},
+		{From: "/news/*", To: "/blog/:splat", Status: 301, Force: true},
+		{From: "/store", Query: map[string]string{"id": ":id"}, To: "/products/:id", Status: 301},
+		{From: "/app/*", To: "/app/index.html", Status: 200},
+	}, rules)
+}
+
+func TestRedirectMatch(t *testing.T) {
+	rule := Redirect{From: "/blog/:year/:slug", To: "/posts/:year-:slug"}
+	target, ok := rule.Match("/blog/2024/hello/", nil)
+	assert.True(t, ok)
+	assert.Equal(t, "/posts/2024-hello", target)
+	_, ok = rule.Match("/blog/2024", nil)
+	assert.False(t, ok)
+
+	rule = Redirect{From: "/news/*", To: "/blog/:splat"}
+	target, ok = rule.Match("/news/2024/01/post.html", nil)
+	assert.True(t, ok)
+	assert.Equal(t, "/blog/2024/01/post.html", target)
+	target, ok = rule.Match("/news", nil)
+	assert.True(t, ok)
+	assert.Equal(t, "/blog/", target)
+	_, ok = rule.Match("/newsletter", nil)
+	assert.False(t, ok)
+
+	rule = Redirect{From: "/store", Query: map[string]string{"id": ":id"}, To: "/products/:id"}
+	_, ok = rule.Match("/store", url.Values{})
+	assert.False(t, ok)
+	target, ok = rule.Match("/store", url.Values{"id": {"42"}})
+	assert.True(t, ok)
+	assert.Equal(t, "/products/42", target)
+
+	// Placeholders cannot turn a path into a protocol-relative URL
+	rule = Redirect{From: "/old/*", To: "/:splat", Status: 301}
+	for _, path := range []string{"/old//evil.com", "/old/\\evil.com", "/old///evil.com/x"} {
+		target, ok = rule.Match(path, nil)
+		assert.True(t, ok, path)
+		assert.False(t, strings.HasPrefix(target, "//") || strings.HasPrefix(target, "/\\"), target)
+	}
+	rule = Redirect{From: "/go", Query: map[string]string{"to": ":to"}, To: "/:to", Status: 302}
+	target, _ = rule.Match("/go", url.Values{"to": {"//evil.com"}})
+	assert.Equal(t, "/evil.com", target)
+	rule = Redirect{From: "/docs/*", To: "https://docs.example.com/:splat", Status: 301}
+	target, _ = rule.Match("/docs/a", nil)
+	assert.Equal(t, "https://docs.example.com/a", target)
+}
+
+func TestParseHeaders(t *testing.T) {
+	rules, err := ParseHeaders(strings.NewReader(`
+/*
+  X-Frame-Options: DENY
+  Link: </style.css>; rel=preload
+  Link: </app.js>; rel=preload
+/assets/*
+  Cache-Control: public, max-age=31536000, immutable
+/docs/:page/print
+  X-Robots-Tag: noindex
+`))
+	assert.NoError(t, err)
+	assert.Len(t, rules, 3)
+
+	headers := HeadersFor(rules, "/assets/app.js")
+	assert.Equal(t, "DENY", headers.Get("X-Frame-Options"))
+	assert.Equal(t, "</style.css>; rel=preload, </app.js>; rel=preload", headers.Get("Link"))
+	assert.Equal(t, "public, max-age=31536000, immutable", headers.Get("Cache-Control"))
+
+	headers = HeadersFor(rules, "/docs/intro/print")
+	assert.Equal(t, "noindex", headers.Get("X-Robots-Tag"))
+	assert.Equal(t, http.Header{"X-Frame-Options": {"DENY"}, "Link": {"</style.css>; rel=preload, </app.js>; rel=preload"}},
+		HeadersFor(rules, "/docs/intro/page/print"))
+}
+
+func TestParseHeadersErrors(t *testing.T) {
+	rules, err := ParseHeaders(strings.NewReader("assets/*\n  Cache-Control: no-cache\n/ok\n  no colon\n  X-Ok: yes\n"))
+	assert.Error(t, err)
+	assert.Len(t, rules, 1)
+	assert.Equal(t, "yes", rules[0].Headers.Get("X-Ok"))
+}
@@ -0,0 +1,153 @@
+// Package manifest parses the Netlify style _redirects and _headers files a site deploys with its content
+package manifest
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Redirect is a rule of a _redirects file
+type Redirect struct {
+	From   string            // path, :placeholders match a segment and a trailing * the rest
+	Query  map[string]string // query parameters the request must have, :placeholder values match any
+	To     string            // path or URL, with the :placeholders and :splat of From
+	Status int               // 3xx redirects, 200 and others rewrite
+	Force  bool              // applies even when an object exists at From
+}
+
+// ParseRedirects reads the rules of a _redirects file. Lines it cannot use, like the ones
+// with country or role conditions, are skipped and reported in the error.
+func ParseRedirects(r io.Reader) ([]Redirect, error) {
+	var rules []Redirect
+	var errs []error
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule, err := parseRedirect(strings.Fields(line))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("_redirects line %d: %w", n, err))
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	return rules, errors.Join(errs...)
+}
+
+func parseRedirect(fields []string) (Redirect, error) {
+	rule := Redirect{From: fields[0], Status: 301}
+	if !strings.HasPrefix(rule.From, "/") {
+		return rule, fmt.Errorf("'%s' is not a path", rule.From)
+	}
+	i := 1
+	for ; i < len(fields) && !isTarget(fields[i]); i++ {
+		key, value, ok := strings.Cut(fields[i], "=")
+		if !ok {
+			return rule, fmt.Errorf("unexpected '%s'", fields[i])
+		}
+		if rule.Query == nil {
+			rule.Query = map[string]string{}
+		}
+		rule.Query[key] = value
+	}
+	if i == len(fields) {
+		return rule, errors.New("missing target")
+	}
+	rule.To = fields[i]
+	i++
+	if i < len(fields) {
+		status := fields[i]
+		status, rule.Force = strings.CutSuffix(status, "!")
+		code, err := strconv.Atoi(status)
+		if err != nil || code < 200 || code > 599 {
+			return rule, fmt.Errorf("invalid status '%s'", fields[i])
+		}
+		rule.Status = code
+		i++
+	}
+	if i < len(fields) {
+		return rule, fmt.Errorf("conditions are not supported: %s", strings.Join(fields[i:], " "))
+	}
+	if !strings.HasPrefix(rule.To, "/") && (rule.Status < 300 || rule.Status > 399) {
+		return rule, fmt.Errorf("proxying to '%s' is not supported", rule.To)
+	}
+	return rule, nil
+}
+
+func isTarget(field string) bool {
+	return strings.HasPrefix(field, "/") || strings.HasPrefix(field, "http://") || strings.HasPrefix(field, "https://")
+}
+
+// Match returns the target of the rule for a request, with the placeholders filled in
+func (rule Redirect) Match(path string, query url.Values) (string, bool) {
+	values := map[string]string{}
+	from := splitPath(rule.From)
+	segments := splitPath(path)
+	for i, pattern := range from {
+		if pattern == "*" && i == len(from)-1 {
+			values["splat"] = strings.Join(segments[min(i, len(segments)):], "/")
+			segments = segments[:min(i, len(segments))]
+			from = from[:i]
+			break
+		}
+	}
+	if len(from) != len(segments) {
+		return "", false
+	}
+	for i, pattern := range from {
+		if name, ok := strings.CutPrefix(pattern, ":"); ok && len(name) > 0 {
+			values[name] = segments[i]
+		} else if pattern != segments[i] {
+			return "", false
+		}
+	}
+	for key, pattern := range rule.Query {
+		if !query.Has(key) {
+			return "", false
+		}
+		if name, ok := strings.CutPrefix(pattern, ":"); ok && len(name) > 0 {
+			values[name] = query.Get(key)
+		} else if pattern != query.Get(key) {
+			return "", false
+		}
+	}
+	target := fill(rule.To, values)
+	if strings.HasPrefix(rule.To, "/") {
+		// A path stays on the site, //host or /\host from a placeholder would leave it
+		target = "/" + strings.TrimLeft(target, "/\\")
+	}
+	return target, true
+}
+
+// fill replaces the placeholders of a target, longer names first so :id does not break :idx
+func fill(target string, values map[string]string) string {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return len(names[i]) > len(names[j]) })
+	for _, name := range names {
+		target = strings.ReplaceAll(target, ":"+name, values[name])
+	}
+	return target
+}
+
+// splitPath splits a path in its segments, a trailing slash does not count
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if len(path) == 0 {
+		return []string{}
+	}
+	return strings.Split(path, "/")
+}
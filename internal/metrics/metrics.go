@@ -11,6 +11,8 @@ import (
 const (
 	GetObjectAction     = "GetObject"
 	ListObjectAction    = "ListObject"
+	PutObjectAction     = "PutObject"
+	DeleteObjectAction  = "DeleteObject"
 	UnknownS3Error      = "UnknownS3Error"
 	DefaultResponseCode = "OK"
 	HealthcheckSource   = "healthcheck"
@@ -26,6 +28,10 @@ var (
 		Name: "s3_http_requests_total",
 		Help: "s3 response codes",
 	}, []string{"action", "responseCode", "source"})
+	S3Writes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "s3_http_write_requests_total",
+		Help: "s3 write response codes",
+	}, []string{"action", "responseCode", "source"})
 )
 
 /*
@@ -33,8 +39,20 @@ UpdateS3Reads receives the AWS error, action and source
 and updates the s3_http_requests_total custom metric
 */
 func UpdateS3Reads(err error, action, source string) {
+	updateS3Requests(S3Reads, err, action, source)
+}
+
+/*
+UpdateS3Writes receives the AWS error, action and source
+and updates the s3_http_write_requests_total custom metric
+*/
+func UpdateS3Writes(err error, action, source string) {
+	updateS3Requests(S3Writes, err, action, source)
+}
+
+func updateS3Requests(counter *prometheus.CounterVec, err error, action, source string) {
 	if err == nil {
-		S3Reads.WithLabelValues(
+		counter.WithLabelValues(
 			action,
 			DefaultResponseCode,
 			source,
@@ -43,14 +61,14 @@ func UpdateS3Reads(err error, action, source string) {
 	}
 	var ae smithy.APIError
 	if errors.As(err, &ae) {
-		S3Reads.WithLabelValues(
+		counter.WithLabelValues(
 			action,
 			ae.ErrorCode(),
 			source,
 		).Inc()
 		return
 	}
-	S3Reads.WithLabelValues(
+	counter.WithLabelValues(
 		action,
 		UnknownS3Error,
 		source,
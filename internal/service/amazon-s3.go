@@ -1,35 +1,56 @@
 package service
 
 import (
+	"bytes"
 	"context"
+	"crypto/md5" // nolint:gosec
+	"encoding/base64"
+	"errors"
+	"io"
 	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
 	"github.com/patrickdk77/aws-s3-proxy/internal/config"
 )
 
+// minPartSize is the smallest part S3 accepts in a multipart upload
+const minPartSize = 5 * 1024 * 1024
+
 // aws-sdk-go-v2 documents that Key must start with /, but the sdk prefixes the key with a / always anyways, breaking GetObject and HeadObject
 // listobjects does not seem to share the issue, and works with or without the leading /
 
 // S3get returns a specified object from Amazon S3
-func (c client) S3get(ctx context.Context, bucket, key string, rangeHeader *string) (*s3.GetObjectOutput, error) {
+func (c client) S3get(ctx context.Context, bucket, key string, rangeHeader *string, cond *Conditions) (*s3.GetObjectOutput, error) {
 	req := &s3.GetObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(strings.TrimLeft(key, "/")),
 		Range:  rangeHeader,
 	}
+	if cond != nil {
+		req.IfMatch = cond.IfMatch
+		req.IfNoneMatch = cond.IfNoneMatch
+		req.IfModifiedSince = cond.IfModifiedSince
+		req.IfUnmodifiedSince = cond.IfUnmodifiedSince
+	}
 	return c.Client.GetObject(ctx, req)
 }
 
 // S3head returns a specified object metadata from Amazon S3
-func (c client) S3head(ctx context.Context, bucket, key string, rangeHeader *string) (*s3.HeadObjectOutput, error) {
+func (c client) S3head(ctx context.Context, bucket, key string, rangeHeader *string, cond *Conditions) (*s3.HeadObjectOutput, error) {
 	req := &s3.HeadObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(strings.TrimLeft(key, "/")),
 		Range:  rangeHeader,
 	}
+	if cond != nil {
+		req.IfMatch = cond.IfMatch
+		req.IfNoneMatch = cond.IfNoneMatch
+		req.IfModifiedSince = cond.IfModifiedSince
+		req.IfUnmodifiedSince = cond.IfUnmodifiedSince
+	}
 	return c.Client.HeadObject(ctx, req)
 }
 
@@ -77,3 +98,137 @@ func (c client) S3listObjects(ctx context.Context, bucket, prefix string) (*s3.L
 
 	return result, nil
 }
+
+// S3listPage returns a single page of s3 objects, whatever GET_ALL_PAGES_IN_DIR says
+func (c client) S3listPage(ctx context.Context, bucket, prefix string, opts *ListOptions) (*s3.ListObjectsV2Output, error) {
+	req := &s3.ListObjectsV2Input{
+		Bucket:            aws.String(bucket),
+		Prefix:            aws.String(strings.TrimLeft(prefix, "/")),
+		Delimiter:         aws.String("/"),
+		StartAfter:        opts.StartAfter,
+		ContinuationToken: opts.ContinuationToken,
+	}
+	if opts.MaxKeys > 0 {
+		req.MaxKeys = aws.Int32(opts.MaxKeys)
+	}
+	if opts.Recursive {
+		req.Delimiter = nil
+	}
+	return c.Client.ListObjectsV2(ctx, req)
+}
+
+// S3put uploads body to Amazon S3 and returns the ETag of the new object.
+// Bodies larger than opts.PartSize are sent as a multipart upload, which is aborted on any error.
+func (c client) S3put(ctx context.Context, bucket, key string, body io.Reader, opts *PutOptions) (*string, error) {
+	partSize := opts.PartSize
+	if partSize < minPartSize {
+		partSize = minPartSize
+	}
+	hash := md5.New() // nolint:gosec
+	reader := io.TeeReader(body, hash)
+	buf := make([]byte, partSize)
+
+	n, err := io.ReadFull(reader, buf)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		// Fits into a single request, S3 checks Content-MD5 itself
+		req := &s3.PutObjectInput{
+			Bucket:             aws.String(bucket),
+			Key:                aws.String(strings.TrimLeft(key, "/")),
+			Body:               bytes.NewReader(buf[:n]),
+			ContentLength:      aws.Int64(int64(n)),
+			ContentType:        opts.ContentType,
+			ContentEncoding:    opts.ContentEncoding,
+			ContentDisposition: opts.ContentDisposition,
+			CacheControl:       opts.CacheControl,
+			ContentMD5:         opts.ContentMD5,
+			IfMatch:            opts.IfMatch,
+			IfNoneMatch:        opts.IfNoneMatch,
+		}
+		output, err := c.Client.PutObject(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		return output.ETag, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := c.Client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:             aws.String(bucket),
+		Key:                aws.String(strings.TrimLeft(key, "/")),
+		ContentType:        opts.ContentType,
+		ContentEncoding:    opts.ContentEncoding,
+		ContentDisposition: opts.ContentDisposition,
+		CacheControl:       opts.CacheControl,
+		ChecksumAlgorithm:  types.ChecksumAlgorithmCrc32,
+	})
+	if err != nil {
+		return nil, err
+	}
+	abort := func(err error) (*string, error) {
+		// Still clean up when the client went away
+		_, _ = c.Client.AbortMultipartUpload(context.WithoutCancel(ctx), &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(bucket),
+			Key:      aws.String(strings.TrimLeft(key, "/")),
+			UploadId: created.UploadId,
+		})
+		return nil, err
+	}
+
+	parts := []types.CompletedPart{}
+	for partNumber := int32(1); n > 0; partNumber++ {
+		part, err := c.Client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:            aws.String(bucket),
+			Key:               aws.String(strings.TrimLeft(key, "/")),
+			UploadId:          created.UploadId,
+			PartNumber:        aws.Int32(partNumber),
+			Body:              bytes.NewReader(buf[:n]),
+			ContentLength:     aws.Int64(int64(n)),
+			ChecksumAlgorithm: types.ChecksumAlgorithmCrc32,
+		})
+		if err != nil {
+			return abort(err)
+		}
+		parts = append(parts, types.CompletedPart{
+			ETag:          part.ETag,
+			ChecksumCRC32: part.ChecksumCRC32,
+			PartNumber:    aws.Int32(partNumber),
+		})
+		n, err = io.ReadFull(reader, buf)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return abort(err)
+		}
+	}
+
+	// S3 only validates Content-MD5 per request, so check the whole body here
+	if opts.ContentMD5 != nil && *opts.ContentMD5 != base64.StdEncoding.EncodeToString(hash.Sum(nil)) {
+		return abort(&smithy.GenericAPIError{
+			Code:    "BadDigest",
+			Message: "The Content-MD5 you specified did not match what we received.",
+		})
+	}
+
+	completed, err := c.Client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(bucket),
+		Key:             aws.String(strings.TrimLeft(key, "/")),
+		UploadId:        created.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+		IfMatch:         opts.IfMatch,
+		IfNoneMatch:     opts.IfNoneMatch,
+	})
+	if err != nil {
+		return abort(err)
+	}
+	return completed.ETag, nil
+}
+
+// S3delete removes a specified object from Amazon S3
+func (c client) S3delete(ctx context.Context, bucket, key string) error {
+	req := &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(strings.TrimLeft(key, "/")),
+	}
+	_, err := c.Client.DeleteObject(ctx, req)
+	return err
+}
@@ -2,6 +2,8 @@ package service
 
 import (
 	"context"
+	"io"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
@@ -10,10 +12,41 @@ import (
 
 // AWS is a service to interact with original AWS services
 type AWS interface {
-	S3get(ctx context.Context, bucket, key string, rangeHeader *string) (*s3.GetObjectOutput, error)
-	S3head(ctx context.Context, bucket, key string, rangeHeader *string) (*s3.HeadObjectOutput, error)
+	S3get(ctx context.Context, bucket, key string, rangeHeader *string, cond *Conditions) (*s3.GetObjectOutput, error)
+	S3head(ctx context.Context, bucket, key string, rangeHeader *string, cond *Conditions) (*s3.HeadObjectOutput, error)
 	S3exists(ctx context.Context, bucket, key string) bool
 	S3listObjects(ctx context.Context, bucket, prefix string) (*s3.ListObjectsV2Output, error)
+	S3listPage(ctx context.Context, bucket, prefix string, opts *ListOptions) (*s3.ListObjectsV2Output, error)
+	S3put(ctx context.Context, bucket, key string, body io.Reader, opts *PutOptions) (*string, error)
+	S3delete(ctx context.Context, bucket, key string) error
+}
+
+// Conditions holds the conditional request headers passed through to Amazon S3
+type Conditions struct {
+	IfMatch           *string
+	IfNoneMatch       *string
+	IfModifiedSince   *time.Time
+	IfUnmodifiedSince *time.Time
+}
+
+// ListOptions selects a page of a listing
+type ListOptions struct {
+	MaxKeys           int32
+	StartAfter        *string // key to list after
+	ContinuationToken *string // NextContinuationToken of the previous page
+	Recursive         bool    // lists the keys of every level, without directories
+}
+
+// PutOptions holds the object metadata and integrity checks of an upload
+type PutOptions struct {
+	ContentType        *string
+	ContentEncoding    *string
+	ContentDisposition *string
+	CacheControl       *string
+	ContentMD5         *string // base64 encoded, as sent in the Content-MD5 header
+	IfMatch            *string
+	IfNoneMatch        *string
+	PartSize           int64 // bodies larger than this use a multipart upload
 }
 
 type client struct {
@@ -6,7 +6,9 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/go-openapi/swag/typeutils"
@@ -45,6 +47,19 @@ func main() {
 	}
 	httpMux.Handle("/", common.WrapHandler(controllers.AwsS3))
 
+	// Reload the routing rules on SIGHUP
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := config.LoadRoutingRules(); err != nil {
+				log.Printf("%v", err)
+				continue
+			}
+			log.Printf("[config] Routing rules reloaded: %d", len(config.RoutingRules()))
+		}
+	}()
+
 	// Listen & Serve
 	addr := net.JoinHostPort(config.Config.Host, config.Config.Port)
 	log.Printf("[service] listening on %s", addr)
@@ -75,7 +90,7 @@ func validateAwsConfigurations() {
 	if len(os.Getenv("AWS_SECRET_ACCESS_KEY")) == 0 {
 		log.Print("Not defined environment variable: AWS_SECRET_ACCESS_KEY")
 	}
-	if len(os.Getenv("AWS_S3_BUCKET")) == 0 {
+	if len(os.Getenv("AWS_S3_BUCKET")) == 0 && len(config.Config.Routes) == 0 {
 		log.Fatal("Missing required environment variable: AWS_S3_BUCKET")
 	}
 	if typeutils.IsZero(config.Config.AwsRegion) {
@@ -84,6 +99,14 @@ func validateAwsConfigurations() {
 			config.Config.AwsRegion = region
 		}
 	}
+	// Buckets named after the subdomain can only use the global region
+	for _, route := range config.Config.Routes {
+		if len(route.S3Bucket) > 0 && len(route.AwsRegion) == 0 && !strings.Contains(route.S3Bucket, "{") {
+			if region, err := service.GuessBucketRegion(route.S3Bucket); err == nil {
+				route.AwsRegion = region
+			}
+		}
+	}
 }
 
 type slashFix struct {